@@ -3,9 +3,12 @@ package main
 import (
 	"flag"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang/glog"
+	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
 	clientset "github.com/openfaas-incubator/openfaas-operator/pkg/client/clientset/versioned"
 	informers "github.com/openfaas-incubator/openfaas-operator/pkg/client/informers/externalversions"
 	"github.com/openfaas-incubator/openfaas-operator/pkg/controller"
@@ -13,6 +16,7 @@ import (
 	"github.com/openfaas-incubator/openfaas-operator/pkg/signals"
 	"github.com/openfaas-incubator/openfaas-operator/pkg/version"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
@@ -74,6 +78,65 @@ func main() {
 		imagePullPolicy = corev1.PullPolicy(val)
 	}
 
+	deploymentConfig := controller.DeploymentConfig{
+		ImagePullPolicy:  imagePullPolicy,
+		SecurityBaseline: readSecurityBaseline(),
+		PatchDenylist:    controller.DefaultPatchDenylist,
+	}
+	deploymentConfig.Resources = readResourcePolicy()
+	if _, exists := os.LookupEnv("pod_patch_denylist"); exists {
+		deploymentConfig.PatchDenylist = lookupList("pod_patch_denylist")
+	}
+	// e.g. com.openfaas.security=restricted, requires get access to namespaces
+	if val, exists := os.LookupEnv("hardened_namespace_label"); exists {
+		deploymentConfig.HardenedNamespaceLabel = val
+	}
+
+	networkPolicyConfig := controller.NetworkPolicyConfig{
+		GatewayNamespaceLabels: map[string]string{"role": "openfaas-system"},
+		GatewayPodLabels:       map[string]string{"app": "gateway"},
+		CallerPodLabels:        []map[string]string{{"app": "queue-worker"}},
+	}
+	if enabled := lookupBool("network_policies"); enabled != nil {
+		networkPolicyConfig.Enabled = *enabled
+	}
+	if defaultDeny := lookupBool("network_policy_default_deny"); defaultDeny != nil {
+		networkPolicyConfig.DefaultDeny = *defaultDeny
+	}
+	if labels := lookupLabels("gateway_namespace_labels"); labels != nil {
+		networkPolicyConfig.GatewayNamespaceLabels = labels
+	}
+	if labels := lookupLabels("gateway_pod_labels"); labels != nil {
+		networkPolicyConfig.GatewayPodLabels = labels
+	}
+	if _, exists := os.LookupEnv("network_policy_callers"); exists {
+		networkPolicyConfig.CallerPodLabels = lookupLabelSets("network_policy_callers")
+	}
+	if labels := lookupLabels("ingress_controller_namespace_labels"); labels != nil {
+		networkPolicyConfig.IngressNamespaceLabels = labels
+	}
+	if labels := lookupLabels("ingress_controller_pod_labels"); labels != nil {
+		networkPolicyConfig.IngressPodLabels = labels
+	}
+
+	ingressConfig := controller.IngressConfig{
+		GatewayService:    "gateway",
+		GatewayPort:       8080,
+		RewriteAnnotation: "nginx.ingress.kubernetes.io/rewrite-target",
+	}
+	if val, exists := os.LookupEnv("ingress_gateway_service"); exists {
+		ingressConfig.GatewayService = val
+	}
+	if val := lookupInt64("ingress_gateway_port"); val != nil {
+		ingressConfig.GatewayPort = int(*val)
+	}
+	if val, exists := os.LookupEnv("ingress_class"); exists {
+		ingressConfig.Class = val
+	}
+	if val, exists := os.LookupEnv("ingress_rewrite_annotation"); exists {
+		ingressConfig.RewriteAnnotation = val
+	}
+
 	defaultResync := time.Second * 30
 
 	kubeInformerOpt := kubeinformers.WithNamespace(functionNamespace)
@@ -82,13 +145,152 @@ func main() {
 	faasInformerOpt := informers.WithNamespace(functionNamespace)
 	faasInformerFactory := informers.NewSharedInformerFactoryWithOptions(faasClient, defaultResync, faasInformerOpt)
 
-	ctrl := controller.NewController(kubeClient, faasClient, kubeInformerFactory, faasInformerFactory, imagePullPolicy)
+	ctrl := controller.NewController(kubeClient, faasClient, kubeInformerFactory, faasInformerFactory, deploymentConfig, networkPolicyConfig, ingressConfig)
 
 	go kubeInformerFactory.Start(stopCh)
 	go faasInformerFactory.Start(stopCh)
-	go server.Start(faasClient, kubeClient, kubeInformerFactory)
+	go server.Start(faasClient, kubeClient, kubeInformerFactory, faasInformerFactory, stopCh)
 
 	if err = ctrl.Run(2, stopCh); err != nil {
 		glog.Fatalf("Error running controller: %s", err.Error())
 	}
 }
+
+// readSecurityBaseline reads the security context applied to every function
+// from the security_* environment variables
+func readSecurityBaseline() *faasv1.FunctionSecurity {
+	baseline := &faasv1.FunctionSecurity{
+		RunAsNonRoot:             lookupBool("security_run_as_non_root"),
+		RunAsUser:                lookupInt64("security_run_as_user"),
+		RunAsGroup:               lookupInt64("security_run_as_group"),
+		FSGroup:                  lookupInt64("security_fs_group"),
+		AllowPrivilegeEscalation: lookupBool("security_allow_privilege_escalation"),
+		AddCapabilities:          lookupList("security_add_capabilities"),
+		DropCapabilities:         lookupList("security_drop_capabilities"),
+	}
+	if val, exists := os.LookupEnv("security_seccomp_profile"); exists {
+		baseline.SeccompProfile = val
+	}
+
+	return baseline
+}
+
+// readResourcePolicy reads the default and maximum function resources and the
+// namespace quota from the environment variables
+func readResourcePolicy() controller.ResourcePolicy {
+	policy := controller.ResourcePolicy{
+		DefaultLimits: faasv1.FunctionResources{
+			CPU:    lookupQuantity("default_limits_cpu"),
+			Memory: lookupQuantity("default_limits_memory"),
+		},
+		DefaultRequests: faasv1.FunctionResources{
+			CPU:    lookupQuantity("default_requests_cpu"),
+			Memory: lookupQuantity("default_requests_memory"),
+		},
+		MaxLimits: faasv1.FunctionResources{
+			CPU:    lookupQuantity("max_limits_cpu"),
+			Memory: lookupQuantity("max_limits_memory"),
+		},
+	}
+	if clamp := lookupBool("resources_clamp"); clamp != nil {
+		policy.Clamp = *clamp
+	}
+	if limitRange := lookupBool("limit_range"); limitRange != nil {
+		policy.LimitRange = *limitRange
+	}
+
+	// e.g. requests.cpu=8,limits.memory=16Gi,pods=100
+	if quota := lookupLabels("function_quota"); quota != nil {
+		policy.Quota = corev1.ResourceList{}
+		for name, val := range quota {
+			qty, err := resource.ParseQuantity(val)
+			if err != nil {
+				glog.Fatalf("Invalid function_quota configured: %s=%s", name, val)
+			}
+			policy.Quota[corev1.ResourceName(name)] = qty
+		}
+	}
+
+	return policy
+}
+
+func lookupBool(key string) *bool {
+	val, exists := os.LookupEnv(key)
+	if !exists {
+		return nil
+	}
+	parsedVal, err := strconv.ParseBool(val)
+	if err != nil {
+		glog.Fatalf("Invalid %s configured: %s", key, val)
+	}
+	return &parsedVal
+}
+
+func lookupInt64(key string) *int64 {
+	val, exists := os.LookupEnv(key)
+	if !exists {
+		return nil
+	}
+	parsedVal, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		glog.Fatalf("Invalid %s configured: %s", key, val)
+	}
+	return &parsedVal
+}
+
+func lookupQuantity(key string) string {
+	val, exists := os.LookupEnv(key)
+	if !exists || len(val) == 0 {
+		return ""
+	}
+	if _, err := resource.ParseQuantity(val); err != nil {
+		glog.Fatalf("Invalid %s configured: %s", key, val)
+	}
+	return val
+}
+
+func lookupList(key string) []string {
+	val, exists := os.LookupEnv(key)
+	if !exists || len(val) == 0 {
+		return nil
+	}
+	items := []string{}
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// lookupLabels reads a comma separated list of key=value pairs
+func lookupLabels(key string) map[string]string {
+	items := lookupList(key)
+	if items == nil {
+		return nil
+	}
+	return parseLabels(key, items)
+}
+
+// lookupLabelSets reads label sets separated by semicolons, e.g. app=queue-worker;app=cron
+func lookupLabelSets(key string) []map[string]string {
+	sets := []map[string]string{}
+	for _, set := range strings.Split(os.Getenv(key), ";") {
+		if set = strings.TrimSpace(set); len(set) > 0 {
+			sets = append(sets, parseLabels(key, strings.Split(set, ",")))
+		}
+	}
+	return sets
+}
+
+func parseLabels(key string, items []string) map[string]string {
+	labels := map[string]string{}
+	for _, item := range items {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || len(strings.TrimSpace(parts[0])) == 0 {
+			glog.Fatalf("Invalid %s configured: %s", key, item)
+		}
+		labels[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return labels
+}
@@ -1,7 +1,11 @@
 package v1alpha2
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // +genclient
@@ -19,18 +23,40 @@ type Function struct {
 
 // FunctionSpec is the spec for a Function resource
 type FunctionSpec struct {
-	Name                   string             `json:"name"`
-	Image                  string             `json:"image"`
-	Replicas               *int32             `json:"replicas"`
-	Handler                string             `json:"handler"`
-	Annotations            *map[string]string `json:"annotations"`
-	Labels                 *map[string]string `json:"labels"`
-	Environment            *map[string]string `json:"environment"`
-	Constraints            []string           `json:"constraints"`
-	Secrets                []string           `json:"secrets"`
-	Limits                 *FunctionResources `json:"limits"`
-	Requests               *FunctionResources `json:"requests"`
-	ReadOnlyRootFilesystem bool               `json:"readOnlyRootFilesystem"`
+	Name                   string              `json:"name"`
+	Image                  string              `json:"image"`
+	Replicas               *int32              `json:"replicas"`
+	Handler                string              `json:"handler"`
+	Annotations            *map[string]string  `json:"annotations"`
+	Labels                 *map[string]string  `json:"labels"`
+	Environment            *map[string]string  `json:"environment"`
+	Constraints            []string            `json:"constraints"`
+	Secrets                []string            `json:"secrets"`
+	Limits                 *FunctionResources  `json:"limits"`
+	Requests               *FunctionResources  `json:"requests"`
+	ReadOnlyRootFilesystem bool                `json:"readOnlyRootFilesystem"`
+	EnvironmentRefs        []FunctionEnvVar    `json:"environmentRefs,omitempty"`
+	EnvironmentFrom        []FunctionEnvFrom   `json:"environmentFrom,omitempty"`
+	Volumes                []FunctionVolume    `json:"volumes,omitempty"`
+	SecretMounts           []FunctionSecret    `json:"secretMounts,omitempty"`
+	ServiceAccount         *FunctionAccount    `json:"serviceAccount,omitempty"`
+	SecurityContext        *FunctionSecurity   `json:"securityContext,omitempty"`
+	Strategy               *FunctionStrategy   `json:"strategy,omitempty"`
+	InitContainers         []FunctionContainer `json:"initContainers,omitempty"`
+	Sidecars               []FunctionContainer `json:"sidecars,omitempty"`
+	// PodTemplatePatch is a strategic merge patch applied to the pod template
+	// of the function Deployment after all other settings
+	PodTemplatePatch *runtime.RawExtension `json:"podTemplatePatch,omitempty"`
+	// NetworkPolicy restricts the egress traffic of the function pods
+	NetworkPolicy *FunctionNetworkPolicy `json:"networkPolicy,omitempty"`
+	// Service customises the Service created for the function
+	Service *FunctionService `json:"service,omitempty"`
+	// Ingress exposes the function on a host or path of its own
+	Ingress *FunctionIngress `json:"ingress,omitempty"`
+	// Timeouts sets the watchdog timeouts and the proxy deadline of the function
+	Timeouts *FunctionTimeouts `json:"timeouts,omitempty"`
+	// RateLimit limits the invocations of the function
+	RateLimit *FunctionRateLimit `json:"rateLimit,omitempty"`
 }
 
 // FunctionResources is used to set CPU and memory limits and requests
@@ -39,9 +65,223 @@ type FunctionResources struct {
 	CPU    string `json:"cpu,omitempty"`
 }
 
+// FunctionEnvVar sets an environment variable from a Secret key, a ConfigMap key
+// or a pod field such as metadata.name, metadata.namespace or spec.nodeName
+type FunctionEnvVar struct {
+	Name            string          `json:"name"`
+	SecretKeyRef    *FunctionKeyRef `json:"secretKeyRef,omitempty"`
+	ConfigMapKeyRef *FunctionKeyRef `json:"configMapKeyRef,omitempty"`
+	FieldRef        string          `json:"fieldRef,omitempty"`
+}
+
+// FunctionKeyRef selects a key of a Secret or ConfigMap
+type FunctionKeyRef struct {
+	Name     string `json:"name"`
+	Key      string `json:"key"`
+	Optional bool   `json:"optional,omitempty"`
+}
+
+// FunctionEnvFrom imports all keys of a Secret or ConfigMap as environment variables
+type FunctionEnvFrom struct {
+	SecretRef    string `json:"secretRef,omitempty"`
+	ConfigMapRef string `json:"configMapRef,omitempty"`
+	Prefix       string `json:"prefix,omitempty"`
+	Optional     bool   `json:"optional,omitempty"`
+}
+
+// FunctionVolume mounts a ConfigMap, an emptyDir or a PersistentVolumeClaim
+// in the function container. Exactly one source must be set.
+type FunctionVolume struct {
+	Name                  string                         `json:"name"`
+	MountPath             string                         `json:"mountPath"`
+	SubPath               string                         `json:"subPath,omitempty"`
+	ReadOnly              bool                           `json:"readOnly,omitempty"`
+	ConfigMap             *FunctionConfigMapVolume       `json:"configMap,omitempty"`
+	EmptyDir              *FunctionEmptyDirVolume        `json:"emptyDir,omitempty"`
+	PersistentVolumeClaim *FunctionPersistentVolumeClaim `json:"persistentVolumeClaim,omitempty"`
+}
+
+// FunctionConfigMapVolume projects the keys of a ConfigMap as files
+type FunctionConfigMapVolume struct {
+	Name        string            `json:"name"`
+	Items       map[string]string `json:"items,omitempty"`
+	DefaultMode *int32            `json:"defaultMode,omitempty"`
+	Optional    bool              `json:"optional,omitempty"`
+}
+
+// FunctionEmptyDirVolume is a scratch directory that lives as long as the pod
+type FunctionEmptyDirVolume struct {
+	Medium    string `json:"medium,omitempty"`
+	SizeLimit string `json:"sizeLimit,omitempty"`
+}
+
+// FunctionPersistentVolumeClaim references an existing PersistentVolumeClaim
+type FunctionPersistentVolumeClaim struct {
+	ClaimName string `json:"claimName"`
+}
+
+// FunctionSecret selects which keys of a Secret are projected under
+// /var/openfaas/secrets, their target paths and file modes
+type FunctionSecret struct {
+	Name        string               `json:"name"`
+	Directory   string               `json:"directory,omitempty"`
+	Items       []FunctionSecretItem `json:"items,omitempty"`
+	DefaultMode *int32               `json:"defaultMode,omitempty"`
+	Optional    bool                 `json:"optional,omitempty"`
+}
+
+// FunctionSecretItem projects a single Secret key, the path defaults to the key name
+type FunctionSecretItem struct {
+	Key  string `json:"key"`
+	Path string `json:"path,omitempty"`
+	Mode *int32 `json:"mode,omitempty"`
+}
+
+// FunctionAccount sets the ServiceAccount the function pods run as. When Create is
+// true the operator creates and owns the account, named after the function unless
+// Name is set.
+type FunctionAccount struct {
+	Name             string   `json:"name,omitempty"`
+	Create           bool     `json:"create,omitempty"`
+	AutomountToken   *bool    `json:"automountToken,omitempty"`
+	ImagePullSecrets []string `json:"imagePullSecrets,omitempty"`
+}
+
+// FunctionSecurity holds the pod and container security settings of a function.
+// SeccompProfile accepts runtime/default, unconfined or localhost/<profile>.
+type FunctionSecurity struct {
+	RunAsNonRoot             *bool    `json:"runAsNonRoot,omitempty"`
+	RunAsUser                *int64   `json:"runAsUser,omitempty"`
+	RunAsGroup               *int64   `json:"runAsGroup,omitempty"`
+	FSGroup                  *int64   `json:"fsGroup,omitempty"`
+	AllowPrivilegeEscalation *bool    `json:"allowPrivilegeEscalation,omitempty"`
+	AddCapabilities          []string `json:"addCapabilities,omitempty"`
+	DropCapabilities         []string `json:"dropCapabilities,omitempty"`
+	SeccompProfile           string   `json:"seccompProfile,omitempty"`
+}
+
+// FunctionStrategy controls how the function Deployment rolls out new versions.
+// Type is RollingUpdate or Recreate, maxSurge and maxUnavailable take a number
+// or a percentage such as 25%.
+type FunctionStrategy struct {
+	Type                    string              `json:"type,omitempty"`
+	MaxSurge                *intstr.IntOrString `json:"maxSurge,omitempty"`
+	MaxUnavailable          *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+	MinReadySeconds         int32               `json:"minReadySeconds,omitempty"`
+	ProgressDeadlineSeconds *int32              `json:"progressDeadlineSeconds,omitempty"`
+	RevisionHistoryLimit    *int32              `json:"revisionHistoryLimit,omitempty"`
+}
+
+// FunctionContainer is an init container or a sidecar that runs in the function pod.
+// VolumeMounts reference volumes declared in the function spec by name and the function
+// secrets are only mounted when MountSecrets is true.
+type FunctionContainer struct {
+	Name            string                `json:"name"`
+	Image           string                `json:"image"`
+	Command         []string              `json:"command,omitempty"`
+	Args            []string              `json:"args,omitempty"`
+	Environment     map[string]string     `json:"environment,omitempty"`
+	EnvironmentRefs []FunctionEnvVar      `json:"environmentRefs,omitempty"`
+	VolumeMounts    []FunctionVolumeMount `json:"volumeMounts,omitempty"`
+	MountSecrets    bool                  `json:"mountSecrets,omitempty"`
+	Limits          *FunctionResources    `json:"limits,omitempty"`
+	Requests        *FunctionResources    `json:"requests,omitempty"`
+}
+
+// FunctionVolumeMount mounts a function volume in an init container or a sidecar
+type FunctionVolumeMount struct {
+	Name      string `json:"name"`
+	MountPath string `json:"mountPath"`
+	SubPath   string `json:"subPath,omitempty"`
+	ReadOnly  bool   `json:"readOnly,omitempty"`
+}
+
+// FunctionNetworkPolicy is the egress allowlist of a function. When it is set the
+// function pods can only reach DNS, if AllowDNS is true, and the listed destinations.
+type FunctionNetworkPolicy struct {
+	AllowDNS bool                 `json:"allowDNS,omitempty"`
+	Egress   []FunctionEgressRule `json:"egress,omitempty"`
+}
+
+// FunctionEgressRule allows traffic to a CIDR or to the pods of the namespaces matching
+// NamespaceLabels, optionally narrowed with PodLabels. An empty Ports list allows all ports.
+type FunctionEgressRule struct {
+	CIDR            string            `json:"cidr,omitempty"`
+	Except          []string          `json:"except,omitempty"`
+	NamespaceLabels map[string]string `json:"namespaceLabels,omitempty"`
+	PodLabels       map[string]string `json:"podLabels,omitempty"`
+	Ports           []int32           `json:"ports,omitempty"`
+}
+
+// FunctionService customises the type, labels and annotations of the function Service
+type FunctionService struct {
+	// Type is one of ClusterIP, Headless, NodePort or LoadBalancer, defaults to ClusterIP
+	Type string `json:"type,omitempty"`
+	// NodePort is the port exposed on the nodes by a NodePort or LoadBalancer Service,
+	// a port is allocated by the cluster when it's not set
+	NodePort    int32             `json:"nodePort,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// FunctionIngress is the custom domain of a function. Requests are routed either to the
+// gateway path /function/<name> or straight to the function Service.
+type FunctionIngress struct {
+	Host string `json:"host,omitempty"`
+	// Path defaults to /
+	Path string `json:"path,omitempty"`
+	// Target is gateway or service, defaults to gateway
+	Target        string            `json:"target,omitempty"`
+	TLSSecretName string            `json:"tlsSecretName,omitempty"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// FunctionTimeouts are the timeouts of a function as durations such as 500ms, 30s or 5m.
+// They are passed to the watchdog as read_timeout, write_timeout and exec_timeout and
+// the proxy stops waiting for the function after the write timeout.
+type FunctionTimeouts struct {
+	Read  string `json:"read,omitempty"`
+	Write string `json:"write,omitempty"`
+	Exec  string `json:"exec,omitempty"`
+}
+
+// FunctionRateLimit limits the invocations of a function with token buckets, a single bucket
+// for every caller or a bucket per client IP, API key or header value
+type FunctionRateLimit struct {
+	// RequestsPerSecond is the sustained rate, fractions such as 0.5 or 500m are allowed
+	RequestsPerSecond resource.Quantity `json:"requestsPerSecond"`
+	// Burst is the number of requests allowed at once, the rate rounded up when not set
+	Burst int32 `json:"burst,omitempty"`
+	// Key is global (default), ip, api-key or header:<name>
+	Key string `json:"key,omitempty"`
+}
+
 // FunctionStatus is the status for a Function resource
 type FunctionStatus struct {
-	AvailableReplicas int32 `json:"availableReplicas"`
+	AvailableReplicas int32               `json:"availableReplicas"`
+	Conditions        []FunctionCondition `json:"conditions,omitempty"`
+	// Limits and Requests are the resources of the function container after
+	// the operator defaults and maximums are applied
+	Limits   *FunctionResources `json:"limits,omitempty"`
+	Requests *FunctionResources `json:"requests,omitempty"`
+}
+
+// FunctionConditionType is a valid value for FunctionCondition.Type
+type FunctionConditionType string
+
+const (
+	// PodTemplatePatched is true when the pod template patch of the function
+	// was applied and false when it was rejected or failed
+	PodTemplatePatched FunctionConditionType = "PodTemplatePatched"
+)
+
+// FunctionCondition describes the state of a Function at a certain point
+type FunctionCondition struct {
+	Type               FunctionConditionType  `json:"type"`
+	Status             corev1.ConditionStatus `json:"status"`
+	Reason             string                 `json:"reason,omitempty"`
+	Message            string                 `json:"message,omitempty"`
+	LastTransitionTime metav1.Time            `json:"lastTransitionTime,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
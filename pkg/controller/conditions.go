@@ -0,0 +1,51 @@
+package controller
+
+import (
+	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// setFunctionCondition adds or replaces the condition of the same type and returns true when
+// the status changed. The transition time is only moved when the condition status changes.
+func setFunctionCondition(status *faasv1.FunctionStatus, condition faasv1.FunctionCondition) bool {
+	for i, existing := range status.Conditions {
+		if existing.Type != condition.Type {
+			continue
+		}
+
+		if existing.Status == condition.Status &&
+			existing.Reason == condition.Reason &&
+			existing.Message == condition.Message {
+			return false
+		}
+
+		condition.LastTransitionTime = existing.LastTransitionTime
+		if existing.Status != condition.Status {
+			condition.LastTransitionTime = metav1.Now()
+		}
+		status.Conditions[i] = condition
+		return true
+	}
+
+	condition.LastTransitionTime = metav1.Now()
+	status.Conditions = append(status.Conditions, condition)
+	return true
+}
+
+// removeFunctionCondition removes the condition of the given type and returns true
+// when the status changed
+func removeFunctionCondition(status *faasv1.FunctionStatus, conditionType faasv1.FunctionConditionType) bool {
+	conditions := []faasv1.FunctionCondition{}
+	for _, existing := range status.Conditions {
+		if existing.Type != conditionType {
+			conditions = append(conditions, existing)
+		}
+	}
+
+	if len(conditions) == len(status.Conditions) {
+		return false
+	}
+
+	status.Conditions = conditions
+	return true
+}
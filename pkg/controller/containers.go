@@ -0,0 +1,122 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+
+	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
+	appsv1beta2 "k8s.io/api/apps/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// configureContainers will add the init containers and sidecars requested in the function spec.
+// The function container stays first in the pod so the settings that only apply to the
+// function, like the read-only root filesystem, keep using Containers[0].
+//
+// This method is safe for both create and update operations.
+func configureContainers(function *faasv1.Function, deployment *appsv1beta2.Deployment, config DeploymentConfig) error {
+	names := map[string]bool{function.Spec.Name: true}
+
+	initContainers := []corev1.Container{}
+	for _, fc := range function.Spec.InitContainers {
+		container, err := makeContainer(function, fc, names, config)
+		if err != nil {
+			return fmt.Errorf("init container '%s': %v", fc.Name, err)
+		}
+		initContainers = append(initContainers, *container)
+	}
+
+	sidecars := []corev1.Container{}
+	for _, fc := range function.Spec.Sidecars {
+		container, err := makeContainer(function, fc, names, config)
+		if err != nil {
+			return fmt.Errorf("sidecar '%s': %v", fc.Name, err)
+		}
+		sidecars = append(sidecars, *container)
+	}
+
+	podSpec := &deployment.Spec.Template.Spec
+	podSpec.InitContainers = initContainers
+	podSpec.Containers = append(podSpec.Containers[:1], sidecars...)
+
+	return nil
+}
+
+func makeContainer(function *faasv1.Function, fc faasv1.FunctionContainer, names map[string]bool, config DeploymentConfig) (*corev1.Container, error) {
+	if len(fc.Name) == 0 || len(fc.Image) == 0 {
+		return nil, fmt.Errorf("name and image are required")
+	}
+	if names[fc.Name] {
+		return nil, fmt.Errorf("container name is already used")
+	}
+	names[fc.Name] = true
+
+	volumes := map[string]bool{}
+	for _, fv := range function.Spec.Volumes {
+		volumes[fv.Name] = true
+	}
+
+	mounts := []corev1.VolumeMount{}
+	for _, mount := range fc.VolumeMounts {
+		if !volumes[mount.Name] {
+			return nil, fmt.Errorf("volume '%s' is not declared in the function volumes", mount.Name)
+		}
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      mount.Name,
+			MountPath: mount.MountPath,
+			SubPath:   mount.SubPath,
+			ReadOnly:  mount.ReadOnly,
+		})
+	}
+
+	envVars := []corev1.EnvVar{}
+	keys := []string{}
+	for k := range fc.Environment {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		envVars = append(envVars, corev1.EnvVar{Name: k, Value: fc.Environment[k]})
+	}
+	envVars = appendEnvRefs(envVars, function.Spec.Name, fc.EnvironmentRefs)
+
+	resources, err := makeResourceRequirements(fc.Limits, fc.Requests)
+	if err != nil {
+		return nil, err
+	}
+	// the operator defaults and maximums apply to every container of the pod
+	if err := applyResourcePolicy(resources, config.Resources); err != nil {
+		return nil, fmt.Errorf("resources: %v", err)
+	}
+
+	return &corev1.Container{
+		Name:            fc.Name,
+		Image:           fc.Image,
+		Command:         fc.Command,
+		Args:            fc.Args,
+		Env:             envVars,
+		VolumeMounts:    mounts,
+		Resources:       *resources,
+		ImagePullPolicy: config.ImagePullPolicy,
+	}, nil
+}
+
+// mountsSecrets returns true when the function secrets must be mounted in the named container
+func mountsSecrets(function *faasv1.Function, containerName string) bool {
+	if containerName == function.Spec.Name {
+		return true
+	}
+
+	for _, fc := range function.Spec.InitContainers {
+		if fc.Name == containerName {
+			return fc.MountSecrets
+		}
+	}
+	for _, fc := range function.Spec.Sidecars {
+		if fc.Name == containerName {
+			return fc.MountSecrets
+		}
+	}
+
+	return false
+}
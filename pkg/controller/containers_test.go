@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"testing"
+
+	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func Test_newDeployment_WithInitContainersAndSidecars(t *testing.T) {
+	function := &faasv1.Function{
+		Spec: faasv1.FunctionSpec{
+			Name:                   "testfunc",
+			Image:                  "alpine:latest",
+			ReadOnlyRootFilesystem: true,
+			Secrets:                []string{"testsecret"},
+			Volumes: []faasv1.FunctionVolume{
+				{Name: "models", MountPath: "/models", EmptyDir: &faasv1.FunctionEmptyDirVolume{}},
+			},
+			InitContainers: []faasv1.FunctionContainer{
+				{
+					Name:         "fetch",
+					Image:        "alpine:latest",
+					Command:      []string{"wget", "-O", "/models/model.bin", "http://models/model.bin"},
+					VolumeMounts: []faasv1.FunctionVolumeMount{{Name: "models", MountPath: "/models"}},
+					MountSecrets: true,
+				},
+			},
+			Sidecars: []faasv1.FunctionContainer{
+				{
+					Name:        "logs",
+					Image:       "fluent/fluent-bit:latest",
+					Environment: map[string]string{"LEVEL": "info"},
+					Limits:      &faasv1.FunctionResources{Memory: "64Mi"},
+				},
+			},
+		},
+	}
+	existingSecrets := map[string]*corev1.Secret{
+		"testsecret": {Type: corev1.SecretTypeOpaque, Data: map[string][]byte{"filename": []byte("contents")}},
+	}
+
+	deployment, err := newDeployment(function, existingSecrets, DeploymentConfig{ImagePullPolicy: corev1.PullAlways})
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+
+	podSpec := deployment.Spec.Template.Spec
+	if len(podSpec.Containers) != 2 || podSpec.Containers[0].Name != "testfunc" || podSpec.Containers[1].Name != "logs" {
+		t.Fatalf("want function container followed by the sidecar, got %v", podSpec.Containers)
+	}
+	if len(podSpec.InitContainers) != 1 || podSpec.InitContainers[0].Name != "fetch" {
+		t.Fatalf("want fetch init container, got %v", podSpec.InitContainers)
+	}
+
+	if !hasMountPath(podSpec.Containers[0], secretsMountPath) || !hasMountPath(podSpec.Containers[0], "/tmp") {
+		t.Errorf("want secrets and /tmp mounted in the function container")
+	}
+	if !hasMountPath(podSpec.InitContainers[0], secretsMountPath) || !hasMountPath(podSpec.InitContainers[0], "/models") {
+		t.Errorf("want secrets and /models mounted in the init container")
+	}
+	if hasMountPath(podSpec.Containers[1], secretsMountPath) || hasMountPath(podSpec.Containers[1], "/tmp") {
+		t.Errorf("want no secrets or /tmp mount in the sidecar")
+	}
+
+	sidecar := podSpec.Containers[1]
+	if len(sidecar.Env) != 1 || sidecar.Env[0].Name != "LEVEL" {
+		t.Errorf("unexpected sidecar environment %v", sidecar.Env)
+	}
+	if memory := sidecar.Resources.Limits[corev1.ResourceMemory]; memory.String() != "64Mi" {
+		t.Errorf("want sidecar memory limit 64Mi, got %s", memory.String())
+	}
+}
+
+func Test_newDeployment_RejectsInvalidSidecars(t *testing.T) {
+	cases := map[string]faasv1.FunctionContainer{
+		"function name":    {Name: "testfunc", Image: "alpine:latest"},
+		"missing image":    {Name: "logs"},
+		"undeclared mount": {Name: "logs", Image: "alpine:latest", VolumeMounts: []faasv1.FunctionVolumeMount{{Name: "data", MountPath: "/data"}}},
+	}
+
+	for name, sidecar := range cases {
+		t.Run(name, func(t *testing.T) {
+			function := &faasv1.Function{
+				Spec: faasv1.FunctionSpec{
+					Name:     "testfunc",
+					Image:    "alpine:latest",
+					Sidecars: []faasv1.FunctionContainer{sidecar},
+				},
+			}
+
+			_, err := newDeployment(function, map[string]*corev1.Secret{}, DeploymentConfig{})
+			if err == nil {
+				t.Errorf("want error, got nil")
+			}
+		})
+	}
+}
+
+func hasMountPath(container corev1.Container, mountPath string) bool {
+	for _, mount := range container.VolumeMounts {
+		if mount.MountPath == mountPath {
+			return true
+		}
+	}
+	return false
+}
+
+func Test_newDeployment_SidecarResourcePolicy(t *testing.T) {
+	function := &faasv1.Function{
+		Spec: faasv1.FunctionSpec{
+			Name:           "testfunc",
+			Image:          "alpine:latest",
+			InitContainers: []faasv1.FunctionContainer{{Name: "fetch", Image: "alpine:latest"}},
+			Sidecars: []faasv1.FunctionContainer{
+				{Name: "logs", Image: "fluent/fluent-bit:latest", Limits: &faasv1.FunctionResources{Memory: "4Gi"}},
+			},
+		},
+	}
+
+	_, err := newDeployment(function, map[string]*corev1.Secret{}, DeploymentConfig{Resources: testResourcePolicy})
+	if err == nil {
+		t.Errorf("want error for a sidecar above the maximum")
+	}
+
+	policy := testResourcePolicy
+	policy.Clamp = true
+	deployment, err := newDeployment(function, map[string]*corev1.Secret{}, DeploymentConfig{Resources: policy})
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+
+	podSpec := deployment.Spec.Template.Spec
+	sidecar := podSpec.Containers[1].Resources
+	assertQuantity(t, sidecar.Limits, corev1.ResourceMemory, "1Gi")
+	assertQuantity(t, sidecar.Limits, corev1.ResourceCPU, "500m")
+	assertQuantity(t, sidecar.Requests, corev1.ResourceMemory, "128Mi")
+
+	initContainer := podSpec.InitContainers[0].Resources
+	assertQuantity(t, initContainer.Limits, corev1.ResourceMemory, "256Mi")
+	assertQuantity(t, initContainer.Requests, corev1.ResourceCPU, "100m")
+}
@@ -2,11 +2,11 @@ package controller
 
 import (
 	"fmt"
+	"reflect"
 	"strings"
 	"time"
 
 	"github.com/golang/glog"
-	"github.com/google/go-cmp/cmp"
 	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
 	clientset "github.com/openfaas-incubator/openfaas-operator/pkg/client/clientset/versioned"
 	faasscheme "github.com/openfaas-incubator/openfaas-operator/pkg/client/clientset/versioned/scheme"
@@ -14,8 +14,11 @@ import (
 	listers "github.com/openfaas-incubator/openfaas-operator/pkg/client/listers/openfaas/v1alpha2"
 	appsv1beta2 "k8s.io/api/apps/v1beta2"
 	corev1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	networkingv1 "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	kubeinformers "k8s.io/client-go/informers"
@@ -23,6 +26,8 @@ import (
 	"k8s.io/client-go/kubernetes/scheme"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	appslisters "k8s.io/client-go/listers/apps/v1beta2"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	extensionslisters "k8s.io/client-go/listers/extensions/v1beta1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
@@ -38,6 +43,9 @@ const (
 	// ErrResourceExists is used as part of the Event 'reason' when a Function fails
 	// to sync due to a Deployment of the same name already existing.
 	ErrResourceExists = "ErrResourceExists"
+	// ErrInvalidSpec is used as part of the Event 'reason' when a Function
+	// spec can't be turned into a Deployment.
+	ErrInvalidSpec = "ErrInvalidSpec"
 
 	// MessageResourceExists is the message used for Events when a resource
 	// fails to sync due to a Deployment already existing
@@ -58,6 +66,10 @@ type Controller struct {
 	deploymentsSynced cache.InformerSynced
 	functionsLister   listers.FunctionLister
 	functionsSynced   cache.InformerSynced
+	ingressesLister   extensionslisters.IngressLister
+	ingressesSynced   cache.InformerSynced
+	accountsLister    corelisters.ServiceAccountLister
+	accountsSynced    cache.InformerSynced
 
 	// workqueue is a rate limited work queue. This is used to queue work to be
 	// processed instead of performing it as soon as a change happens. This
@@ -69,7 +81,9 @@ type Controller struct {
 	// Kubernetes API.
 	recorder record.EventRecorder
 
-	imagePullPolicy corev1.PullPolicy
+	deploymentConfig    DeploymentConfig
+	networkPolicyConfig NetworkPolicyConfig
+	ingressConfig       IngressConfig
 }
 
 func checkCustomResourceType(obj interface{}) (faasv1.Function, bool) {
@@ -88,13 +102,19 @@ func NewController(
 	faasclientset clientset.Interface,
 	kubeInformerFactory kubeinformers.SharedInformerFactory,
 	faasInformerFactory informers.SharedInformerFactory,
-	imagePullPolicy corev1.PullPolicy) *Controller {
+	deploymentConfig DeploymentConfig,
+	networkPolicyConfig NetworkPolicyConfig,
+	ingressConfig IngressConfig) *Controller {
 
 	// obtain references to shared index informers for the Deployment and Function types
 	deploymentInformer := kubeInformerFactory.Apps().V1beta2().Deployments()
 
 	serviceInformer := kubeInformerFactory.Core().V1().Services()
 
+	ingressInformer := kubeInformerFactory.Extensions().V1beta1().Ingresses()
+
+	accountInformer := kubeInformerFactory.Core().V1().ServiceAccounts()
+
 	faasInformer := faasInformerFactory.Openfaas().V1alpha2().Functions()
 
 	// Create event broadcaster
@@ -108,14 +128,21 @@ func NewController(
 	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: controllerAgentName})
 
 	controller := &Controller{
-		kubeclientset:     kubeclientset,
-		faasclientset:     faasclientset,
-		deploymentsLister: deploymentInformer.Lister(),
-		deploymentsSynced: deploymentInformer.Informer().HasSynced,
-		functionsLister:   faasInformer.Lister(),
-		functionsSynced:   faasInformer.Informer().HasSynced,
-		workqueue:         workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Functions"),
-		recorder:          recorder,
+		kubeclientset:       kubeclientset,
+		faasclientset:       faasclientset,
+		deploymentsLister:   deploymentInformer.Lister(),
+		deploymentsSynced:   deploymentInformer.Informer().HasSynced,
+		functionsLister:     faasInformer.Lister(),
+		functionsSynced:     faasInformer.Informer().HasSynced,
+		ingressesLister:     ingressInformer.Lister(),
+		ingressesSynced:     ingressInformer.Informer().HasSynced,
+		accountsLister:      accountInformer.Lister(),
+		accountsSynced:      accountInformer.Informer().HasSynced,
+		workqueue:           workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Functions"),
+		recorder:            recorder,
+		deploymentConfig:    deploymentConfig,
+		networkPolicyConfig: networkPolicyConfig,
+		ingressConfig:       ingressConfig,
 	}
 
 	glog.Info("Setting up event handlers")
@@ -134,7 +161,7 @@ func NewController(
 			if !ok {
 				return
 			}
-			if diff := cmp.Diff(oldFn.Spec, newFn.Spec); diff != "" {
+			if diff := functionSpecDiff(oldFn.Spec, newFn.Spec); diff != "" {
 				controller.enqueueFunction(new)
 			}
 		},
@@ -179,6 +206,47 @@ func NewController(
 		DeleteFunc: controller.handleObject,
 	})
 
+	// Add ServiceAccount Informer
+	accountInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, new interface{}) {
+			newAccount := new.(*corev1.ServiceAccount)
+			oldAccount := old.(*corev1.ServiceAccount)
+			if newAccount.ResourceVersion == oldAccount.ResourceVersion {
+				return
+			}
+			controller.handleObject(new)
+		},
+		DeleteFunc: controller.handleObject,
+	})
+
+	// Add Ingress Informer
+	ingressInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, new interface{}) {
+			newIngress := new.(*extensionsv1beta1.Ingress)
+			oldIngress := old.(*extensionsv1beta1.Ingress)
+			if newIngress.ResourceVersion == oldIngress.ResourceVersion {
+				return
+			}
+			controller.handleObject(new)
+		},
+		DeleteFunc: controller.handleObject,
+	})
+
+	// Add NetworkPolicy Informer, the policies are only watched when the operator manages them
+	if networkPolicyConfig.Enabled {
+		kubeInformerFactory.Networking().V1().NetworkPolicies().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			UpdateFunc: func(old, new interface{}) {
+				newPolicy := new.(*networkingv1.NetworkPolicy)
+				oldPolicy := old.(*networkingv1.NetworkPolicy)
+				if newPolicy.ResourceVersion == oldPolicy.ResourceVersion {
+					return
+				}
+				controller.handleObject(new)
+			},
+			DeleteFunc: controller.handleObject,
+		})
+	}
+
 	// Set up an event handler for when functions related resources like pods, deployments, replica sets
 	// can't be materialized. This logs abnormal events like ImagePullBackOff, back-off restarting failed container,
 	// failed to start container, oci runtime errors, etc
@@ -211,7 +279,7 @@ func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 	// Start the informer factories to begin populating the informer caches
 	// Wait for the caches to be synced before starting workers
 	glog.Info("Waiting for informer caches to sync")
-	if ok := cache.WaitForCacheSync(stopCh, c.deploymentsSynced, c.functionsSynced); !ok {
+	if ok := cache.WaitForCacheSync(stopCh, c.deploymentsSynced, c.functionsSynced, c.ingressesSynced, c.accountsSynced); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 
@@ -318,29 +386,62 @@ func (c *Controller) syncHandler(key string) error {
 		return nil
 	}
 
+	// Create or update the ServiceAccount before the pods that run as it
+	if err := c.syncServiceAccount(function); err != nil {
+		return err
+	}
+
+	// Apply the namespace quota and limits before the pods are created
+	if err := c.syncNamespaceResources(function.Namespace); err != nil {
+		return err
+	}
+
+	// Isolate the function pods before they start
+	if err := c.syncNetworkPolicy(function); err != nil {
+		return err
+	}
+
 	// Get the deployment with the name specified in Function.spec
 	deployment, err := c.deploymentsLister.Deployments(function.Namespace).Get(deploymentName)
 	// If the resource doesn't exist, we'll create it
 	if errors.IsNotFound(err) {
-		existingSecrets, err := c.getSecrets(function.Namespace, function.Spec.Secrets)
+		existingSecrets, err := c.getSecrets(function.Namespace, secretNames(function))
+		if err != nil {
+			return err
+		}
+
+		config, err := c.functionDeploymentConfig(function.Namespace)
 		if err != nil {
 			return err
 		}
 
+		deploymentSpec, err := newDeployment(function, existingSecrets, config)
+		if conditionErr := c.updateDeploymentStatus(function, deploymentSpec, err); conditionErr != nil {
+			glog.Warningf("Updating status for '%s' failed: %v", function.Spec.Name, conditionErr)
+		}
+		if err != nil {
+			c.recorder.Event(function, corev1.EventTypeWarning, ErrInvalidSpec, err.Error())
+			return err
+		}
+
 		glog.Infof("Creating deployment for '%s'", function.Spec.Name)
-		deployment, err = c.kubeclientset.AppsV1beta2().Deployments(function.Namespace).Create(
-			newDeployment(function, existingSecrets, c.imagePullPolicy),
-		)
+		deployment, err = c.kubeclientset.AppsV1beta2().Deployments(function.Namespace).Create(deploymentSpec)
 	}
 
 	svcGetOptions := metav1.GetOptions{}
 	_, getSvcErr := c.kubeclientset.CoreV1().Services(function.Namespace).Get(deploymentName, svcGetOptions)
 	if errors.IsNotFound(getSvcErr) {
-		glog.Infof("Creating ClusterIP service for '%s'", function.Spec.Name)
-		if _, err := c.kubeclientset.CoreV1().Services(function.Namespace).Create(newService(function)); err != nil {
+		serviceSpec, err := newService(function)
+		if err != nil {
+			c.recorder.Event(function, corev1.EventTypeWarning, ErrInvalidSpec, err.Error())
+			return err
+		}
+
+		glog.Infof("Creating %s service for '%s'", serviceSpec.Spec.Type, function.Spec.Name)
+		if _, err := c.kubeclientset.CoreV1().Services(function.Namespace).Create(serviceSpec); err != nil {
 			// If an error occurs during Service Create, we'll requeue the item
 			if errors.IsAlreadyExists(err) {
-				glog.V(2).Infof("ClusterIP service '%s' already exists. Skipping creation.", function.Spec.Name)
+				glog.V(2).Infof("Service '%s' already exists. Skipping creation.", function.Spec.Name)
 			} else {
 				return err
 			}
@@ -362,18 +463,34 @@ func (c *Controller) syncHandler(key string) error {
 		return fmt.Errorf(msg)
 	}
 
+	if err := c.syncIngress(function); err != nil {
+		return err
+	}
+
 	// Update the Deployment resource if the Function definition differs
 	if deploymentNeedsUpdate(function, deployment) {
 		glog.Infof("Updating deployment for '%s'", function.Spec.Name)
 
-		existingSecrets, err := c.getSecrets(function.Namespace, function.Spec.Secrets)
+		existingSecrets, err := c.getSecrets(function.Namespace, secretNames(function))
 		if err != nil {
 			return err
 		}
 
-		deployment, err = c.kubeclientset.AppsV1beta2().Deployments(function.Namespace).Update(
-			newDeployment(function, existingSecrets, c.imagePullPolicy),
-		)
+		config, err := c.functionDeploymentConfig(function.Namespace)
+		if err != nil {
+			return err
+		}
+
+		deploymentSpec, err := newDeployment(function, existingSecrets, config)
+		if conditionErr := c.updateDeploymentStatus(function, deploymentSpec, err); conditionErr != nil {
+			glog.Warningf("Updating status for '%s' failed: %v", function.Spec.Name, conditionErr)
+		}
+		if err != nil {
+			c.recorder.Event(function, corev1.EventTypeWarning, ErrInvalidSpec, err.Error())
+			return err
+		}
+
+		deployment, err = c.kubeclientset.AppsV1beta2().Deployments(function.Namespace).Update(deploymentSpec)
 
 		if err != nil {
 			glog.Errorf("Updating deployment for '%s' failed: %v", function.Spec.Name, err)
@@ -384,8 +501,23 @@ func (c *Controller) syncHandler(key string) error {
 			return err
 		}
 
-		existingService.Annotations = makeAnnotations(function)
-		_, err = c.kubeclientset.CoreV1().Services(function.Namespace).Update(existingService)
+		if serviceNeedsRecreate(function, existingService) {
+			// the cluster IP can't be changed, the Service is created again on the next sync
+			glog.Infof("Deleting service for '%s' to change its type", function.Spec.Name)
+			err = c.kubeclientset.CoreV1().Services(function.Namespace).Delete(function.Spec.Name, &metav1.DeleteOptions{})
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("service for '%s' is being recreated", function.Spec.Name)
+		}
+
+		serviceCopy := existingService.DeepCopy()
+		serviceCopy.Annotations = makeAnnotations(function)
+		if err := configureService(function, serviceCopy); err != nil {
+			c.recorder.Event(function, corev1.EventTypeWarning, ErrInvalidSpec, err.Error())
+			return err
+		}
+		_, err = c.kubeclientset.CoreV1().Services(function.Namespace).Update(serviceCopy)
 		if err != nil {
 			glog.Errorf("Updating service for '%s' failed: %v", function.Spec.Name, err)
 		}
@@ -398,6 +530,11 @@ func (c *Controller) syncHandler(key string) error {
 		return err
 	}
 
+	// Remove the ServiceAccounts the pods stopped running as
+	if err := c.pruneServiceAccounts(function, deployment); err != nil {
+		return err
+	}
+
 	// Finally, we update the status block of the Function resource to reflect the
 	// current state of the world
 	err = c.updateFunctionStatus(function, deployment)
@@ -478,12 +615,260 @@ func (c *Controller) handleObject(obj interface{}) {
 	}
 }
 
+// updateDeploymentStatus records in the Function status whether its pod template patch
+// was applied and the effective resources of the function container. Nothing is written
+// when the status is unchanged.
+func (c *Controller) updateDeploymentStatus(function *faasv1.Function, deployment *appsv1beta2.Deployment, deploymentErr error) error {
+	functionCopy := function.DeepCopy()
+
+	changed := false
+	if function.Spec.PodTemplatePatch == nil {
+		changed = removeFunctionCondition(&functionCopy.Status, faasv1.PodTemplatePatched)
+	} else if patchErr, ok := deploymentErr.(*podTemplatePatchError); ok {
+		changed = setFunctionCondition(&functionCopy.Status, faasv1.FunctionCondition{
+			Type:    faasv1.PodTemplatePatched,
+			Status:  corev1.ConditionFalse,
+			Reason:  "PatchFailed",
+			Message: patchErr.Error(),
+		})
+	} else if deploymentErr == nil {
+		changed = setFunctionCondition(&functionCopy.Status, faasv1.FunctionCondition{
+			Type:   faasv1.PodTemplatePatched,
+			Status: corev1.ConditionTrue,
+			Reason: "PatchApplied",
+		})
+	}
+
+	if deployment != nil {
+		resources := deployment.Spec.Template.Spec.Containers[0].Resources
+		limits := makeFunctionResources(resources.Limits)
+		requests := makeFunctionResources(resources.Requests)
+		if !reflect.DeepEqual(limits, function.Status.Limits) || !reflect.DeepEqual(requests, function.Status.Requests) {
+			functionCopy.Status.Limits = limits
+			functionCopy.Status.Requests = requests
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	_, err := c.faasclientset.OpenfaasV1alpha2().Functions(function.Namespace).Update(functionCopy)
+	return err
+}
+
+// functionDeploymentConfig returns the deployment settings for functions in the given namespace,
+// enabling the restricted security preset when the namespace carries the hardened label.
+func (c *Controller) functionDeploymentConfig(namespace string) (DeploymentConfig, error) {
+	config := c.deploymentConfig
+	if len(config.HardenedNamespaceLabel) == 0 {
+		return config, nil
+	}
+
+	ns, err := c.kubeclientset.CoreV1().Namespaces().Get(namespace, metav1.GetOptions{})
+	if err != nil {
+		return config, err
+	}
+
+	parts := strings.SplitN(config.HardenedNamespaceLabel, "=", 2)
+	value, exists := ns.Labels[parts[0]]
+	config.Restricted = exists && (len(parts) == 1 || value == parts[1])
+
+	return config, nil
+}
+
+// syncServiceAccount creates or updates the ServiceAccount owned by a Function,
+// when the Function asks the operator to manage one.
+func (c *Controller) syncServiceAccount(function *faasv1.Function) error {
+	if !ownsServiceAccount(function) {
+		return nil
+	}
+
+	accountName := serviceAccountName(function)
+	existing, err := c.kubeclientset.CoreV1().ServiceAccounts(function.Namespace).Get(accountName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		glog.Infof("Creating service account for '%s'", function.Spec.Name)
+		_, err = c.kubeclientset.CoreV1().ServiceAccounts(function.Namespace).Create(newServiceAccount(function))
+		if errors.IsAlreadyExists(err) {
+			return nil
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if !metav1.IsControlledBy(existing, function) {
+		msg := fmt.Sprintf(MessageResourceExists, existing.Name)
+		c.recorder.Event(function, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return fmt.Errorf("%s", msg)
+	}
+
+	if serviceAccountNeedsUpdate(function, existing) {
+		glog.Infof("Updating service account for '%s'", function.Spec.Name)
+		desired := newServiceAccount(function)
+		accountCopy := existing.DeepCopy()
+		accountCopy.ImagePullSecrets = desired.ImagePullSecrets
+		accountCopy.AutomountServiceAccountToken = desired.AutomountServiceAccountToken
+		_, err = c.kubeclientset.CoreV1().ServiceAccounts(function.Namespace).Update(accountCopy)
+		return err
+	}
+
+	return nil
+}
+
+// pruneServiceAccounts deletes the ServiceAccounts created for a Function that it no longer
+// uses, they would otherwise be kept until the Function is deleted. Accounts are only deleted
+// once the rollout is complete and no pod of the function runs as them, so the pods of the
+// previous rollout keep their token.
+func (c *Controller) pruneServiceAccounts(function *faasv1.Function, deployment *appsv1beta2.Deployment) error {
+	selector := labels.SelectorFromSet(labels.Set{"faas_function": function.Spec.Name})
+	accounts, err := c.accountsLister.ServiceAccounts(function.Namespace).List(selector)
+	if err != nil {
+		return err
+	}
+	if len(staleServiceAccounts(function, accounts, nil)) == 0 || !rolloutComplete(deployment) {
+		return nil
+	}
+
+	pods, err := c.kubeclientset.CoreV1().Pods(function.Namespace).List(metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, account := range staleServiceAccounts(function, accounts, pods.Items) {
+		glog.Infof("Deleting service account '%s' of '%s'", account.Name, function.Spec.Name)
+		err := c.kubeclientset.CoreV1().ServiceAccounts(function.Namespace).Delete(account.Name, &metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncNamespaceResources creates or updates the ResourceQuota and LimitRange of the function
+// namespace when the operator is configured to manage them
+func (c *Controller) syncNamespaceResources(namespace string) error {
+	policy := c.deploymentConfig.Resources
+
+	if len(policy.Quota) > 0 {
+		quotas := c.kubeclientset.CoreV1().ResourceQuotas(namespace)
+		desired := newResourceQuota(namespace, policy)
+		existing, err := quotas.Get(desired.Name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			glog.Infof("Creating resource quota in '%s'", namespace)
+			_, err = quotas.Create(desired)
+			if errors.IsAlreadyExists(err) {
+				err = nil
+			}
+		} else if err == nil && !resourceListsEqual(desired.Spec.Hard, existing.Spec.Hard) {
+			glog.Infof("Updating resource quota in '%s'", namespace)
+			quotaCopy := existing.DeepCopy()
+			quotaCopy.Spec.Hard = desired.Spec.Hard
+			_, err = quotas.Update(quotaCopy)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if policy.LimitRange {
+		limitRanges := c.kubeclientset.CoreV1().LimitRanges(namespace)
+		desired, err := newLimitRange(namespace, policy)
+		if err != nil {
+			return err
+		}
+		existing, err := limitRanges.Get(desired.Name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			glog.Infof("Creating limit range in '%s'", namespace)
+			_, err = limitRanges.Create(desired)
+			if errors.IsAlreadyExists(err) {
+				err = nil
+			}
+		} else if err == nil && limitRangeNeedsUpdate(desired, existing) {
+			glog.Infof("Updating limit range in '%s'", namespace)
+			limitRangeCopy := existing.DeepCopy()
+			limitRangeCopy.Spec = desired.Spec
+			_, err = limitRanges.Update(limitRangeCopy)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// syncIngress creates, updates or deletes the Ingress of a function
+func (c *Controller) syncIngress(function *faasv1.Function) error {
+	existing, err := c.ingressesLister.Ingresses(function.Namespace).Get(function.Spec.Name)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	found := err == nil
+
+	ingresses := c.kubeclientset.ExtensionsV1beta1().Ingresses(function.Namespace)
+
+	// an Ingress left behind by a previous spec is deleted
+	if function.Spec.Ingress == nil {
+		if !found || !metav1.IsControlledBy(existing, function) {
+			return nil
+		}
+		glog.Infof("Deleting ingress for '%s'", function.Spec.Name)
+		err = ingresses.Delete(existing.Name, &metav1.DeleteOptions{})
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if found && !metav1.IsControlledBy(existing, function) {
+		msg := fmt.Sprintf(MessageResourceExists, existing.Name)
+		c.recorder.Event(function, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return fmt.Errorf("%s", msg)
+	}
+
+	desired, err := newIngress(function, c.ingressConfig)
+	if err != nil {
+		c.recorder.Event(function, corev1.EventTypeWarning, ErrInvalidSpec, err.Error())
+		return err
+	}
+
+	if !found {
+		glog.Infof("Creating ingress for '%s'", function.Spec.Name)
+		_, err = ingresses.Create(desired)
+		if errors.IsAlreadyExists(err) {
+			return nil
+		}
+		return err
+	}
+
+	if ingressNeedsUpdate(desired, existing) {
+		glog.Infof("Updating ingress for '%s'", function.Spec.Name)
+		ingressCopy := existing.DeepCopy()
+		ingressCopy.Annotations = desired.Annotations
+		ingressCopy.Spec = desired.Spec
+		_, err = ingresses.Update(ingressCopy)
+		return err
+	}
+
+	return nil
+}
+
 // getSecrets queries Kubernetes for a list of secrets by name in the given k8s namespace.
+// Secrets that are not found are left out of the result, UpdateSecrets decides if they
+// were required.
 func (c *Controller) getSecrets(namespace string, secretNames []string) (map[string]*corev1.Secret, error) {
 	secrets := map[string]*corev1.Secret{}
 
 	for _, secretName := range secretNames {
 		secret, err := c.kubeclientset.CoreV1().Secrets(namespace).Get(secretName, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			continue
+		}
 		if err != nil {
 			return secrets, err
 		}
@@ -2,6 +2,7 @@ package controller
 
 import (
 	"encoding/json"
+	"fmt"
 	"path/filepath"
 	"strings"
 
@@ -10,24 +11,55 @@ import (
 	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
 	appsv1beta2 "k8s.io/api/apps/v1beta2"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 const (
 	annotationFunctionSpec = "com.openfaas.function.spec"
 )
 
+// functionSpecDiff compares two function specs, quantities such as the rate limit are
+// compared by value since cmp can't look into their unexported fields
+func functionSpecDiff(x, y faasv1.FunctionSpec) string {
+	return cmp.Diff(x, y, cmp.Comparer(func(a, b resource.Quantity) bool {
+		return a.Cmp(b) == 0
+	}))
+}
+
+// DeploymentConfig holds the operator-wide settings used to build function Deployments
+type DeploymentConfig struct {
+	// ImagePullPolicy is set on the function container
+	ImagePullPolicy corev1.PullPolicy
+	// SecurityBaseline is the security context applied to every function,
+	// function settings take precedence
+	SecurityBaseline *faasv1.FunctionSecurity
+	// HardenedNamespaceLabel selects the namespaces where the restricted
+	// security preset is enforced, in the form key or key=value
+	HardenedNamespaceLabel string
+	// Restricted is set by the controller when the function namespace is hardened
+	Restricted bool
+	// PatchDenylist lists the pod template paths a function patch can't set
+	PatchDenylist []string
+	// Resources holds the default and maximum resources of the function container
+	Resources ResourcePolicy
+}
+
 // newDeployment creates a new Deployment for a Function resource. It also sets
 // the appropriate OwnerReferences on the resource so handleObject can discover
-// the Function resource that 'owns' it.
+// the Function resource that 'owns' it. An error is returned when the Function
+// requests secrets or volumes that can't be mounted, an invalid rollout strategy,
+// invalid init containers or sidecars, security settings that its namespace
+// does not allow, resources above the operator maximums or a pod template patch
+// that can't be applied.
 func newDeployment(
 	function *faasv1.Function,
 	existingSecrets map[string]*corev1.Secret,
-	imagePullPolicy corev1.PullPolicy) *appsv1beta2.Deployment {
+	config DeploymentConfig) (*appsv1beta2.Deployment, error) {
 
 	envVars := makeEnvVars(function)
+	envFrom := makeEnvFrom(function)
 	labels := makeLabels(function)
 	nodeSelector := makeNodeSelector(function.Spec.Constraints)
 	livenessProbe := makeLivenessProbe()
@@ -37,6 +69,9 @@ func newDeployment(
 		glog.Warningf("Function %s resources parsing failed: %v",
 			function.Spec.Name, err)
 	}
+	if err := applyResourcePolicy(resources, config.Resources); err != nil {
+		return nil, fmt.Errorf("resources: %v", err)
+	}
 
 	annotations := makeAnnotations(function)
 
@@ -55,26 +90,12 @@ func newDeployment(
 		},
 		Spec: appsv1beta2.DeploymentSpec{
 			Replicas: function.Spec.Replicas,
-			Strategy: appsv1beta2.DeploymentStrategy{
-				Type: appsv1beta2.RollingUpdateDeploymentStrategyType,
-				RollingUpdate: &appsv1beta2.RollingUpdateDeployment{
-					MaxUnavailable: &intstr.IntOrString{
-						Type:   intstr.Int,
-						IntVal: int32(0),
-					},
-					MaxSurge: &intstr.IntOrString{
-						Type:   intstr.Int,
-						IntVal: int32(1),
-					},
-				},
-			},
 			Selector: &metav1.LabelSelector{
 				MatchLabels: map[string]string{
 					"app":        function.Spec.Name,
 					"controller": function.Name,
 				},
 			},
-			RevisionHistoryLimit: int32p(5),
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
 					Labels:      labels,
@@ -89,8 +110,9 @@ func newDeployment(
 							Ports: []corev1.ContainerPort{
 								{ContainerPort: int32(functionPort), Protocol: corev1.ProtocolTCP},
 							},
-							ImagePullPolicy: imagePullPolicy,
+							ImagePullPolicy: config.ImagePullPolicy,
 							Env:             envVars,
+							EnvFrom:         envFrom,
 							Resources:       *resources,
 							LivenessProbe:   livenessProbe,
 							ReadinessProbe:  livenessProbe,
@@ -101,14 +123,38 @@ func newDeployment(
 		},
 	}
 
+	if err := configureStrategy(function, deploymentSpec); err != nil {
+		return nil, err
+	}
+
 	configureReadOnlyRootFilesystem(function, deploymentSpec)
+	configureServiceAccount(function, &deploymentSpec.Spec.Template.Spec)
+
+	if err := configureVolumes(function, deploymentSpec); err != nil {
+		return nil, err
+	}
+
+	if err := configureContainers(function, deploymentSpec, config); err != nil {
+		return nil, err
+	}
 
 	if err := UpdateSecrets(function, deploymentSpec, existingSecrets); err != nil {
-		glog.Warningf("Function %s secrets update failed: %v",
-			function.Spec.Name, err)
+		return nil, err
+	}
+
+	if err := configureSecurityContext(function, deploymentSpec, config); err != nil {
+		return nil, err
+	}
+
+	if err := applyPodTemplatePatch(function, deploymentSpec, config); err != nil {
+		return nil, err
+	}
+
+	if err := checkMountPaths(deploymentSpec); err != nil {
+		return nil, err
 	}
 
-	return deploymentSpec
+	return deploymentSpec, nil
 }
 
 func makeEnvVars(function *faasv1.Function) []corev1.EnvVar {
@@ -130,9 +176,86 @@ func makeEnvVars(function *faasv1.Function) []corev1.EnvVar {
 		}
 	}
 
+	envVars = appendEnvRefs(envVars, function.Spec.Name, function.Spec.EnvironmentRefs)
+	envVars = setTimeoutEnvVars(function, envVars)
+
 	return envVars
 }
 
+// appendEnvRefs adds the environment variables read from Secret keys, ConfigMap keys and pod fields
+func appendEnvRefs(envVars []corev1.EnvVar, functionName string, refs []faasv1.FunctionEnvVar) []corev1.EnvVar {
+	for _, ref := range refs {
+		envVar := corev1.EnvVar{Name: ref.Name}
+
+		switch {
+		case ref.SecretKeyRef != nil:
+			optional := ref.SecretKeyRef.Optional
+			envVar.ValueFrom = &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: ref.SecretKeyRef.Name},
+					Key:                  ref.SecretKeyRef.Key,
+					Optional:             &optional,
+				},
+			}
+		case ref.ConfigMapKeyRef != nil:
+			optional := ref.ConfigMapKeyRef.Optional
+			envVar.ValueFrom = &corev1.EnvVarSource{
+				ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: ref.ConfigMapKeyRef.Name},
+					Key:                  ref.ConfigMapKeyRef.Key,
+					Optional:             &optional,
+				},
+			}
+		case len(ref.FieldRef) > 0:
+			envVar.ValueFrom = &corev1.EnvVarSource{
+				FieldRef: &corev1.ObjectFieldSelector{
+					APIVersion: "v1",
+					FieldPath:  ref.FieldRef,
+				},
+			}
+		default:
+			glog.Warningf("Function %s environment variable %s has no source, skipping",
+				functionName, ref.Name)
+			continue
+		}
+
+		envVars = append(envVars, envVar)
+	}
+
+	return envVars
+}
+
+// makeEnvFrom imports whole Secrets and ConfigMaps as environment variables
+func makeEnvFrom(function *faasv1.Function) []corev1.EnvFromSource {
+	envFrom := []corev1.EnvFromSource{}
+
+	for _, source := range function.Spec.EnvironmentFrom {
+		optional := source.Optional
+
+		if len(source.SecretRef) > 0 {
+			envFrom = append(envFrom, corev1.EnvFromSource{
+				Prefix: source.Prefix,
+				SecretRef: &corev1.SecretEnvSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: source.SecretRef},
+					Optional:             &optional,
+				},
+			})
+		}
+
+		if len(source.ConfigMapRef) > 0 {
+			envFrom = append(envFrom, corev1.EnvFromSource{
+				Prefix: source.Prefix,
+				ConfigMapRef: &corev1.ConfigMapEnvSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: source.ConfigMapRef},
+					Optional:             &optional,
+				},
+			})
+		}
+	}
+
+	return envFrom
+}
+
 func makeLabels(function *faasv1.Function) map[string]string {
 	labels := map[string]string{
 		"faas_function": function.Spec.Name,
@@ -225,7 +348,7 @@ func deploymentNeedsUpdate(function *faasv1.Function, deployment *appsv1beta2.De
 		Spec: *prevFnSpec,
 	}
 
-	if diff := cmp.Diff(prevFn.Spec, function.Spec); diff != "" {
+	if diff := functionSpecDiff(prevFn.Spec, function.Spec); diff != "" {
 		glog.V(2).Infof("Change detected for %s diff\n%s", function.Name, diff)
 		return true
 	} else {
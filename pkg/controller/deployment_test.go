@@ -0,0 +1,45 @@
+package controller
+
+import (
+	"testing"
+
+	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func Test_deploymentNeedsUpdate_WithRateLimit(t *testing.T) {
+	function := &faasv1.Function{
+		Spec: faasv1.FunctionSpec{
+			Name:  "testfunc",
+			Image: "alpine:latest",
+			RateLimit: &faasv1.FunctionRateLimit{
+				RequestsPerSecond: resource.MustParse("20"),
+				Burst:             40,
+			},
+		},
+	}
+
+	deployment, err := newDeployment(function, map[string]*corev1.Secret{}, DeploymentConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	if deploymentNeedsUpdate(function, deployment) {
+		t.Errorf("want no update for the synced spec")
+	}
+
+	// the same rate written another way is not a change
+	function.Spec.RateLimit.RequestsPerSecond = resource.MustParse("20000m")
+	if deploymentNeedsUpdate(function, deployment) {
+		t.Errorf("want no update for an equal rate")
+	}
+
+	updated := function.DeepCopy()
+	updated.Spec.RateLimit.RequestsPerSecond = resource.MustParse("500m")
+	if !deploymentNeedsUpdate(updated, deployment) {
+		t.Errorf("want an update for a new rate")
+	}
+	if functionSpecDiff(function.Spec, updated.Spec) == "" {
+		t.Errorf("want the informer to see the new rate")
+	}
+}
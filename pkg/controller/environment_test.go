@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"testing"
+
+	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
+)
+
+func Test_makeEnvVars_WithRefs(t *testing.T) {
+	function := &faasv1.Function{
+		Spec: faasv1.FunctionSpec{
+			Name:    "testfunc",
+			Handler: "cat",
+			EnvironmentRefs: []faasv1.FunctionEnvVar{
+				{Name: "DB_PASSWORD", SecretKeyRef: &faasv1.FunctionKeyRef{Name: "db", Key: "password"}},
+				{Name: "LOG_LEVEL", ConfigMapKeyRef: &faasv1.FunctionKeyRef{Name: "config", Key: "level", Optional: true}},
+				{Name: "POD_NAME", FieldRef: "metadata.name"},
+				{Name: "EMPTY"},
+			},
+		},
+	}
+
+	envVars := makeEnvVars(function)
+
+	if len(envVars) != 4 {
+		t.Fatalf("want 4 env vars, got %d: %v", len(envVars), envVars)
+	}
+
+	if envVars[0].Name != "fprocess" || envVars[0].Value != "cat" {
+		t.Errorf("want fprocess=cat, got %s=%s", envVars[0].Name, envVars[0].Value)
+	}
+
+	secretRef := envVars[1].ValueFrom.SecretKeyRef
+	if secretRef == nil || secretRef.Name != "db" || secretRef.Key != "password" || *secretRef.Optional {
+		t.Errorf("unexpected secret key ref for %s: %v", envVars[1].Name, envVars[1].ValueFrom)
+	}
+
+	configMapRef := envVars[2].ValueFrom.ConfigMapKeyRef
+	if configMapRef == nil || configMapRef.Name != "config" || configMapRef.Key != "level" || !*configMapRef.Optional {
+		t.Errorf("unexpected config map key ref for %s: %v", envVars[2].Name, envVars[2].ValueFrom)
+	}
+
+	fieldRef := envVars[3].ValueFrom.FieldRef
+	if fieldRef == nil || fieldRef.FieldPath != "metadata.name" {
+		t.Errorf("unexpected field ref for %s: %v", envVars[3].Name, envVars[3].ValueFrom)
+	}
+}
+
+func Test_makeEnvFrom(t *testing.T) {
+	function := &faasv1.Function{
+		Spec: faasv1.FunctionSpec{
+			Name: "testfunc",
+			EnvironmentFrom: []faasv1.FunctionEnvFrom{
+				{SecretRef: "db", Prefix: "DB_"},
+				{ConfigMapRef: "config", Optional: true},
+			},
+		},
+	}
+
+	envFrom := makeEnvFrom(function)
+
+	if len(envFrom) != 2 {
+		t.Fatalf("want 2 env sources, got %d", len(envFrom))
+	}
+
+	if envFrom[0].SecretRef == nil || envFrom[0].SecretRef.Name != "db" || envFrom[0].Prefix != "DB_" {
+		t.Errorf("unexpected secret source: %v", envFrom[0])
+	}
+
+	if envFrom[1].ConfigMapRef == nil || envFrom[1].ConfigMapRef.Name != "config" || !*envFrom[1].ConfigMapRef.Optional {
+		t.Errorf("unexpected config map source: %v", envFrom[1])
+	}
+}
@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	ingressTargetGateway = "gateway"
+	ingressTargetService = "service"
+)
+
+// IngressConfig holds the operator-wide settings of the function Ingresses
+type IngressConfig struct {
+	// GatewayService is the Service in the functions namespace that routes to the gateway,
+	// usually an ExternalName Service for the gateway in the OpenFaaS namespace
+	GatewayService string
+	// GatewayPort is the port of the gateway Service
+	GatewayPort int
+	// Class sets the kubernetes.io/ingress.class annotation when not empty
+	Class string
+	// RewriteAnnotation is the annotation that rewrites the request path to /function/<name>
+	// when the Ingress routes to the gateway
+	RewriteAnnotation string
+}
+
+// newIngress creates a new Ingress for a Function resource that routes the function host and path
+// to the gateway or to the function Service. It also sets the appropriate OwnerReferences on the
+// resource so handleObject can discover the Function resource that 'owns' it.
+func newIngress(function *faasv1.Function, config IngressConfig) (*extensionsv1beta1.Ingress, error) {
+	fi := function.Spec.Ingress
+
+	path := fi.Path
+	if len(path) == 0 {
+		path = "/"
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("ingress path '%s' must start with /", path)
+	}
+	if len(fi.TLSSecretName) > 0 && len(fi.Host) == 0 {
+		return nil, fmt.Errorf("ingress tlsSecretName requires a host")
+	}
+
+	annotations := map[string]string{}
+	for k, v := range fi.Annotations {
+		annotations[k] = v
+	}
+	if len(config.Class) > 0 {
+		annotations["kubernetes.io/ingress.class"] = config.Class
+	}
+
+	backend := extensionsv1beta1.IngressBackend{}
+	switch fi.Target {
+	case "", ingressTargetGateway:
+		backend.ServiceName = config.GatewayService
+		backend.ServicePort = intstr.FromInt(config.GatewayPort)
+		annotations[config.RewriteAnnotation] = fmt.Sprintf("/function/%s", function.Spec.Name)
+	case ingressTargetService:
+		backend.ServiceName = function.Spec.Name
+		backend.ServicePort = intstr.FromInt(functionPort)
+	default:
+		return nil, fmt.Errorf("ingress target '%s' is not supported", fi.Target)
+	}
+
+	spec := extensionsv1beta1.IngressSpec{
+		Rules: []extensionsv1beta1.IngressRule{
+			{
+				Host: fi.Host,
+				IngressRuleValue: extensionsv1beta1.IngressRuleValue{
+					HTTP: &extensionsv1beta1.HTTPIngressRuleValue{
+						Paths: []extensionsv1beta1.HTTPIngressPath{{Path: path, Backend: backend}},
+					},
+				},
+			},
+		},
+	}
+	if len(fi.TLSSecretName) > 0 {
+		spec.TLS = []extensionsv1beta1.IngressTLS{
+			{Hosts: []string{fi.Host}, SecretName: fi.TLSSecretName},
+		}
+	}
+
+	return &extensionsv1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        function.Spec.Name,
+			Namespace:   function.Namespace,
+			Labels:      map[string]string{"faas_function": function.Spec.Name},
+			Annotations: annotations,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(function, schema.GroupVersionKind{
+					Group:   faasv1.SchemeGroupVersion.Group,
+					Version: faasv1.SchemeGroupVersion.Version,
+					Kind:    faasKind,
+				}),
+			},
+		},
+		Spec: spec,
+	}, nil
+}
+
+// ingressNeedsUpdate returns true when the existing Ingress spec or annotations differ from the desired ones
+func ingressNeedsUpdate(desired, existing *extensionsv1beta1.Ingress) bool {
+	return !reflect.DeepEqual(desired.Spec, existing.Spec) ||
+		!reflect.DeepEqual(desired.Annotations, existing.Annotations)
+}
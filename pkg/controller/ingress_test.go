@@ -0,0 +1,88 @@
+package controller
+
+import (
+	"testing"
+
+	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
+)
+
+var testIngressConfig = IngressConfig{
+	GatewayService:    "gateway",
+	GatewayPort:       8080,
+	Class:             "nginx",
+	RewriteAnnotation: "nginx.ingress.kubernetes.io/rewrite-target",
+}
+
+func Test_newIngress_Gateway(t *testing.T) {
+	function := &faasv1.Function{
+		Spec: faasv1.FunctionSpec{
+			Name: "testfunc",
+			Ingress: &faasv1.FunctionIngress{
+				Host:          "testfunc.example.com",
+				TLSSecretName: "testfunc-tls",
+			},
+		},
+	}
+
+	ingress, err := newIngress(function, testIngressConfig)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rule := ingress.Spec.Rules[0]
+	if rule.Host != "testfunc.example.com" {
+		t.Errorf("want host testfunc.example.com, got %s", rule.Host)
+	}
+	path := rule.HTTP.Paths[0]
+	if path.Path != "/" || path.Backend.ServiceName != "gateway" || path.Backend.ServicePort.IntValue() != 8080 {
+		t.Errorf("want / routed to gateway:8080, got %v", path)
+	}
+	if rewrite := ingress.Annotations["nginx.ingress.kubernetes.io/rewrite-target"]; rewrite != "/function/testfunc" {
+		t.Errorf("want rewrite to /function/testfunc, got '%s'", rewrite)
+	}
+	if ingress.Annotations["kubernetes.io/ingress.class"] != "nginx" {
+		t.Errorf("want ingress class nginx, got %v", ingress.Annotations)
+	}
+	if len(ingress.Spec.TLS) != 1 || ingress.Spec.TLS[0].SecretName != "testfunc-tls" {
+		t.Errorf("want TLS with testfunc-tls, got %v", ingress.Spec.TLS)
+	}
+}
+
+func Test_newIngress_Service(t *testing.T) {
+	function := &faasv1.Function{
+		Spec: faasv1.FunctionSpec{
+			Name:    "testfunc",
+			Ingress: &faasv1.FunctionIngress{Path: "/api", Target: "service"},
+		},
+	}
+
+	ingress, err := newIngress(function, testIngressConfig)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	path := ingress.Spec.Rules[0].HTTP.Paths[0]
+	if path.Path != "/api" || path.Backend.ServiceName != "testfunc" || path.Backend.ServicePort.IntValue() != functionPort {
+		t.Errorf("want /api routed to testfunc:%d, got %v", functionPort, path)
+	}
+	if _, ok := ingress.Annotations[testIngressConfig.RewriteAnnotation]; ok {
+		t.Errorf("want no rewrite for the service target")
+	}
+}
+
+func Test_newIngress_Invalid(t *testing.T) {
+	ingresses := []*faasv1.FunctionIngress{
+		{Path: "api"},
+		{Target: "pod"},
+		{TLSSecretName: "tls"},
+	}
+
+	for _, fi := range ingresses {
+		function := &faasv1.Function{
+			Spec: faasv1.FunctionSpec{Name: "testfunc", Ingress: fi},
+		}
+		if _, err := newIngress(function, testIngressConfig); err == nil {
+			t.Errorf("want error for ingress %v", fi)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package controller
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/golang/glog"
+	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const defaultDenyPolicyName = "openfaas-default-deny"
+
+// NetworkPolicyConfig holds the operator-wide NetworkPolicy settings
+type NetworkPolicyConfig struct {
+	// Enabled turns on the creation of a NetworkPolicy per function
+	Enabled bool
+	// DefaultDeny creates a policy that denies ingress to every pod of the
+	// function namespace not allowed by another policy
+	DefaultDeny bool
+	// GatewayNamespaceLabels selects the namespace of the gateway and operator pods
+	GatewayNamespaceLabels map[string]string
+	// GatewayPodLabels selects the gateway and operator pods
+	GatewayPodLabels map[string]string
+	// CallerPodLabels select other pods of the gateway namespace that invoke functions
+	// directly, such as the queue-worker
+	CallerPodLabels []map[string]string
+	// IngressNamespaceLabels and IngressPodLabels select the ingress controller pods, which
+	// call functions with an Ingress that targets the function Service. Empty labels
+	// select every namespace or pod.
+	IngressNamespaceLabels map[string]string
+	IngressPodLabels       map[string]string
+}
+
+// newNetworkPolicy creates a new NetworkPolicy for a Function resource. Ingress is only allowed
+// on the function port, from the gateway and caller pods, from the ingress controller when the
+// function Ingress targets the function Service and from anywhere when the function Service is
+// exposed on the nodes. When the function declares an egress allowlist egress is limited to it.
+// It also sets the appropriate OwnerReferences on the resource so handleObject can discover the
+// Function resource that 'owns' it.
+func newNetworkPolicy(function *faasv1.Function, config NetworkPolicyConfig) (*networkingv1.NetworkPolicy, error) {
+	tcp := corev1.ProtocolTCP
+	udp := corev1.ProtocolUDP
+	port := intstr.FromInt(functionPort)
+
+	ingress := networkingv1.NetworkPolicyIngressRule{
+		Ports: []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: &port}},
+		From: []networkingv1.NetworkPolicyPeer{
+			{
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: config.GatewayNamespaceLabels},
+				PodSelector:       &metav1.LabelSelector{MatchLabels: config.GatewayPodLabels},
+			},
+		},
+	}
+	for _, labels := range config.CallerPodLabels {
+		ingress.From = append(ingress.From, networkingv1.NetworkPolicyPeer{
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: config.GatewayNamespaceLabels},
+			PodSelector:       &metav1.LabelSelector{MatchLabels: labels},
+		})
+	}
+	if fi := function.Spec.Ingress; fi != nil && fi.Target == ingressTargetService {
+		ingress.From = append(ingress.From, networkingv1.NetworkPolicyPeer{
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: config.IngressNamespaceLabels},
+			PodSelector:       &metav1.LabelSelector{MatchLabels: config.IngressPodLabels},
+		})
+	}
+	if fs := function.Spec.Service; fs != nil &&
+		(fs.Type == string(corev1.ServiceTypeNodePort) || fs.Type == string(corev1.ServiceTypeLoadBalancer)) {
+		// node and load balancer traffic can't be told apart by labels, a rule
+		// without peers allows any source
+		ingress.From = nil
+	}
+
+	spec := networkingv1.NetworkPolicySpec{
+		PodSelector: metav1.LabelSelector{
+			MatchLabels: map[string]string{"faas_function": function.Spec.Name},
+		},
+		Ingress:     []networkingv1.NetworkPolicyIngressRule{ingress},
+		PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+	}
+
+	if policy := function.Spec.NetworkPolicy; policy != nil {
+		// an Egress policy type without rules denies all egress traffic
+		spec.PolicyTypes = append(spec.PolicyTypes, networkingv1.PolicyTypeEgress)
+
+		if policy.AllowDNS {
+			dns := intstr.FromInt(53)
+			spec.Egress = append(spec.Egress, networkingv1.NetworkPolicyEgressRule{
+				Ports: []networkingv1.NetworkPolicyPort{
+					{Protocol: &udp, Port: &dns},
+					{Protocol: &tcp, Port: &dns},
+				},
+			})
+		}
+
+		for _, rule := range policy.Egress {
+			egress, err := makeEgressRule(rule)
+			if err != nil {
+				return nil, err
+			}
+			spec.Egress = append(spec.Egress, *egress)
+		}
+	}
+
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      function.Spec.Name,
+			Namespace: function.Namespace,
+			Labels:    map[string]string{"faas_function": function.Spec.Name},
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(function, schema.GroupVersionKind{
+					Group:   faasv1.SchemeGroupVersion.Group,
+					Version: faasv1.SchemeGroupVersion.Version,
+					Kind:    faasKind,
+				}),
+			},
+		},
+		Spec: spec,
+	}, nil
+}
+
+func makeEgressRule(rule faasv1.FunctionEgressRule) (*networkingv1.NetworkPolicyEgressRule, error) {
+	peer := networkingv1.NetworkPolicyPeer{}
+
+	switch {
+	case len(rule.CIDR) > 0:
+		if len(rule.NamespaceLabels) > 0 || len(rule.PodLabels) > 0 {
+			return nil, fmt.Errorf("egress rule for '%s' can't also select namespaces or pods", rule.CIDR)
+		}
+		peer.IPBlock = &networkingv1.IPBlock{CIDR: rule.CIDR, Except: rule.Except}
+	case len(rule.NamespaceLabels) > 0:
+		peer.NamespaceSelector = &metav1.LabelSelector{MatchLabels: rule.NamespaceLabels}
+		if len(rule.PodLabels) > 0 {
+			peer.PodSelector = &metav1.LabelSelector{MatchLabels: rule.PodLabels}
+		}
+	default:
+		return nil, fmt.Errorf("egress rule needs a cidr or namespaceLabels")
+	}
+
+	egress := &networkingv1.NetworkPolicyEgressRule{To: []networkingv1.NetworkPolicyPeer{peer}}
+	for _, p := range rule.Ports {
+		tcp := corev1.ProtocolTCP
+		port := intstr.FromInt(int(p))
+		egress.Ports = append(egress.Ports, networkingv1.NetworkPolicyPort{Protocol: &tcp, Port: &port})
+	}
+
+	return egress, nil
+}
+
+// newDefaultDenyPolicy creates the namespace-wide policy that denies ingress to every pod
+// that no other policy allows
+func newDefaultDenyPolicy(namespace string) *networkingv1.NetworkPolicy {
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      defaultDenyPolicyName,
+			Namespace: namespace,
+			Labels:    map[string]string{"app": controllerAgentName},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+		},
+	}
+}
+
+// networkPolicyNeedsUpdate returns true when the existing policy spec differs from the desired one
+func networkPolicyNeedsUpdate(desired, existing *networkingv1.NetworkPolicy) bool {
+	return !reflect.DeepEqual(desired.Spec, existing.Spec)
+}
+
+// syncNetworkPolicy creates or updates the NetworkPolicy of a function and the namespace
+// default deny policy when the operator is configured to manage them
+func (c *Controller) syncNetworkPolicy(function *faasv1.Function) error {
+	config := c.networkPolicyConfig
+	if !config.Enabled {
+		return nil
+	}
+
+	policies := c.kubeclientset.NetworkingV1().NetworkPolicies(function.Namespace)
+
+	if config.DefaultDeny {
+		_, err := policies.Get(defaultDenyPolicyName, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			glog.Infof("Creating default deny network policy in '%s'", function.Namespace)
+			_, err = policies.Create(newDefaultDenyPolicy(function.Namespace))
+			if errors.IsAlreadyExists(err) {
+				err = nil
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	desired, err := newNetworkPolicy(function, config)
+	if err != nil {
+		c.recorder.Event(function, corev1.EventTypeWarning, ErrInvalidSpec, err.Error())
+		return err
+	}
+
+	existing, err := policies.Get(desired.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		glog.Infof("Creating network policy for '%s'", function.Spec.Name)
+		_, err = policies.Create(desired)
+		if errors.IsAlreadyExists(err) {
+			return nil
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if !metav1.IsControlledBy(existing, function) {
+		msg := fmt.Sprintf(MessageResourceExists, existing.Name)
+		c.recorder.Event(function, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return fmt.Errorf("%s", msg)
+	}
+
+	if networkPolicyNeedsUpdate(desired, existing) {
+		glog.Infof("Updating network policy for '%s'", function.Spec.Name)
+		policyCopy := existing.DeepCopy()
+		policyCopy.Spec = desired.Spec
+		_, err = policies.Update(policyCopy)
+		return err
+	}
+
+	return nil
+}
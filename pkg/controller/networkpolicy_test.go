@@ -0,0 +1,164 @@
+package controller
+
+import (
+	"testing"
+
+	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+var testNetworkPolicyConfig = NetworkPolicyConfig{
+	Enabled:                true,
+	GatewayNamespaceLabels: map[string]string{"role": "openfaas-system"},
+	GatewayPodLabels:       map[string]string{"app": "gateway"},
+}
+
+func Test_newNetworkPolicy_IngressOnly(t *testing.T) {
+	function := &faasv1.Function{
+		Spec: faasv1.FunctionSpec{Name: "testfunc"},
+	}
+
+	policy, err := newNetworkPolicy(function, testNetworkPolicyConfig)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if policy.Spec.PodSelector.MatchLabels["faas_function"] != "testfunc" {
+		t.Errorf("want pod selector faas_function=testfunc, got %v", policy.Spec.PodSelector.MatchLabels)
+	}
+	if len(policy.Spec.PolicyTypes) != 1 || policy.Spec.PolicyTypes[0] != networkingv1.PolicyTypeIngress {
+		t.Errorf("want Ingress policy type only, got %v", policy.Spec.PolicyTypes)
+	}
+	if len(policy.Spec.Ingress) != 1 || len(policy.Spec.Ingress[0].From) != 1 {
+		t.Fatalf("want a single ingress peer, got %v", policy.Spec.Ingress)
+	}
+	peer := policy.Spec.Ingress[0].From[0]
+	if peer.NamespaceSelector.MatchLabels["role"] != "openfaas-system" || peer.PodSelector.MatchLabels["app"] != "gateway" {
+		t.Errorf("want gateway peer, got %v", peer)
+	}
+	if port := policy.Spec.Ingress[0].Ports[0].Port.IntValue(); port != functionPort {
+		t.Errorf("want port %d, got %d", functionPort, port)
+	}
+}
+
+func Test_newNetworkPolicy_Egress(t *testing.T) {
+	function := &faasv1.Function{
+		Spec: faasv1.FunctionSpec{
+			Name: "testfunc",
+			NetworkPolicy: &faasv1.FunctionNetworkPolicy{
+				AllowDNS: true,
+				Egress: []faasv1.FunctionEgressRule{
+					{CIDR: "10.0.0.0/8", Except: []string{"10.0.1.0/24"}, Ports: []int32{5432}},
+					{NamespaceLabels: map[string]string{"name": "storage"}, PodLabels: map[string]string{"app": "minio"}},
+				},
+			},
+		},
+	}
+
+	policy, err := newNetworkPolicy(function, testNetworkPolicyConfig)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(policy.Spec.PolicyTypes) != 2 || policy.Spec.PolicyTypes[1] != networkingv1.PolicyTypeEgress {
+		t.Errorf("want Ingress and Egress policy types, got %v", policy.Spec.PolicyTypes)
+	}
+	if len(policy.Spec.Egress) != 3 {
+		t.Fatalf("want DNS and 2 egress rules, got %d", len(policy.Spec.Egress))
+	}
+	if len(policy.Spec.Egress[0].To) != 0 || len(policy.Spec.Egress[0].Ports) != 2 {
+		t.Errorf("want DNS rule to any destination on 2 ports, got %v", policy.Spec.Egress[0])
+	}
+	block := policy.Spec.Egress[1].To[0].IPBlock
+	if block == nil || block.CIDR != "10.0.0.0/8" || len(block.Except) != 1 {
+		t.Errorf("want ip block 10.0.0.0/8, got %v", block)
+	}
+	if policy.Spec.Egress[1].Ports[0].Port.IntValue() != 5432 {
+		t.Errorf("want port 5432, got %v", policy.Spec.Egress[1].Ports)
+	}
+	peer := policy.Spec.Egress[2].To[0]
+	if peer.NamespaceSelector == nil || peer.PodSelector == nil || peer.PodSelector.MatchLabels["app"] != "minio" {
+		t.Errorf("want namespace and pod selector, got %v", peer)
+	}
+}
+
+func Test_newNetworkPolicy_InvalidEgress(t *testing.T) {
+	rules := []faasv1.FunctionEgressRule{
+		{},
+		{CIDR: "10.0.0.0/8", PodLabels: map[string]string{"app": "minio"}},
+	}
+
+	for _, rule := range rules {
+		function := &faasv1.Function{
+			Spec: faasv1.FunctionSpec{
+				Name:          "testfunc",
+				NetworkPolicy: &faasv1.FunctionNetworkPolicy{Egress: []faasv1.FunctionEgressRule{rule}},
+			},
+		}
+
+		if _, err := newNetworkPolicy(function, testNetworkPolicyConfig); err == nil {
+			t.Errorf("want error for egress rule %v", rule)
+		}
+	}
+}
+
+func Test_networkPolicyNeedsUpdate(t *testing.T) {
+	function := &faasv1.Function{
+		Spec: faasv1.FunctionSpec{Name: "testfunc"},
+	}
+
+	existing, _ := newNetworkPolicy(function, testNetworkPolicyConfig)
+	desired, _ := newNetworkPolicy(function, testNetworkPolicyConfig)
+	if networkPolicyNeedsUpdate(desired, existing) {
+		t.Errorf("want no update for the same spec")
+	}
+
+	function.Spec.NetworkPolicy = &faasv1.FunctionNetworkPolicy{AllowDNS: true}
+	desired, _ = newNetworkPolicy(function, testNetworkPolicyConfig)
+	if !networkPolicyNeedsUpdate(desired, existing) {
+		t.Errorf("want update when egress is restricted")
+	}
+}
+
+func Test_newNetworkPolicy_ExposedFunctions(t *testing.T) {
+	config := testNetworkPolicyConfig
+	config.CallerPodLabels = []map[string]string{{"app": "queue-worker"}}
+	config.IngressNamespaceLabels = map[string]string{"name": "ingress-nginx"}
+
+	cases := []struct {
+		name      string
+		spec      faasv1.FunctionSpec
+		wantPeers int
+	}{
+		{"gateway and callers", faasv1.FunctionSpec{Name: "testfunc"}, 2},
+		{"ingress to the gateway", faasv1.FunctionSpec{
+			Name:    "testfunc",
+			Ingress: &faasv1.FunctionIngress{Host: "testfunc.example.com", Target: ingressTargetGateway},
+		}, 2},
+		{"ingress to the service", faasv1.FunctionSpec{
+			Name:    "testfunc",
+			Ingress: &faasv1.FunctionIngress{Host: "testfunc.example.com", Target: ingressTargetService},
+		}, 3},
+		{"node port", faasv1.FunctionSpec{Name: "testfunc", Service: &faasv1.FunctionService{Type: "NodePort"}}, 0},
+		{"load balancer", faasv1.FunctionSpec{Name: "testfunc", Service: &faasv1.FunctionService{Type: "LoadBalancer"}}, 0},
+	}
+	for _, c := range cases {
+		policy, err := newNetworkPolicy(&faasv1.Function{Spec: c.spec}, config)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", c.name, err)
+		}
+		rule := policy.Spec.Ingress[0]
+		if len(rule.From) != c.wantPeers {
+			t.Errorf("%s: want %d peers, got %v", c.name, c.wantPeers, rule.From)
+		}
+		if len(rule.Ports) != 1 || rule.Ports[0].Port.IntValue() != functionPort {
+			t.Errorf("%s: want the function port only, got %v", c.name, rule.Ports)
+		}
+	}
+
+	policy, _ := newNetworkPolicy(&faasv1.Function{Spec: cases[2].spec}, config)
+	peer := policy.Spec.Ingress[0].From[2]
+	if peer.NamespaceSelector.MatchLabels["name"] != "ingress-nginx" || len(peer.PodSelector.MatchLabels) != 0 {
+		t.Errorf("want the ingress controller namespace, got %v", peer)
+	}
+}
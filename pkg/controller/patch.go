@@ -0,0 +1,200 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
+	appsv1beta2 "k8s.io/api/apps/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+)
+
+// DefaultPatchDenylist lists the pod template paths a function patch can't set. Paths are
+// dot separated, `*` matches any list item or map key.
+var DefaultPatchDenylist = []string{
+	"spec.hostNetwork",
+	"spec.hostPID",
+	"spec.hostIPC",
+	"spec.volumes.*.hostPath",
+	"spec.containers.*.securityContext.privileged",
+	"spec.containers.*.securityContext.capabilities",
+	"spec.containers.*.securityContext.allowPrivilegeEscalation",
+	"spec.initContainers.*.securityContext.privileged",
+	"spec.initContainers.*.securityContext.capabilities",
+	"spec.initContainers.*.securityContext.allowPrivilegeEscalation",
+	"spec.securityContext",
+	"spec.serviceAccountName",
+	"spec.automountServiceAccountToken",
+}
+
+// podTemplatePatchError is returned by newDeployment when the pod template patch
+// of a function is denied or can't be applied
+type podTemplatePatchError struct {
+	err error
+}
+
+func (e *podTemplatePatchError) Error() string {
+	return fmt.Sprintf("pod template patch: %v", e.err)
+}
+
+// applyPodTemplatePatch applies the strategic merge patch from the function spec on top of the
+// pod template built by newDeployment. The patch is rejected when it sets a path from the denylist
+// or changes one in the patched template, such as with a $patch directive, or when it weakens the
+// restricted preset of a hardened namespace.
+func applyPodTemplatePatch(function *faasv1.Function, deployment *appsv1beta2.Deployment, config DeploymentConfig) error {
+	patch := function.Spec.PodTemplatePatch
+	if patch == nil || len(patch.Raw) == 0 {
+		return nil
+	}
+
+	var patchDoc interface{}
+	if err := json.Unmarshal(patch.Raw, &patchDoc); err != nil {
+		return &podTemplatePatchError{err}
+	}
+
+	for _, denied := range config.PatchDenylist {
+		if hasPath(patchDoc, strings.Split(denied, ".")) {
+			return &podTemplatePatchError{fmt.Errorf("setting '%s' is not allowed", denied)}
+		}
+	}
+
+	original, err := json.Marshal(deployment.Spec.Template)
+	if err != nil {
+		return &podTemplatePatchError{err}
+	}
+
+	patched, err := strategicpatch.StrategicMergePatch(original, patch.Raw, corev1.PodTemplateSpec{})
+	if err != nil {
+		return &podTemplatePatchError{err}
+	}
+
+	template := corev1.PodTemplateSpec{}
+	if err := json.Unmarshal(patched, &template); err != nil {
+		return &podTemplatePatchError{err}
+	}
+
+	// directives such as $patch: delete or replace, $retainKeys and $setElementOrder can
+	// remove or replace denied paths without naming them, so the templates are compared
+	if err := checkDeniedChanges(deployment.Spec.Template, template, config.PatchDenylist); err != nil {
+		return &podTemplatePatchError{err}
+	}
+
+	if config.Restricted {
+		if err := checkRestrictedTemplate(&template); err != nil {
+			return &podTemplatePatchError{fmt.Errorf("namespace '%s' is hardened: %v", function.Namespace, err)}
+		}
+	}
+
+	deployment.Spec.Template = template
+	return nil
+}
+
+// hasPath returns true when the decoded JSON document contains the path
+func hasPath(doc interface{}, path []string) bool {
+	if len(path) == 0 {
+		return true
+	}
+
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		if path[0] == "*" {
+			for _, child := range node {
+				if hasPath(child, path[1:]) {
+					return true
+				}
+			}
+			return false
+		}
+		child, ok := node[path[0]]
+		return ok && hasPath(child, path[1:])
+
+	case []interface{}:
+		if path[0] != "*" {
+			return false
+		}
+		for _, child := range node {
+			if hasPath(child, path[1:]) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// checkDeniedChanges returns an error when a path from the denylist differs between the
+// original and the patched pod template
+func checkDeniedChanges(original, patched corev1.PodTemplateSpec, denylist []string) error {
+	before, err := decodeTemplate(original)
+	if err != nil {
+		return err
+	}
+	after, err := decodeTemplate(patched)
+	if err != nil {
+		return err
+	}
+
+	for _, denied := range denylist {
+		path := strings.Split(denied, ".")
+		beforeValues := map[string]interface{}{}
+		afterValues := map[string]interface{}{}
+		collectPath(before, path, "", beforeValues)
+		collectPath(after, path, "", afterValues)
+
+		if !reflect.DeepEqual(beforeValues, afterValues) {
+			return fmt.Errorf("changing '%s' is not allowed", denied)
+		}
+	}
+	return nil
+}
+
+// decodeTemplate converts a pod template into a decoded JSON document
+func decodeTemplate(template corev1.PodTemplateSpec) (interface{}, error) {
+	data, err := json.Marshal(template)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	err = json.Unmarshal(data, &doc)
+	return doc, err
+}
+
+// collectPath stores the values at the path of the decoded JSON document by their location.
+// List items are located by their name when they have one, so reordered items compare equal.
+func collectPath(doc interface{}, path []string, at string, values map[string]interface{}) {
+	if len(path) == 0 {
+		values[at] = doc
+		return
+	}
+
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		if path[0] == "*" {
+			for key, child := range node {
+				collectPath(child, path[1:], at+"."+key, values)
+			}
+			return
+		}
+		if child, ok := node[path[0]]; ok {
+			collectPath(child, path[1:], at+"."+path[0], values)
+		}
+
+	case []interface{}:
+		if path[0] != "*" {
+			return
+		}
+		for i, child := range node {
+			key := strconv.Itoa(i)
+			if item, ok := child.(map[string]interface{}); ok {
+				if name, ok := item["name"].(string); ok {
+					key = name
+				}
+			}
+			collectPath(child, path[1:], at+"."+key, values)
+		}
+	}
+}
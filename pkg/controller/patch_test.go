@@ -0,0 +1,165 @@
+package controller
+
+import (
+	"testing"
+
+	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func Test_newDeployment_AppliesPodTemplatePatch(t *testing.T) {
+	function := &faasv1.Function{
+		Spec: faasv1.FunctionSpec{
+			Name:                   "testfunc",
+			Image:                  "alpine:latest",
+			ReadOnlyRootFilesystem: true,
+			PodTemplatePatch: &runtime.RawExtension{Raw: []byte(`{
+				"spec": {
+					"priorityClassName": "high",
+					"containers": [{"name": "testfunc", "workingDir": "/home/app"}]
+				}
+			}`)},
+		},
+	}
+
+	deployment, err := newDeployment(function, map[string]*corev1.Secret{}, DeploymentConfig{PatchDenylist: DefaultPatchDenylist})
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+
+	podSpec := deployment.Spec.Template.Spec
+	if podSpec.PriorityClassName != "high" {
+		t.Errorf("want priority class high, got %s", podSpec.PriorityClassName)
+	}
+
+	container := podSpec.Containers[0]
+	if container.WorkingDir != "/home/app" || container.Image != "alpine:latest" {
+		t.Errorf("want patched container to keep its image, got %+v", container)
+	}
+	if !hasMountPath(container, "/tmp") {
+		t.Errorf("want /tmp mount to be kept after the patch")
+	}
+}
+
+func Test_newDeployment_RejectsDeniedPodTemplatePatch(t *testing.T) {
+	patches := map[string]string{
+		"host network": `{"spec": {"hostNetwork": true}}`,
+		"privileged":   `{"spec": {"containers": [{"name": "testfunc", "securityContext": {"privileged": true}}]}}`,
+		"invalid json": `{"spec": `,
+	}
+
+	for name, patch := range patches {
+		t.Run(name, func(t *testing.T) {
+			function := &faasv1.Function{
+				Spec: faasv1.FunctionSpec{
+					Name:             "testfunc",
+					Image:            "alpine:latest",
+					PodTemplatePatch: &runtime.RawExtension{Raw: []byte(patch)},
+				},
+			}
+
+			_, err := newDeployment(function, map[string]*corev1.Secret{}, DeploymentConfig{PatchDenylist: DefaultPatchDenylist})
+			if _, ok := err.(*podTemplatePatchError); !ok {
+				t.Errorf("want pod template patch error, got %v", err)
+			}
+		})
+	}
+}
+
+func Test_setFunctionCondition(t *testing.T) {
+	status := &faasv1.FunctionStatus{}
+	condition := faasv1.FunctionCondition{
+		Type:   faasv1.PodTemplatePatched,
+		Status: corev1.ConditionTrue,
+		Reason: "PatchApplied",
+	}
+
+	if !setFunctionCondition(status, condition) {
+		t.Errorf("want status change when adding a condition")
+	}
+	if setFunctionCondition(status, condition) {
+		t.Errorf("want no status change when setting the same condition")
+	}
+
+	condition.Status = corev1.ConditionFalse
+	if !setFunctionCondition(status, condition) || len(status.Conditions) != 1 {
+		t.Errorf("want the condition to be replaced, got %v", status.Conditions)
+	}
+
+	if !removeFunctionCondition(status, faasv1.PodTemplatePatched) || len(status.Conditions) != 0 {
+		t.Errorf("want the condition to be removed, got %v", status.Conditions)
+	}
+}
+
+func Test_newDeployment_RestrictedRejectsWeakeningPatch(t *testing.T) {
+	patches := map[string]string{
+		"root user":            `{"spec": {"containers": [{"name": "testfunc", "securityContext": {"runAsUser": 0}}]}}`,
+		"privilege escalation": `{"spec": {"containers": [{"name": "testfunc", "securityContext": {"allowPrivilegeEscalation": true}}]}}`,
+		"unconfined seccomp":   `{"metadata": {"annotations": {"seccomp.security.alpha.kubernetes.io/pod": "unconfined"}}}`,
+		"unhardened container": `{"spec": {"containers": [{"name": "debug", "image": "alpine:latest"}]}}`,
+	}
+
+	for name, patch := range patches {
+		t.Run(name, func(t *testing.T) {
+			function := &faasv1.Function{
+				Spec: faasv1.FunctionSpec{
+					Name:             "testfunc",
+					Image:            "alpine:latest",
+					PodTemplatePatch: &runtime.RawExtension{Raw: []byte(patch)},
+				},
+			}
+
+			config := DeploymentConfig{PatchDenylist: DefaultPatchDenylist, Restricted: true}
+			_, err := newDeployment(function, map[string]*corev1.Secret{}, config)
+			if _, ok := err.(*podTemplatePatchError); !ok {
+				t.Errorf("want pod template patch error, got %v", err)
+			}
+		})
+	}
+
+	// patches that keep the preset are still applied
+	function := &faasv1.Function{
+		Spec: faasv1.FunctionSpec{
+			Name:             "testfunc",
+			Image:            "alpine:latest",
+			PodTemplatePatch: &runtime.RawExtension{Raw: []byte(`{"spec": {"containers": [{"name": "testfunc", "securityContext": {"runAsUser": 1000}}]}}`)},
+		},
+	}
+	config := DeploymentConfig{PatchDenylist: DefaultPatchDenylist, Restricted: true}
+	if _, err := newDeployment(function, map[string]*corev1.Secret{}, config); err != nil {
+		t.Errorf("unexpected error %s", err.Error())
+	}
+}
+
+func Test_newDeployment_RejectsPatchDirectivesOnDeniedPaths(t *testing.T) {
+	patches := map[string]string{
+		"delete security context":  `{"spec": {"containers": [{"name": "testfunc", "securityContext": {"$patch": "delete"}}]}}`,
+		"replace containers":       `{"spec": {"containers": [{"name": "testfunc", "image": "alpine:latest", "$patch": "replace"}]}}`,
+		"replace security context": `{"spec": {"containers": [{"name": "testfunc", "securityContext": {"$patch": "replace", "readOnlyRootFilesystem": true}}]}}`,
+		"retain keys":              `{"spec": {"containers": [{"name": "testfunc", "securityContext": {"$retainKeys": ["readOnlyRootFilesystem"], "readOnlyRootFilesystem": true}}]}}`,
+		"delete pod security":      `{"spec": {"securityContext": {"$patch": "delete"}}}`,
+	}
+
+	user := int64(1000)
+	for name, patch := range patches {
+		t.Run(name, func(t *testing.T) {
+			function := &faasv1.Function{
+				Spec: faasv1.FunctionSpec{
+					Name:  "testfunc",
+					Image: "alpine:latest",
+					SecurityContext: &faasv1.FunctionSecurity{
+						RunAsUser:        &user,
+						DropCapabilities: []string{"ALL"},
+					},
+					PodTemplatePatch: &runtime.RawExtension{Raw: []byte(patch)},
+				},
+			}
+
+			_, err := newDeployment(function, map[string]*corev1.Secret{}, DeploymentConfig{PatchDenylist: DefaultPatchDenylist})
+			if _, ok := err.(*podTemplatePatchError); !ok {
+				t.Errorf("want pod template patch error, got %v", err)
+			}
+		})
+	}
+}
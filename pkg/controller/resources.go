@@ -1,28 +1,36 @@
 package controller
 
 import (
+	"fmt"
+
 	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // makeResources creates deployment resource limits and requests requirements from function specs
 func makeResources(function *faasv1.Function) (*corev1.ResourceRequirements, error) {
+	return makeResourceRequirements(function.Spec.Limits, function.Spec.Requests)
+}
+
+// makeResourceRequirements parses the CPU and memory limits and requests of a container
+func makeResourceRequirements(limits *faasv1.FunctionResources, requests *faasv1.FunctionResources) (*corev1.ResourceRequirements, error) {
 	resources := &corev1.ResourceRequirements{
 		Limits:   corev1.ResourceList{},
 		Requests: corev1.ResourceList{},
 	}
 
 	// Set Memory limits
-	if function.Spec.Limits != nil && len(function.Spec.Limits.Memory) > 0 {
-		qty, err := resource.ParseQuantity(function.Spec.Limits.Memory)
+	if limits != nil && len(limits.Memory) > 0 {
+		qty, err := resource.ParseQuantity(limits.Memory)
 		if err != nil {
 			return resources, err
 		}
 		resources.Limits[corev1.ResourceMemory] = qty
 	}
-	if function.Spec.Requests != nil && len(function.Spec.Requests.Memory) > 0 {
-		qty, err := resource.ParseQuantity(function.Spec.Requests.Memory)
+	if requests != nil && len(requests.Memory) > 0 {
+		qty, err := resource.ParseQuantity(requests.Memory)
 		if err != nil {
 			return resources, err
 		}
@@ -30,15 +38,15 @@ func makeResources(function *faasv1.Function) (*corev1.ResourceRequirements, err
 	}
 
 	// Set CPU limits
-	if function.Spec.Limits != nil && len(function.Spec.Limits.CPU) > 0 {
-		qty, err := resource.ParseQuantity(function.Spec.Limits.CPU)
+	if limits != nil && len(limits.CPU) > 0 {
+		qty, err := resource.ParseQuantity(limits.CPU)
 		if err != nil {
 			return resources, err
 		}
 		resources.Limits[corev1.ResourceCPU] = qty
 	}
-	if function.Spec.Requests != nil && len(function.Spec.Requests.CPU) > 0 {
-		qty, err := resource.ParseQuantity(function.Spec.Requests.CPU)
+	if requests != nil && len(requests.CPU) > 0 {
+		qty, err := resource.ParseQuantity(requests.CPU)
 		if err != nil {
 			return resources, err
 		}
@@ -47,3 +55,196 @@ func makeResources(function *faasv1.Function) (*corev1.ResourceRequirements, err
 
 	return resources, nil
 }
+
+// ResourcePolicy holds the operator-wide defaults and maximums of the function resources
+type ResourcePolicy struct {
+	// DefaultLimits and DefaultRequests fill in the values the function leaves out
+	DefaultLimits   faasv1.FunctionResources
+	DefaultRequests faasv1.FunctionResources
+	// MaxLimits caps both the limits and the requests of a function
+	MaxLimits faasv1.FunctionResources
+	// Clamp lowers values above the maximum instead of rejecting the function
+	Clamp bool
+	// LimitRange creates a LimitRange with the defaults and maximums in the function namespace
+	LimitRange bool
+	// Quota is the hard limit of the ResourceQuota created in the function namespace,
+	// no quota is created when it's empty
+	Quota corev1.ResourceList
+}
+
+// applyResourcePolicy sets the default requests and limits the function leaves out and checks the
+// result against the maximums. Values above a maximum are clamped or rejected with an error.
+// A default request above the limit of the function is lowered to the limit and a default
+// limit below the request of the function is raised to the request.
+func applyResourcePolicy(resources *corev1.ResourceRequirements, policy ResourcePolicy) error {
+	defaultLimits, err := makeResourceList(policy.DefaultLimits)
+	if err != nil {
+		return err
+	}
+	defaultRequests, err := makeResourceList(policy.DefaultRequests)
+	if err != nil {
+		return err
+	}
+	maxLimits, err := makeResourceList(policy.MaxLimits)
+	if err != nil {
+		return err
+	}
+
+	if resources.Limits == nil {
+		resources.Limits = corev1.ResourceList{}
+	}
+	if resources.Requests == nil {
+		resources.Requests = corev1.ResourceList{}
+	}
+
+	for name, qty := range defaultLimits {
+		if _, ok := resources.Limits[name]; ok {
+			continue
+		}
+		if request, ok := resources.Requests[name]; ok && qty.Cmp(request) < 0 {
+			qty = request
+		}
+		resources.Limits[name] = qty
+	}
+	for name, qty := range defaultRequests {
+		if _, ok := resources.Requests[name]; ok {
+			continue
+		}
+		if limit, ok := resources.Limits[name]; ok && qty.Cmp(limit) > 0 {
+			qty = limit
+		}
+		resources.Requests[name] = qty
+	}
+
+	for name, max := range maxLimits {
+		if err := capResource(resources.Limits, name, max, "limit", policy.Clamp); err != nil {
+			return err
+		}
+		if err := capResource(resources.Requests, name, max, "request", policy.Clamp); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func capResource(list corev1.ResourceList, name corev1.ResourceName, max resource.Quantity, kind string, clamp bool) error {
+	qty, ok := list[name]
+	if !ok || qty.Cmp(max) <= 0 {
+		return nil
+	}
+	if !clamp {
+		return fmt.Errorf("%s %s of %s is above the maximum of %s", name, kind, qty.String(), max.String())
+	}
+	list[name] = max
+	return nil
+}
+
+// makeResourceList parses the CPU and memory of a FunctionResources into a ResourceList
+func makeResourceList(fr faasv1.FunctionResources) (corev1.ResourceList, error) {
+	list := corev1.ResourceList{}
+	if len(fr.Memory) > 0 {
+		qty, err := resource.ParseQuantity(fr.Memory)
+		if err != nil {
+			return nil, err
+		}
+		list[corev1.ResourceMemory] = qty
+	}
+	if len(fr.CPU) > 0 {
+		qty, err := resource.ParseQuantity(fr.CPU)
+		if err != nil {
+			return nil, err
+		}
+		list[corev1.ResourceCPU] = qty
+	}
+	return list, nil
+}
+
+// makeFunctionResources converts a ResourceList into the FunctionResources reported in the
+// function status, nil when the list has no CPU or memory
+func makeFunctionResources(list corev1.ResourceList) *faasv1.FunctionResources {
+	fr := &faasv1.FunctionResources{}
+	if qty, ok := list[corev1.ResourceMemory]; ok {
+		fr.Memory = qty.String()
+	}
+	if qty, ok := list[corev1.ResourceCPU]; ok {
+		fr.CPU = qty.String()
+	}
+	if len(fr.Memory) == 0 && len(fr.CPU) == 0 {
+		return nil
+	}
+	return fr
+}
+
+// namespaceResourcesName is the name of the ResourceQuota and LimitRange managed by the operator
+const namespaceResourcesName = "openfaas-functions"
+
+// newResourceQuota creates the ResourceQuota of the function namespace
+func newResourceQuota(namespace string, policy ResourcePolicy) *corev1.ResourceQuota {
+	return &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      namespaceResourcesName,
+			Namespace: namespace,
+			Labels:    map[string]string{"app": controllerAgentName},
+		},
+		Spec: corev1.ResourceQuotaSpec{
+			Hard: policy.Quota,
+		},
+	}
+}
+
+// newLimitRange creates the LimitRange of the function namespace, it applies the operator
+// defaults and maximums to every container including init containers and sidecars
+func newLimitRange(namespace string, policy ResourcePolicy) (*corev1.LimitRange, error) {
+	item := corev1.LimitRangeItem{Type: corev1.LimitTypeContainer}
+
+	var err error
+	if item.Default, err = makeResourceList(policy.DefaultLimits); err != nil {
+		return nil, err
+	}
+	if item.DefaultRequest, err = makeResourceList(policy.DefaultRequests); err != nil {
+		return nil, err
+	}
+	if item.Max, err = makeResourceList(policy.MaxLimits); err != nil {
+		return nil, err
+	}
+
+	return &corev1.LimitRange{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      namespaceResourcesName,
+			Namespace: namespace,
+			Labels:    map[string]string{"app": controllerAgentName},
+		},
+		Spec: corev1.LimitRangeSpec{
+			Limits: []corev1.LimitRangeItem{item},
+		},
+	}, nil
+}
+
+// resourceListsEqual compares two ResourceLists by value, the quantities read from the API
+// can have another format than the ones parsed by the operator
+func resourceListsEqual(a, b corev1.ResourceList) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, qty := range a {
+		other, ok := b[name]
+		if !ok || qty.Cmp(other) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// limitRangeNeedsUpdate returns true when the existing LimitRange differs from the desired one
+func limitRangeNeedsUpdate(desired, existing *corev1.LimitRange) bool {
+	if len(existing.Spec.Limits) != 1 {
+		return true
+	}
+	want := desired.Spec.Limits[0]
+	got := existing.Spec.Limits[0]
+	return got.Type != want.Type ||
+		!resourceListsEqual(want.Default, got.Default) ||
+		!resourceListsEqual(want.DefaultRequest, got.DefaultRequest) ||
+		!resourceListsEqual(want.Max, got.Max)
+}
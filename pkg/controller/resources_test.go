@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"testing"
+
+	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+var testResourcePolicy = ResourcePolicy{
+	DefaultLimits:   faasv1.FunctionResources{CPU: "500m", Memory: "256Mi"},
+	DefaultRequests: faasv1.FunctionResources{CPU: "100m", Memory: "128Mi"},
+	MaxLimits:       faasv1.FunctionResources{CPU: "2", Memory: "1Gi"},
+}
+
+func Test_applyResourcePolicy_Defaults(t *testing.T) {
+	resources, _ := makeResourceRequirements(&faasv1.FunctionResources{Memory: "64Mi"}, nil)
+
+	if err := applyResourcePolicy(resources, testResourcePolicy); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assertQuantity(t, resources.Limits, corev1.ResourceMemory, "64Mi")
+	assertQuantity(t, resources.Limits, corev1.ResourceCPU, "500m")
+	assertQuantity(t, resources.Requests, corev1.ResourceCPU, "100m")
+	// the default request is lowered to the function limit
+	assertQuantity(t, resources.Requests, corev1.ResourceMemory, "64Mi")
+}
+
+func Test_applyResourcePolicy_DefaultLimitBelowRequest(t *testing.T) {
+	resources, _ := makeResourceRequirements(nil, &faasv1.FunctionResources{Memory: "512Mi"})
+
+	if err := applyResourcePolicy(resources, testResourcePolicy); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the default limit is raised to the function request
+	assertQuantity(t, resources.Requests, corev1.ResourceMemory, "512Mi")
+	assertQuantity(t, resources.Limits, corev1.ResourceMemory, "512Mi")
+	assertQuantity(t, resources.Limits, corev1.ResourceCPU, "500m")
+}
+
+func Test_applyResourcePolicy_Maximum(t *testing.T) {
+	limits := &faasv1.FunctionResources{Memory: "2Gi", CPU: "1"}
+
+	resources, _ := makeResourceRequirements(limits, nil)
+	if err := applyResourcePolicy(resources, testResourcePolicy); err == nil {
+		t.Errorf("want error for a memory limit above the maximum")
+	}
+
+	policy := testResourcePolicy
+	policy.Clamp = true
+	resources, _ = makeResourceRequirements(limits, &faasv1.FunctionResources{Memory: "2Gi"})
+	if err := applyResourcePolicy(resources, policy); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertQuantity(t, resources.Limits, corev1.ResourceMemory, "1Gi")
+	assertQuantity(t, resources.Requests, corev1.ResourceMemory, "1Gi")
+	assertQuantity(t, resources.Limits, corev1.ResourceCPU, "1")
+}
+
+func Test_applyResourcePolicy_Empty(t *testing.T) {
+	resources, _ := makeResourceRequirements(nil, nil)
+	if err := applyResourcePolicy(resources, ResourcePolicy{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(resources.Limits) != 0 || len(resources.Requests) != 0 {
+		t.Errorf("want no resources, got %v", resources)
+	}
+}
+
+func Test_makeFunctionResources(t *testing.T) {
+	if fr := makeFunctionResources(corev1.ResourceList{}); fr != nil {
+		t.Errorf("want nil for an empty list, got %v", fr)
+	}
+
+	fr := makeFunctionResources(corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("128Mi")})
+	if fr == nil || fr.Memory != "128Mi" || fr.CPU != "" {
+		t.Errorf("want memory 128Mi, got %v", fr)
+	}
+}
+
+func Test_limitRangeNeedsUpdate(t *testing.T) {
+	desired, err := newLimitRange("openfaas-fn", testResourcePolicy)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	existing := desired.DeepCopy()
+	existing.Spec.Limits[0].Max[corev1.ResourceCPU] = resource.MustParse("2000m")
+	if limitRangeNeedsUpdate(desired, existing) {
+		t.Errorf("want no update for an equal quantity in another format")
+	}
+
+	existing.Spec.Limits[0].Max[corev1.ResourceCPU] = resource.MustParse("4")
+	if !limitRangeNeedsUpdate(desired, existing) {
+		t.Errorf("want update when the maximum changes")
+	}
+}
+
+func assertQuantity(t *testing.T, list corev1.ResourceList, name corev1.ResourceName, want string) {
+	qty, ok := list[name]
+	if !ok || qty.Cmp(resource.MustParse(want)) != 0 {
+		t.Errorf("want %s %s, got %s", name, want, qty.String())
+	}
+}
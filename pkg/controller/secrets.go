@@ -2,7 +2,11 @@ package controller
 
 import (
 	"fmt"
+	"path"
+	"sort"
+	"strings"
 
+	"github.com/golang/glog"
 	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
 	appsv1beta2 "k8s.io/api/apps/v1beta2"
 	corev1 "k8s.io/api/core/v1"
@@ -16,14 +20,25 @@ const (
 // in the kubernetes cluster.  For each requested secret, we inspect the type and add it to the
 // deployment spec as appropriate: secrets with type `SecretTypeDockercfg` are added as ImagePullSecrets
 // all other secrets are mounted as files in the deployments containers.
+//
+// Secrets listed in SecretMounts can select individual keys, target paths, file modes and a
+// sub-directory. An error is returned when two secrets project a file to the same path.
 func UpdateSecrets(function *faasv1.Function, deployment *appsv1beta2.Deployment, existingSecrets map[string]*corev1.Secret) error {
 	// Add / reference pre-existing secrets within Kubernetes
 	secretVolumeProjections := []corev1.VolumeProjection{}
+	projectedPaths := map[string]string{}
 
-	for _, secretName := range function.Spec.Secrets {
-		deployedSecret, ok := existingSecrets[secretName]
+	for _, secret := range functionSecrets(function) {
+		deployedSecret, ok := existingSecrets[secret.Name]
 		if !ok {
-			return fmt.Errorf("required secret '%s' was not found in the cluster", secretName)
+			if secret.Optional && len(secret.Items) > 0 {
+				deployedSecret = &corev1.Secret{Type: corev1.SecretTypeOpaque}
+			} else if secret.Optional {
+				glog.V(2).Infof("Optional secret '%s' was not found, skipping", secret.Name)
+				continue
+			} else {
+				return fmt.Errorf("required secret '%s' was not found in the cluster", secret.Name)
+			}
 		}
 
 		switch deployedSecret.Type {
@@ -31,24 +46,34 @@ func UpdateSecrets(function *faasv1.Function, deployment *appsv1beta2.Deployment
 		case corev1.SecretTypeDockercfg,
 			corev1.SecretTypeDockerConfigJson:
 
-			deployment.Spec.Template.Spec.ImagePullSecrets = append(
+			deployment.Spec.Template.Spec.ImagePullSecrets = addImagePullSecret(
+				secret.Name,
 				deployment.Spec.Template.Spec.ImagePullSecrets,
-				corev1.LocalObjectReference{
-					Name: secretName,
-				},
 			)
 
 			break
 
 		default:
 
-			projectedPaths := []corev1.KeyToPath{}
-			for secretKey := range deployedSecret.Data {
-				projectedPaths = append(projectedPaths, corev1.KeyToPath{Key: secretKey, Path: secretKey})
+			items, err := makeSecretItems(secret, deployedSecret)
+			if err != nil {
+				return err
+			}
+
+			for _, item := range items {
+				if other, ok := findPathCollision(item.Path, projectedPaths); ok {
+					return fmt.Errorf("secret '%s' key '%s' collides with secret '%s' at path '%s'",
+						secret.Name, item.Key, other, item.Path)
+				}
+				projectedPaths[item.Path] = secret.Name
 			}
 
-			projection := &corev1.SecretProjection{Items: projectedPaths}
-			projection.Name = secretName
+			projection := &corev1.SecretProjection{Items: items}
+			projection.Name = secret.Name
+			if secret.Optional {
+				optional := true
+				projection.Optional = &optional
+			}
 			secretProjection := corev1.VolumeProjection{
 				Secret: projection,
 			}
@@ -76,9 +101,25 @@ func UpdateSecrets(function *faasv1.Function, deployment *appsv1beta2.Deployment
 		deployment.Spec.Template.Spec.Volumes = append(existingVolumes, projectedSecrets)
 	}
 
-	// add mount secret as a file
+	// add mount secret as a file to the function container and to the init
+	// containers and sidecars that asked for the secrets
+	deployment.Spec.Template.Spec.Containers = mountSecrets(
+		function, volumeName, len(secretVolumeProjections) > 0, deployment.Spec.Template.Spec.Containers, true)
+	deployment.Spec.Template.Spec.InitContainers = mountSecrets(
+		function, volumeName, len(secretVolumeProjections) > 0, deployment.Spec.Template.Spec.InitContainers, false)
+
+	return nil
+}
+
+// mountSecrets replaces the secrets volume mount of the given containers. The first container is
+// the function container when isPodContainers is set and it always gets the secrets.
+func mountSecrets(function *faasv1.Function, volumeName string, hasSecrets bool, containers []corev1.Container, isPodContainers bool) []corev1.Container {
+	if containers == nil {
+		return nil
+	}
+
 	updatedContainers := []corev1.Container{}
-	for _, container := range deployment.Spec.Template.Spec.Containers {
+	for i, container := range containers {
 		mount := corev1.VolumeMount{
 			Name:      volumeName,
 			ReadOnly:  true,
@@ -86,16 +127,98 @@ func UpdateSecrets(function *faasv1.Function, deployment *appsv1beta2.Deployment
 		}
 		// remove the existing secrets volume mount, if we can find it. We update it later.
 		container.VolumeMounts = removeVolumeMount(volumeName, container.VolumeMounts)
-		if len(secretVolumeProjections) > 0 {
+		isFunction := isPodContainers && i == 0
+		if hasSecrets && (isFunction || mountsSecrets(function, container.Name)) {
 			container.VolumeMounts = append(container.VolumeMounts, mount)
 		}
 
 		updatedContainers = append(updatedContainers, container)
 	}
 
-	deployment.Spec.Template.Spec.Containers = updatedContainers
+	return updatedContainers
+}
 
-	return nil
+// functionSecrets merges the plain secret names and the fine-grained secret mounts of a function
+func functionSecrets(function *faasv1.Function) []faasv1.FunctionSecret {
+	secrets := []faasv1.FunctionSecret{}
+	for _, secretName := range function.Spec.Secrets {
+		secrets = append(secrets, faasv1.FunctionSecret{Name: secretName})
+	}
+
+	return append(secrets, function.Spec.SecretMounts...)
+}
+
+// secretNames returns the names of all secrets referenced by a function
+func secretNames(function *faasv1.Function) []string {
+	names := []string{}
+	for _, secret := range functionSecrets(function) {
+		names = append(names, secret.Name)
+	}
+
+	return names
+}
+
+// makeSecretItems returns the keys to project for a secret. When no items are selected
+// every key of the deployed secret is projected with its own name as the path.
+func makeSecretItems(secret faasv1.FunctionSecret, deployedSecret *corev1.Secret) ([]corev1.KeyToPath, error) {
+	selected := secret.Items
+	if len(selected) == 0 {
+		keys := []string{}
+		for secretKey := range deployedSecret.Data {
+			keys = append(keys, secretKey)
+		}
+		sort.Strings(keys)
+
+		for _, secretKey := range keys {
+			selected = append(selected, faasv1.FunctionSecretItem{Key: secretKey})
+		}
+	}
+
+	items := []corev1.KeyToPath{}
+	for _, item := range selected {
+		target := item.Path
+		if len(target) == 0 {
+			target = item.Key
+		}
+		target = path.Clean(path.Join(secret.Directory, target))
+		if path.IsAbs(target) || target == "." || target == ".." || strings.HasPrefix(target, "../") {
+			return nil, fmt.Errorf("secret '%s' key '%s' has an invalid path '%s'", secret.Name, item.Key, target)
+		}
+
+		mode := item.Mode
+		if mode == nil {
+			mode = secret.DefaultMode
+		}
+
+		items = append(items, corev1.KeyToPath{Key: item.Key, Path: target, Mode: mode})
+	}
+
+	return items, nil
+}
+
+// findPathCollision checks if a projected file path is already used or if it
+// would turn an existing file into a directory or the other way around
+func findPathCollision(target string, projectedPaths map[string]string) (string, bool) {
+	for existing, secretName := range projectedPaths {
+		if existing == target ||
+			strings.HasPrefix(existing, target+"/") ||
+			strings.HasPrefix(target, existing+"/") {
+			return secretName, true
+		}
+	}
+
+	return "", false
+}
+
+// addImagePullSecret appends a registry secret unless it is already referenced
+func addImagePullSecret(secretName string, pullSecrets []corev1.LocalObjectReference) []corev1.LocalObjectReference {
+	for _, ref := range pullSecrets {
+		if ref.Name == secretName {
+			return pullSecrets
+		}
+	}
+
+	return append(pullSecrets, corev1.LocalObjectReference{Name: secretName})
 }
 
 // removeVolume returns a Volume slice with any volumes matching volumeName removed.
@@ -240,6 +240,123 @@ func Test_UpdateSecrets_RemovesSecretsVolumeIfRequestSecretsIsEmptyOrNil(t *test
 	validateEmptySecretVolumesAndMounts(t, deployment)
 }
 
+func Test_UpdateSecrets_ProjectsSelectedKeys(t *testing.T) {
+	mode := int32(0400)
+	request := &faasv1.Function{
+		Spec: faasv1.FunctionSpec{
+			Name: "testfunc",
+			SecretMounts: []faasv1.FunctionSecret{
+				{
+					Name:        "testsecret",
+					Directory:   "db",
+					DefaultMode: &mode,
+					Items: []faasv1.FunctionSecretItem{
+						{Key: "password", Path: "pass"},
+					},
+				},
+				{Name: "optionalsecret", Optional: true},
+			},
+		},
+	}
+	existingSecrets := map[string]*corev1.Secret{
+		"testsecret": {Type: corev1.SecretTypeOpaque, Data: map[string][]byte{
+			"password": []byte("contents"),
+			"username": []byte("contents"),
+		}},
+	}
+
+	deployment := &appsv1beta2.Deployment{
+		Spec: appsv1beta2.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{Name: "testfunc", Image: "alpine:latest"},
+					},
+				},
+			},
+		},
+	}
+	err := UpdateSecrets(request, deployment, existingSecrets)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+
+	sources := deployment.Spec.Template.Spec.Volumes[0].Projected.Sources
+	if len(sources) != 1 {
+		t.Fatalf("want 1 projected secret, got %d", len(sources))
+	}
+
+	items := sources[0].Secret.Items
+	if len(items) != 1 {
+		t.Fatalf("want 1 projected key, got %d", len(items))
+	}
+
+	if items[0].Key != "password" || items[0].Path != "db/pass" || *items[0].Mode != mode {
+		t.Errorf("unexpected projected key %+v", items[0])
+	}
+}
+
+func Test_UpdateSecrets_ReportsPathCollisions(t *testing.T) {
+	request := &faasv1.Function{
+		Spec: faasv1.FunctionSpec{
+			Name:    "testfunc",
+			Secrets: []string{"testsecret", "othersecret"},
+		},
+	}
+	existingSecrets := map[string]*corev1.Secret{
+		"testsecret":  {Type: corev1.SecretTypeOpaque, Data: map[string][]byte{"filename": []byte("contents")}},
+		"othersecret": {Type: corev1.SecretTypeOpaque, Data: map[string][]byte{"filename": []byte("contents")}},
+	}
+
+	deployment := &appsv1beta2.Deployment{
+		Spec: appsv1beta2.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{Name: "testfunc", Image: "alpine:latest"},
+					},
+				},
+			},
+		},
+	}
+	err := UpdateSecrets(request, deployment, existingSecrets)
+	if err == nil {
+		t.Fatal("want path collision error, got nil")
+	}
+
+	request.Spec.Secrets = []string{"testsecret"}
+	request.Spec.SecretMounts = []faasv1.FunctionSecret{{Name: "othersecret", Directory: "other"}}
+	err = UpdateSecrets(request, deployment, existingSecrets)
+	if err != nil {
+		t.Errorf("unexpected error %s", err.Error())
+	}
+}
+
+func Test_UpdateSecrets_RequiresNonOptionalSecrets(t *testing.T) {
+	request := &faasv1.Function{
+		Spec: faasv1.FunctionSpec{
+			Name:         "testfunc",
+			SecretMounts: []faasv1.FunctionSecret{{Name: "missing"}},
+		},
+	}
+
+	deployment := &appsv1beta2.Deployment{
+		Spec: appsv1beta2.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{Name: "testfunc", Image: "alpine:latest"},
+					},
+				},
+			},
+		},
+	}
+	err := UpdateSecrets(request, deployment, map[string]*corev1.Secret{})
+	if err == nil {
+		t.Fatal("want missing secret error, got nil")
+	}
+}
+
 func validateEmptySecretVolumesAndMounts(t *testing.T, deployment *appsv1beta2.Deployment) {
 	numVolumes := len(deployment.Spec.Template.Spec.Volumes)
 	if numVolumes != 0 {
@@ -0,0 +1,231 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
+	appsv1beta2 "k8s.io/api/apps/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// allCapabilities is the capability name that drops every Linux capability
+	allCapabilities = "ALL"
+	// netBindCapability is the only capability the restricted preset allows to add
+	netBindCapability = "NET_BIND_SERVICE"
+)
+
+// configureSecurityContext will set the pod and container security settings of a function.
+// The function settings override the operator baseline. When the function runs in a hardened
+// namespace the restricted preset fills in unset values and settings that would weaken it are
+// rejected with an error.
+//
+// Container settings are applied to every container in the pod. Only the fields managed here
+// are written, so the ReadOnlyRootFilesystem setting made by configureReadOnlyRootFilesystem
+// is preserved.
+func configureSecurityContext(function *faasv1.Function, deployment *appsv1beta2.Deployment, config DeploymentConfig) error {
+	security := mergeSecurity(config.SecurityBaseline, function.Spec.SecurityContext)
+
+	if config.Restricted {
+		if err := restrictSecurity(&security); err != nil {
+			return fmt.Errorf("namespace '%s' is hardened: %v", function.Namespace, err)
+		}
+	}
+
+	podSpec := &deployment.Spec.Template.Spec
+	if security.RunAsNonRoot != nil || security.RunAsUser != nil ||
+		security.RunAsGroup != nil || security.FSGroup != nil {
+		if podSpec.SecurityContext == nil {
+			podSpec.SecurityContext = &corev1.PodSecurityContext{}
+		}
+		podSpec.SecurityContext.RunAsNonRoot = security.RunAsNonRoot
+		podSpec.SecurityContext.RunAsUser = security.RunAsUser
+		podSpec.SecurityContext.RunAsGroup = security.RunAsGroup
+		podSpec.SecurityContext.FSGroup = security.FSGroup
+	}
+
+	// container settings apply to the init containers and sidecars as well
+	for i := range podSpec.InitContainers {
+		setContainerSecurity(&podSpec.InitContainers[i], security)
+	}
+	for i := range podSpec.Containers {
+		setContainerSecurity(&podSpec.Containers[i], security)
+	}
+
+	if len(security.SeccompProfile) > 0 {
+		// the pod template shares its annotations with the deployment, copy them first
+		annotations := map[string]string{}
+		for k, v := range deployment.Spec.Template.Annotations {
+			annotations[k] = v
+		}
+		annotations[corev1.SeccompPodAnnotationKey] = security.SeccompProfile
+		deployment.Spec.Template.Annotations = annotations
+	}
+
+	return nil
+}
+
+func setContainerSecurity(container *corev1.Container, security faasv1.FunctionSecurity) {
+	if container.SecurityContext == nil {
+		container.SecurityContext = &corev1.SecurityContext{}
+	}
+	container.SecurityContext.AllowPrivilegeEscalation = security.AllowPrivilegeEscalation
+	container.SecurityContext.Capabilities = nil
+	if len(security.AddCapabilities) > 0 || len(security.DropCapabilities) > 0 {
+		container.SecurityContext.Capabilities = &corev1.Capabilities{
+			Add:  makeCapabilities(security.AddCapabilities),
+			Drop: makeCapabilities(security.DropCapabilities),
+		}
+	}
+}
+
+// mergeSecurity returns the baseline settings overridden by any setting made on the function
+func mergeSecurity(baseline, function *faasv1.FunctionSecurity) faasv1.FunctionSecurity {
+	merged := faasv1.FunctionSecurity{}
+	if baseline != nil {
+		merged = *baseline.DeepCopy()
+	}
+	if function == nil {
+		return merged
+	}
+
+	if function.RunAsNonRoot != nil {
+		merged.RunAsNonRoot = function.RunAsNonRoot
+	}
+	if function.RunAsUser != nil {
+		merged.RunAsUser = function.RunAsUser
+	}
+	if function.RunAsGroup != nil {
+		merged.RunAsGroup = function.RunAsGroup
+	}
+	if function.FSGroup != nil {
+		merged.FSGroup = function.FSGroup
+	}
+	if function.AllowPrivilegeEscalation != nil {
+		merged.AllowPrivilegeEscalation = function.AllowPrivilegeEscalation
+	}
+	if function.AddCapabilities != nil {
+		merged.AddCapabilities = function.AddCapabilities
+	}
+	if function.DropCapabilities != nil {
+		merged.DropCapabilities = function.DropCapabilities
+	}
+	if len(function.SeccompProfile) > 0 {
+		merged.SeccompProfile = function.SeccompProfile
+	}
+
+	return merged
+}
+
+// restrictSecurity applies the restricted preset: non-root user, no privilege escalation,
+// every capability dropped except NET_BIND_SERVICE and the runtime default seccomp profile
+func restrictSecurity(security *faasv1.FunctionSecurity) error {
+	if security.RunAsNonRoot != nil && !*security.RunAsNonRoot {
+		return fmt.Errorf("runAsNonRoot can't be disabled")
+	}
+	if security.RunAsUser != nil && *security.RunAsUser == 0 {
+		return fmt.Errorf("runAsUser can't be root")
+	}
+	if security.AllowPrivilegeEscalation != nil && *security.AllowPrivilegeEscalation {
+		return fmt.Errorf("allowPrivilegeEscalation can't be enabled")
+	}
+	for _, capability := range security.AddCapabilities {
+		if strings.ToUpper(capability) != netBindCapability {
+			return fmt.Errorf("capability '%s' can't be added", capability)
+		}
+	}
+	if security.SeccompProfile == "unconfined" {
+		return fmt.Errorf("seccomp profile can't be unconfined")
+	}
+
+	nonRoot := true
+	security.RunAsNonRoot = &nonRoot
+	escalation := false
+	security.AllowPrivilegeEscalation = &escalation
+	security.DropCapabilities = []string{allCapabilities}
+	if len(security.SeccompProfile) == 0 {
+		security.SeccompProfile = corev1.SeccompProfileRuntimeDefault
+	}
+
+	return nil
+}
+
+// checkRestrictedTemplate rejects a pod template that weakens the restricted preset. It runs on
+// the template after the pod template patch of the function is applied, so a patch can't turn
+// the hardening of the namespace back off.
+func checkRestrictedTemplate(template *corev1.PodTemplateSpec) error {
+	podContext := template.Spec.SecurityContext
+	if podContext == nil || podContext.RunAsNonRoot == nil || !*podContext.RunAsNonRoot {
+		return fmt.Errorf("runAsNonRoot can't be disabled")
+	}
+	if podContext.RunAsUser != nil && *podContext.RunAsUser == 0 {
+		return fmt.Errorf("runAsUser can't be root")
+	}
+
+	containers := append([]corev1.Container{}, template.Spec.InitContainers...)
+	containers = append(containers, template.Spec.Containers...)
+	for _, container := range containers {
+		if err := checkRestrictedContainer(container.SecurityContext); err != nil {
+			return fmt.Errorf("container '%s': %v", container.Name, err)
+		}
+	}
+
+	for key, profile := range template.Annotations {
+		if key == corev1.SeccompPodAnnotationKey || strings.HasPrefix(key, corev1.SeccompContainerAnnotationKeyPrefix) {
+			if profile == "unconfined" {
+				return fmt.Errorf("seccomp profile can't be unconfined")
+			}
+		}
+	}
+	if len(template.Annotations[corev1.SeccompPodAnnotationKey]) == 0 {
+		return fmt.Errorf("seccomp profile can't be removed")
+	}
+
+	return nil
+}
+
+func checkRestrictedContainer(security *corev1.SecurityContext) error {
+	if security == nil {
+		return fmt.Errorf("securityContext can't be removed")
+	}
+	if security.Privileged != nil && *security.Privileged {
+		return fmt.Errorf("privileged can't be enabled")
+	}
+	if security.RunAsNonRoot != nil && !*security.RunAsNonRoot {
+		return fmt.Errorf("runAsNonRoot can't be disabled")
+	}
+	if security.RunAsUser != nil && *security.RunAsUser == 0 {
+		return fmt.Errorf("runAsUser can't be root")
+	}
+	if security.AllowPrivilegeEscalation == nil || *security.AllowPrivilegeEscalation {
+		return fmt.Errorf("allowPrivilegeEscalation can't be enabled")
+	}
+	if security.Capabilities == nil {
+		return fmt.Errorf("capabilities must drop %s", allCapabilities)
+	}
+	for _, capability := range security.Capabilities.Add {
+		if strings.ToUpper(string(capability)) != netBindCapability {
+			return fmt.Errorf("capability '%s' can't be added", capability)
+		}
+	}
+	for _, capability := range security.Capabilities.Drop {
+		if strings.ToUpper(string(capability)) == allCapabilities {
+			return nil
+		}
+	}
+	return fmt.Errorf("capabilities must drop %s", allCapabilities)
+}
+
+func makeCapabilities(names []string) []corev1.Capability {
+	if len(names) == 0 {
+		return nil
+	}
+
+	capabilities := []corev1.Capability{}
+	for _, name := range names {
+		capabilities = append(capabilities, corev1.Capability(strings.ToUpper(name)))
+	}
+
+	return capabilities
+}
@@ -0,0 +1,128 @@
+package controller
+
+import (
+	"testing"
+
+	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
+	appsv1beta2 "k8s.io/api/apps/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func newSecurityTestDeployment() *appsv1beta2.Deployment {
+	readOnly := true
+	return &appsv1beta2.Deployment{
+		Spec: appsv1beta2.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:            "testfunc",
+							Image:           "alpine:latest",
+							SecurityContext: &corev1.SecurityContext{ReadOnlyRootFilesystem: &readOnly},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func Test_configureSecurityContext_FunctionOverridesBaseline(t *testing.T) {
+	baselineUser := int64(1000)
+	functionUser := int64(2000)
+	nonRoot := true
+
+	function := &faasv1.Function{
+		Spec: faasv1.FunctionSpec{
+			Name: "testfunc",
+			SecurityContext: &faasv1.FunctionSecurity{
+				RunAsUser:       &functionUser,
+				AddCapabilities: []string{"net_bind_service"},
+			},
+		},
+	}
+	config := DeploymentConfig{
+		SecurityBaseline: &faasv1.FunctionSecurity{
+			RunAsNonRoot:     &nonRoot,
+			RunAsUser:        &baselineUser,
+			DropCapabilities: []string{"ALL"},
+		},
+	}
+
+	deployment := newSecurityTestDeployment()
+	if err := configureSecurityContext(function, deployment, config); err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+
+	podContext := deployment.Spec.Template.Spec.SecurityContext
+	if podContext == nil || *podContext.RunAsUser != functionUser || !*podContext.RunAsNonRoot {
+		t.Errorf("unexpected pod security context %+v", podContext)
+	}
+
+	containerContext := deployment.Spec.Template.Spec.Containers[0].SecurityContext
+	if !*containerContext.ReadOnlyRootFilesystem {
+		t.Errorf("want read-only root filesystem to be preserved")
+	}
+	capabilities := containerContext.Capabilities
+	if capabilities == nil || len(capabilities.Add) != 1 || capabilities.Add[0] != "NET_BIND_SERVICE" ||
+		len(capabilities.Drop) != 1 || capabilities.Drop[0] != "ALL" {
+		t.Errorf("unexpected capabilities %+v", capabilities)
+	}
+
+	if _, ok := deployment.Spec.Template.Annotations[corev1.SeccompPodAnnotationKey]; ok {
+		t.Errorf("want no seccomp annotation")
+	}
+}
+
+func Test_configureSecurityContext_RestrictedPreset(t *testing.T) {
+	function := &faasv1.Function{
+		Spec: faasv1.FunctionSpec{Name: "testfunc"},
+	}
+
+	deployment := newSecurityTestDeployment()
+	if err := configureSecurityContext(function, deployment, DeploymentConfig{Restricted: true}); err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+
+	if !*deployment.Spec.Template.Spec.SecurityContext.RunAsNonRoot {
+		t.Errorf("want runAsNonRoot")
+	}
+
+	containerContext := deployment.Spec.Template.Spec.Containers[0].SecurityContext
+	if *containerContext.AllowPrivilegeEscalation {
+		t.Errorf("want privilege escalation disabled")
+	}
+	if containerContext.Capabilities == nil || containerContext.Capabilities.Drop[0] != allCapabilities {
+		t.Errorf("want all capabilities dropped, got %+v", containerContext.Capabilities)
+	}
+
+	profile := deployment.Spec.Template.Annotations[corev1.SeccompPodAnnotationKey]
+	if profile != corev1.SeccompProfileRuntimeDefault {
+		t.Errorf("want seccomp profile %s, got %s", corev1.SeccompProfileRuntimeDefault, profile)
+	}
+}
+
+func Test_configureSecurityContext_RestrictedPresetRejectsViolations(t *testing.T) {
+	root := int64(0)
+	escalation := true
+
+	cases := map[string]*faasv1.FunctionSecurity{
+		"root user":            {RunAsUser: &root},
+		"privilege escalation": {AllowPrivilegeEscalation: &escalation},
+		"added capability":     {AddCapabilities: []string{"SYS_ADMIN"}},
+		"unconfined seccomp":   {SeccompProfile: "unconfined"},
+	}
+
+	for name, security := range cases {
+		t.Run(name, func(t *testing.T) {
+			function := &faasv1.Function{
+				Spec: faasv1.FunctionSpec{Name: "testfunc", SecurityContext: security},
+			}
+
+			err := configureSecurityContext(function, newSecurityTestDeployment(), DeploymentConfig{Restricted: true})
+			if err == nil {
+				t.Errorf("want error, got nil")
+			}
+		})
+	}
+}
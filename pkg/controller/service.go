@@ -1,6 +1,8 @@
 package controller
 
 import (
+	"fmt"
+
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -9,11 +11,19 @@ import (
 	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
 )
 
-// newService creates a new ClusterIP Service for a Function resource. It also sets
-// the appropriate OwnerReferences on the resource so handleObject can discover
-// the Function resource that 'owns' it.
-func newService(function *faasv1.Function) *corev1.Service {
-	return &corev1.Service{
+const (
+	// serviceTypeHeadless is the function Service type for a ClusterIP Service without a cluster IP
+	serviceTypeHeadless = "Headless"
+
+	// annotationProtocol is the protocol spoken by the function: http, h2c or grpc
+	annotationProtocol = "com.openfaas.protocol"
+)
+
+// newService creates a new Service for a Function resource, ClusterIP unless the function
+// asks for another type. It also sets the appropriate OwnerReferences on the resource so
+// handleObject can discover the Function resource that 'owns' it.
+func newService(function *faasv1.Function) (*corev1.Service, error) {
+	service := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:        function.Spec.Name,
 			Namespace:   function.Namespace,
@@ -42,4 +52,95 @@ func newService(function *faasv1.Function) *corev1.Service {
 			},
 		},
 	}
+
+	if err := configureService(function, service); err != nil {
+		return nil, err
+	}
+
+	return service, nil
+}
+
+// configureService sets the type, node port, port name, labels and annotations requested in the
+// function spec on a new or existing Service. Annotations set by the operator take precedence.
+func configureService(function *faasv1.Function, service *corev1.Service) error {
+	fs := function.Spec.Service
+	if fs == nil {
+		fs = &faasv1.FunctionService{}
+	}
+
+	switch fs.Type {
+	case "", string(corev1.ServiceTypeClusterIP):
+		service.Spec.Type = corev1.ServiceTypeClusterIP
+	case serviceTypeHeadless:
+		service.Spec.Type = corev1.ServiceTypeClusterIP
+		service.Spec.ClusterIP = corev1.ClusterIPNone
+	case string(corev1.ServiceTypeNodePort), string(corev1.ServiceTypeLoadBalancer):
+		service.Spec.Type = corev1.ServiceType(fs.Type)
+	default:
+		return fmt.Errorf("service type '%s' is not supported", fs.Type)
+	}
+
+	exposed := service.Spec.Type == corev1.ServiceTypeNodePort || service.Spec.Type == corev1.ServiceTypeLoadBalancer
+	if fs.NodePort != 0 && !exposed {
+		return fmt.Errorf("nodePort requires a NodePort or LoadBalancer service")
+	}
+	if exposed {
+		// keep the port allocated by the cluster unless a port is requested
+		if fs.NodePort != 0 {
+			service.Spec.Ports[0].NodePort = fs.NodePort
+		}
+	} else {
+		service.Spec.Ports[0].NodePort = 0
+	}
+
+	portName, err := servicePortName(function)
+	if err != nil {
+		return err
+	}
+	service.Spec.Ports[0].Name = portName
+
+	labels := map[string]string{}
+	for k, v := range fs.Labels {
+		labels[k] = v
+	}
+	labels["faas_function"] = function.Spec.Name
+	service.Labels = labels
+
+	annotations := map[string]string{}
+	for k, v := range fs.Annotations {
+		annotations[k] = v
+	}
+	for k, v := range service.Annotations {
+		annotations[k] = v
+	}
+	service.Annotations = annotations
+
+	return nil
+}
+
+// serviceNeedsRecreate returns true when the Service must be deleted before the function
+// Service type can be applied, the cluster IP of a Service can't be changed
+func serviceNeedsRecreate(function *faasv1.Function, existing *corev1.Service) bool {
+	headless := function.Spec.Service != nil && function.Spec.Service.Type == serviceTypeHeadless
+	return headless != (existing.Spec.ClusterIP == corev1.ClusterIPNone)
+}
+
+// servicePortName names the function port after the protocol of the function, so service
+// meshes and ingress controllers pick the matching protocol
+func servicePortName(function *faasv1.Function) (string, error) {
+	protocol := ""
+	if function.Spec.Annotations != nil {
+		protocol = (*function.Spec.Annotations)[annotationProtocol]
+	}
+
+	switch protocol {
+	case "", "http":
+		return "http", nil
+	case "h2c":
+		return "http2", nil
+	case "grpc":
+		return "grpc", nil
+	}
+
+	return "", fmt.Errorf("protocol '%s' is not supported", protocol)
 }
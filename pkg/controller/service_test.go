@@ -0,0 +1,161 @@
+package controller
+
+import (
+	"testing"
+
+	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func Test_newService_Types(t *testing.T) {
+	scenarios := []struct {
+		name      string
+		fs        *faasv1.FunctionService
+		wantType  corev1.ServiceType
+		clusterIP string
+		nodePort  int32
+	}{
+		{"default", nil, corev1.ServiceTypeClusterIP, "", 0},
+		{"headless", &faasv1.FunctionService{Type: "Headless"}, corev1.ServiceTypeClusterIP, corev1.ClusterIPNone, 0},
+		{"node port", &faasv1.FunctionService{Type: "NodePort", NodePort: 31112}, corev1.ServiceTypeNodePort, "", 31112},
+		{"load balancer", &faasv1.FunctionService{Type: "LoadBalancer"}, corev1.ServiceTypeLoadBalancer, "", 0},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			function := &faasv1.Function{
+				Spec: faasv1.FunctionSpec{Name: "testfunc", Service: s.fs},
+			}
+
+			service, err := newService(function)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if service.Spec.Type != s.wantType {
+				t.Errorf("want type %s, got %s", s.wantType, service.Spec.Type)
+			}
+			if service.Spec.ClusterIP != s.clusterIP {
+				t.Errorf("want cluster IP '%s', got '%s'", s.clusterIP, service.Spec.ClusterIP)
+			}
+			if service.Spec.Ports[0].NodePort != s.nodePort {
+				t.Errorf("want node port %d, got %d", s.nodePort, service.Spec.Ports[0].NodePort)
+			}
+		})
+	}
+}
+
+func Test_newService_Invalid(t *testing.T) {
+	services := []*faasv1.FunctionService{
+		{Type: "ExternalName"},
+		{Type: "ClusterIP", NodePort: 31112},
+	}
+
+	for _, fs := range services {
+		function := &faasv1.Function{
+			Spec: faasv1.FunctionSpec{Name: "testfunc", Service: fs},
+		}
+		if _, err := newService(function); err == nil {
+			t.Errorf("want error for service %v", fs)
+		}
+	}
+}
+
+func Test_configureService_LabelsAndAnnotations(t *testing.T) {
+	function := &faasv1.Function{
+		Spec: faasv1.FunctionSpec{
+			Name: "testfunc",
+			Service: &faasv1.FunctionService{
+				Labels:      map[string]string{"team": "payments", "faas_function": "other"},
+				Annotations: map[string]string{"external-dns.alpha.kubernetes.io/hostname": "fn.example.com", "prometheus.io.scrape": "true"},
+			},
+		},
+	}
+
+	service, err := newService(function)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if service.Labels["team"] != "payments" || service.Labels["faas_function"] != "testfunc" {
+		t.Errorf("want team and faas_function labels, got %v", service.Labels)
+	}
+	if service.Annotations["external-dns.alpha.kubernetes.io/hostname"] != "fn.example.com" {
+		t.Errorf("want hostname annotation, got %v", service.Annotations)
+	}
+	if service.Annotations["prometheus.io.scrape"] != "false" {
+		t.Errorf("want operator annotation to take precedence, got %v", service.Annotations)
+	}
+}
+
+func Test_configureService_KeepsAllocatedNodePort(t *testing.T) {
+	function := &faasv1.Function{
+		Spec: faasv1.FunctionSpec{
+			Name:    "testfunc",
+			Service: &faasv1.FunctionService{Type: "NodePort"},
+		},
+	}
+
+	service, _ := newService(function)
+	service.Spec.Ports[0].NodePort = 30080
+
+	if err := configureService(function, service); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if service.Spec.Ports[0].NodePort != 30080 {
+		t.Errorf("want allocated node port kept, got %d", service.Spec.Ports[0].NodePort)
+	}
+
+	function.Spec.Service = nil
+	if err := configureService(function, service); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if service.Spec.Ports[0].NodePort != 0 {
+		t.Errorf("want node port cleared for ClusterIP, got %d", service.Spec.Ports[0].NodePort)
+	}
+}
+
+func Test_serviceNeedsRecreate(t *testing.T) {
+	function := &faasv1.Function{
+		Spec: faasv1.FunctionSpec{Name: "testfunc", Service: &faasv1.FunctionService{Type: "Headless"}},
+	}
+
+	existing := &corev1.Service{Spec: corev1.ServiceSpec{ClusterIP: "10.0.0.10"}}
+	if !serviceNeedsRecreate(function, existing) {
+		t.Errorf("want recreate when switching to headless")
+	}
+
+	existing.Spec.ClusterIP = corev1.ClusterIPNone
+	if serviceNeedsRecreate(function, existing) {
+		t.Errorf("want no recreate for a headless service")
+	}
+}
+
+func Test_newService_ProtocolPortName(t *testing.T) {
+	scenarios := map[string]string{"": "http", "http": "http", "h2c": "http2", "grpc": "grpc"}
+
+	for protocol, want := range scenarios {
+		annotations := map[string]string{}
+		if len(protocol) > 0 {
+			annotations[annotationProtocol] = protocol
+		}
+		function := &faasv1.Function{
+			Spec: faasv1.FunctionSpec{Name: "testfunc", Annotations: &annotations},
+		}
+
+		service, err := newService(function)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := service.Spec.Ports[0].Name; got != want {
+			t.Errorf("protocol '%s': want port name %s, got %s", protocol, want, got)
+		}
+	}
+
+	annotations := map[string]string{annotationProtocol: "websocket"}
+	function := &faasv1.Function{
+		Spec: faasv1.FunctionSpec{Name: "testfunc", Annotations: &annotations},
+	}
+	if _, err := newService(function); err == nil {
+		t.Errorf("want error for an unsupported protocol")
+	}
+}
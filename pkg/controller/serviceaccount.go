@@ -0,0 +1,109 @@
+package controller
+
+import (
+	"reflect"
+
+	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// newServiceAccount creates a new ServiceAccount for a Function resource that asks the
+// operator to manage its account. It also sets the appropriate OwnerReferences on the
+// resource so handleObject can discover the Function resource that 'owns' it.
+func newServiceAccount(function *faasv1.Function) *corev1.ServiceAccount {
+	account := function.Spec.ServiceAccount
+
+	pullSecrets := []corev1.LocalObjectReference{}
+	for _, secretName := range account.ImagePullSecrets {
+		pullSecrets = append(pullSecrets, corev1.LocalObjectReference{Name: secretName})
+	}
+
+	return &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      serviceAccountName(function),
+			Namespace: function.Namespace,
+			Labels:    map[string]string{"faas_function": function.Spec.Name},
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(function, schema.GroupVersionKind{
+					Group:   faasv1.SchemeGroupVersion.Group,
+					Version: faasv1.SchemeGroupVersion.Version,
+					Kind:    faasKind,
+				}),
+			},
+		},
+		ImagePullSecrets:             pullSecrets,
+		AutomountServiceAccountToken: account.AutomountToken,
+	}
+}
+
+// serviceAccountName returns the ServiceAccount the function pods run as,
+// an empty name means the namespace default account
+func serviceAccountName(function *faasv1.Function) string {
+	account := function.Spec.ServiceAccount
+	if account == nil {
+		return ""
+	}
+	if len(account.Name) == 0 && account.Create {
+		return function.Spec.Name
+	}
+
+	return account.Name
+}
+
+// ownsServiceAccount returns true when the operator has to create the function ServiceAccount
+func ownsServiceAccount(function *faasv1.Function) bool {
+	return function.Spec.ServiceAccount != nil && function.Spec.ServiceAccount.Create
+}
+
+// configureServiceAccount sets the ServiceAccount, token automount and registry secrets
+// of the function pods. Registry secrets of an account created by the operator are
+// attached to the account, otherwise they are added to the pod spec.
+func configureServiceAccount(function *faasv1.Function, podSpec *corev1.PodSpec) {
+	account := function.Spec.ServiceAccount
+	if account == nil {
+		return
+	}
+
+	podSpec.ServiceAccountName = serviceAccountName(function)
+	podSpec.AutomountServiceAccountToken = account.AutomountToken
+
+	if !account.Create {
+		for _, secretName := range account.ImagePullSecrets {
+			podSpec.ImagePullSecrets = addImagePullSecret(secretName, podSpec.ImagePullSecrets)
+		}
+	}
+}
+
+// serviceAccountNeedsUpdate determines if the owned ServiceAccount differs from the function spec
+func serviceAccountNeedsUpdate(function *faasv1.Function, existing *corev1.ServiceAccount) bool {
+	desired := newServiceAccount(function)
+
+	if len(desired.ImagePullSecrets) != len(existing.ImagePullSecrets) {
+		return true
+	}
+	if len(desired.ImagePullSecrets) > 0 && !reflect.DeepEqual(desired.ImagePullSecrets, existing.ImagePullSecrets) {
+		return true
+	}
+
+	return !reflect.DeepEqual(desired.AutomountServiceAccountToken, existing.AutomountServiceAccountToken)
+}
+
+// staleServiceAccounts returns the accounts created for the function that its pods no longer
+// run as, e.g. after serviceAccount.create was turned off or the account was renamed. Accounts
+// that pods, such as the terminating pods of the previous rollout, still run as are kept.
+func staleServiceAccounts(function *faasv1.Function, accounts []*corev1.ServiceAccount, pods []corev1.Pod) []*corev1.ServiceAccount {
+	inUse := map[string]bool{serviceAccountName(function): true}
+	for _, pod := range pods {
+		inUse[pod.Spec.ServiceAccountName] = true
+	}
+
+	stale := []*corev1.ServiceAccount{}
+	for _, account := range accounts {
+		if !inUse[account.Name] && metav1.IsControlledBy(account, function) {
+			stale = append(stale, account)
+		}
+	}
+	return stale
+}
@@ -0,0 +1,115 @@
+package controller
+
+import (
+	"testing"
+
+	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_configureServiceAccount_ExistingAccount(t *testing.T) {
+	automount := false
+	function := &faasv1.Function{
+		Spec: faasv1.FunctionSpec{
+			Name: "testfunc",
+			ServiceAccount: &faasv1.FunctionAccount{
+				Name:             "reader",
+				AutomountToken:   &automount,
+				ImagePullSecrets: []string{"registry"},
+			},
+		},
+	}
+
+	podSpec := &corev1.PodSpec{}
+	configureServiceAccount(function, podSpec)
+
+	if podSpec.ServiceAccountName != "reader" {
+		t.Errorf("want service account reader, got %s", podSpec.ServiceAccountName)
+	}
+	if podSpec.AutomountServiceAccountToken == nil || *podSpec.AutomountServiceAccountToken {
+		t.Errorf("want token automount disabled")
+	}
+	if len(podSpec.ImagePullSecrets) != 1 || podSpec.ImagePullSecrets[0].Name != "registry" {
+		t.Errorf("want registry image pull secret on the pod, got %v", podSpec.ImagePullSecrets)
+	}
+}
+
+func Test_configureServiceAccount_OwnedAccount(t *testing.T) {
+	function := &faasv1.Function{
+		Spec: faasv1.FunctionSpec{
+			Name: "testfunc",
+			ServiceAccount: &faasv1.FunctionAccount{
+				Create:           true,
+				ImagePullSecrets: []string{"registry"},
+			},
+		},
+	}
+
+	podSpec := &corev1.PodSpec{}
+	configureServiceAccount(function, podSpec)
+
+	if podSpec.ServiceAccountName != "testfunc" {
+		t.Errorf("want service account testfunc, got %s", podSpec.ServiceAccountName)
+	}
+	if len(podSpec.ImagePullSecrets) != 0 {
+		t.Errorf("want image pull secrets on the account only, got %v", podSpec.ImagePullSecrets)
+	}
+
+	account := newServiceAccount(function)
+	if len(account.ImagePullSecrets) != 1 || account.ImagePullSecrets[0].Name != "registry" {
+		t.Errorf("want registry image pull secret on the account, got %v", account.ImagePullSecrets)
+	}
+	if serviceAccountNeedsUpdate(function, account) {
+		t.Errorf("want no update for an account built from the same spec")
+	}
+}
+
+func Test_configureServiceAccount_DefaultAccount(t *testing.T) {
+	function := &faasv1.Function{
+		Spec: faasv1.FunctionSpec{Name: "testfunc"},
+	}
+
+	podSpec := &corev1.PodSpec{}
+	configureServiceAccount(function, podSpec)
+
+	if podSpec.ServiceAccountName != "" || podSpec.AutomountServiceAccountToken != nil {
+		t.Errorf("want namespace default account, got %s", podSpec.ServiceAccountName)
+	}
+}
+
+func Test_staleServiceAccounts(t *testing.T) {
+	function := &faasv1.Function{
+		ObjectMeta: metav1.ObjectMeta{Name: "testfunc", UID: "1234"},
+		Spec: faasv1.FunctionSpec{
+			Name:           "testfunc",
+			ServiceAccount: &faasv1.FunctionAccount{Create: true},
+		},
+	}
+	owned := newServiceAccount(function)
+	other := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "reader"}}
+	accounts := []*corev1.ServiceAccount{owned, other}
+
+	if stale := staleServiceAccounts(function, accounts, nil); len(stale) != 0 {
+		t.Errorf("want the owned account kept while it's used, got %v", stale)
+	}
+
+	// the operator stops managing the account when create is turned off
+	function.Spec.ServiceAccount.Create = false
+	stale := staleServiceAccounts(function, accounts, nil)
+	if len(stale) != 1 || stale[0].Name != "testfunc" {
+		t.Errorf("want the owned account deleted, got %v", stale)
+	}
+
+	// pods of the previous rollout still running as the account keep it
+	oldPod := corev1.Pod{Spec: corev1.PodSpec{ServiceAccountName: "testfunc"}}
+	if stale := staleServiceAccounts(function, accounts, []corev1.Pod{oldPod}); len(stale) != 0 {
+		t.Errorf("want the account kept while a pod runs as it, got %v", stale)
+	}
+
+	// an owned account the function still names is kept
+	function.Spec.ServiceAccount.Name = "testfunc"
+	if stale := staleServiceAccounts(function, accounts, nil); len(stale) != 0 {
+		t.Errorf("want the named account kept, got %v", stale)
+	}
+}
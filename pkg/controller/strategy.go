@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"fmt"
+
+	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
+	appsv1beta2 "k8s.io/api/apps/v1beta2"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const defaultRevisionHistoryLimit = 5
+
+// configureStrategy sets the rollout strategy of the function Deployment. Without a strategy
+// in the function spec new pods are rolled out one at a time and no pod is taken down before
+// its replacement is available.
+func configureStrategy(function *faasv1.Function, deployment *appsv1beta2.Deployment) error {
+	strategy := function.Spec.Strategy
+	if strategy == nil {
+		strategy = &faasv1.FunctionStrategy{}
+	}
+
+	deployment.Spec.MinReadySeconds = strategy.MinReadySeconds
+	deployment.Spec.ProgressDeadlineSeconds = strategy.ProgressDeadlineSeconds
+	deployment.Spec.RevisionHistoryLimit = int32p(defaultRevisionHistoryLimit)
+	if strategy.RevisionHistoryLimit != nil {
+		deployment.Spec.RevisionHistoryLimit = strategy.RevisionHistoryLimit
+	}
+
+	switch appsv1beta2.DeploymentStrategyType(strategy.Type) {
+	case appsv1beta2.RecreateDeploymentStrategyType:
+		if strategy.MaxSurge != nil || strategy.MaxUnavailable != nil {
+			return fmt.Errorf("maxSurge and maxUnavailable can't be set with the Recreate strategy")
+		}
+
+		deployment.Spec.Strategy = appsv1beta2.DeploymentStrategy{
+			Type: appsv1beta2.RecreateDeploymentStrategyType,
+		}
+
+	case "", appsv1beta2.RollingUpdateDeploymentStrategyType:
+		maxSurge := intstr.FromInt(1)
+		if strategy.MaxSurge != nil {
+			maxSurge = *strategy.MaxSurge
+		}
+		maxUnavailable := intstr.FromInt(0)
+		if strategy.MaxUnavailable != nil {
+			maxUnavailable = *strategy.MaxUnavailable
+		}
+
+		surge, err := intstr.GetValueFromIntOrPercent(&maxSurge, 100, true)
+		if err != nil {
+			return fmt.Errorf("invalid maxSurge: %v", err)
+		}
+		unavailable, err := intstr.GetValueFromIntOrPercent(&maxUnavailable, 100, false)
+		if err != nil {
+			return fmt.Errorf("invalid maxUnavailable: %v", err)
+		}
+		if surge < 0 || unavailable < 0 {
+			return fmt.Errorf("maxSurge and maxUnavailable can't be negative")
+		}
+		if surge == 0 && unavailable == 0 {
+			return fmt.Errorf("maxSurge and maxUnavailable can't both be zero")
+		}
+
+		deployment.Spec.Strategy = appsv1beta2.DeploymentStrategy{
+			Type: appsv1beta2.RollingUpdateDeploymentStrategyType,
+			RollingUpdate: &appsv1beta2.RollingUpdateDeployment{
+				MaxUnavailable: &maxUnavailable,
+				MaxSurge:       &maxSurge,
+			},
+		}
+
+	default:
+		return fmt.Errorf("unknown strategy type '%s'", strategy.Type)
+	}
+
+	return nil
+}
+
+// rolloutComplete returns true when the Deployment controller has rolled out the latest spec
+// and no pods of older ReplicaSets are left
+func rolloutComplete(deployment *appsv1beta2.Deployment) bool {
+	status := deployment.Status
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	return status.ObservedGeneration >= deployment.Generation &&
+		status.UpdatedReplicas == replicas &&
+		status.Replicas == replicas
+}
@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"testing"
+
+	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
+	appsv1beta2 "k8s.io/api/apps/v1beta2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func Test_configureStrategy_Default(t *testing.T) {
+	function := &faasv1.Function{
+		Spec: faasv1.FunctionSpec{Name: "testfunc"},
+	}
+
+	deployment := &appsv1beta2.Deployment{}
+	if err := configureStrategy(function, deployment); err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+
+	rollingUpdate := deployment.Spec.Strategy.RollingUpdate
+	if deployment.Spec.Strategy.Type != appsv1beta2.RollingUpdateDeploymentStrategyType || rollingUpdate == nil {
+		t.Fatalf("want RollingUpdate strategy, got %s", deployment.Spec.Strategy.Type)
+	}
+	if rollingUpdate.MaxSurge.IntValue() != 1 || rollingUpdate.MaxUnavailable.IntValue() != 0 {
+		t.Errorf("want maxSurge 1 and maxUnavailable 0, got %s and %s",
+			rollingUpdate.MaxSurge.String(), rollingUpdate.MaxUnavailable.String())
+	}
+	if *deployment.Spec.RevisionHistoryLimit != defaultRevisionHistoryLimit {
+		t.Errorf("want revision history limit %d, got %d", defaultRevisionHistoryLimit, *deployment.Spec.RevisionHistoryLimit)
+	}
+}
+
+func Test_configureStrategy_Percentages(t *testing.T) {
+	maxSurge := intstr.FromString("25%")
+	maxUnavailable := intstr.FromString("10%")
+	function := &faasv1.Function{
+		Spec: faasv1.FunctionSpec{
+			Name: "testfunc",
+			Strategy: &faasv1.FunctionStrategy{
+				MaxSurge:                &maxSurge,
+				MaxUnavailable:          &maxUnavailable,
+				MinReadySeconds:         10,
+				ProgressDeadlineSeconds: int32p(300),
+				RevisionHistoryLimit:    int32p(2),
+			},
+		},
+	}
+
+	deployment := &appsv1beta2.Deployment{}
+	if err := configureStrategy(function, deployment); err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+
+	rollingUpdate := deployment.Spec.Strategy.RollingUpdate
+	if rollingUpdate.MaxSurge.String() != "25%" || rollingUpdate.MaxUnavailable.String() != "10%" {
+		t.Errorf("want maxSurge 25%% and maxUnavailable 10%%, got %s and %s",
+			rollingUpdate.MaxSurge.String(), rollingUpdate.MaxUnavailable.String())
+	}
+	if deployment.Spec.MinReadySeconds != 10 || *deployment.Spec.ProgressDeadlineSeconds != 300 ||
+		*deployment.Spec.RevisionHistoryLimit != 2 {
+		t.Errorf("unexpected deployment spec %+v", deployment.Spec)
+	}
+}
+
+func Test_configureStrategy_Recreate(t *testing.T) {
+	function := &faasv1.Function{
+		Spec: faasv1.FunctionSpec{
+			Name:     "testfunc",
+			Strategy: &faasv1.FunctionStrategy{Type: "Recreate"},
+		},
+	}
+
+	deployment := &appsv1beta2.Deployment{}
+	if err := configureStrategy(function, deployment); err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+
+	if deployment.Spec.Strategy.Type != appsv1beta2.RecreateDeploymentStrategyType || deployment.Spec.Strategy.RollingUpdate != nil {
+		t.Errorf("want Recreate strategy, got %+v", deployment.Spec.Strategy)
+	}
+}
+
+func Test_configureStrategy_Invalid(t *testing.T) {
+	zero := intstr.FromInt(0)
+	invalid := intstr.FromString("lots")
+
+	cases := map[string]*faasv1.FunctionStrategy{
+		"unknown type":           {Type: "BlueGreen"},
+		"recreate with surge":    {Type: "Recreate", MaxSurge: &zero},
+		"zero surge unavailable": {MaxSurge: &zero, MaxUnavailable: &zero},
+		"invalid percentage":     {MaxSurge: &invalid},
+	}
+
+	for name, strategy := range cases {
+		t.Run(name, func(t *testing.T) {
+			function := &faasv1.Function{
+				Spec: faasv1.FunctionSpec{Name: "testfunc", Strategy: strategy},
+			}
+			if err := configureStrategy(function, &appsv1beta2.Deployment{}); err == nil {
+				t.Errorf("want error, got nil")
+			}
+		})
+	}
+}
+
+func Test_rolloutComplete(t *testing.T) {
+	deployment := &appsv1beta2.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Generation: 2},
+		Spec:       appsv1beta2.DeploymentSpec{Replicas: int32p(2)},
+		Status:     appsv1beta2.DeploymentStatus{ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 2},
+	}
+	if rolloutComplete(deployment) {
+		t.Errorf("want the rollout in progress while an old pod is left")
+	}
+
+	deployment.Status.Replicas = 2
+	if !rolloutComplete(deployment) {
+		t.Errorf("want the rollout complete")
+	}
+
+	deployment.Generation = 3
+	if rolloutComplete(deployment) {
+		t.Errorf("want the rollout in progress until the new spec is observed")
+	}
+}
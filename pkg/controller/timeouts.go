@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// timeoutEnvVars maps the watchdog environment variables to the timeout annotations,
+// the timeouts in the function spec take precedence over the annotations
+var timeoutEnvVars = []struct {
+	name       string
+	annotation string
+	value      func(*faasv1.FunctionTimeouts) string
+}{
+	{"read_timeout", "com.openfaas.timeout.read", func(t *faasv1.FunctionTimeouts) string { return t.Read }},
+	{"write_timeout", "com.openfaas.timeout.write", func(t *faasv1.FunctionTimeouts) string { return t.Write }},
+	{"exec_timeout", "com.openfaas.timeout.exec", func(t *faasv1.FunctionTimeouts) string { return t.Exec }},
+}
+
+// setTimeoutEnvVars sets the watchdog timeouts of the function, replacing environment
+// variables of the same name so the watchdog and the proxy use the same limits
+func setTimeoutEnvVars(function *faasv1.Function, envVars []corev1.EnvVar) []corev1.EnvVar {
+	annotations := map[string]string{}
+	if function.Spec.Annotations != nil {
+		annotations = *function.Spec.Annotations
+	}
+
+	for _, timeout := range timeoutEnvVars {
+		value := annotations[timeout.annotation]
+		if function.Spec.Timeouts != nil && len(timeout.value(function.Spec.Timeouts)) > 0 {
+			value = timeout.value(function.Spec.Timeouts)
+		}
+		if len(value) == 0 {
+			continue
+		}
+		if _, err := time.ParseDuration(value); err != nil {
+			glog.Warningf("Function %s has an invalid %s: %s", function.Spec.Name, timeout.name, value)
+			continue
+		}
+
+		envVars = removeEnvVar(timeout.name, envVars)
+		envVars = append(envVars, corev1.EnvVar{Name: timeout.name, Value: value})
+	}
+
+	return envVars
+}
+
+func removeEnvVar(name string, envVars []corev1.EnvVar) []corev1.EnvVar {
+	kept := []corev1.EnvVar{}
+	for _, envVar := range envVars {
+		if envVar.Name != name {
+			kept = append(kept, envVar)
+		}
+	}
+	return kept
+}
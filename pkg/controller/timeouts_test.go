@@ -0,0 +1,38 @@
+package controller
+
+import (
+	"testing"
+
+	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func Test_setTimeoutEnvVars(t *testing.T) {
+	function := &faasv1.Function{
+		Spec: faasv1.FunctionSpec{
+			Name: "testfunc",
+			Annotations: &map[string]string{
+				"com.openfaas.timeout.read":  "10s",
+				"com.openfaas.timeout.write": "10s",
+				"com.openfaas.timeout.exec":  "forever",
+			},
+			Timeouts: &faasv1.FunctionTimeouts{Write: "2m"},
+		},
+	}
+	envVars := []corev1.EnvVar{
+		{Name: "fprocess", Value: "cat"},
+		{Name: "write_timeout", Value: "5s"},
+	}
+
+	envVars = setTimeoutEnvVars(function, envVars)
+
+	want := map[string]string{"fprocess": "cat", "read_timeout": "10s", "write_timeout": "2m"}
+	if len(envVars) != len(want) {
+		t.Fatalf("want %d env vars, got %v", len(want), envVars)
+	}
+	for _, envVar := range envVars {
+		if want[envVar.Name] != envVar.Value {
+			t.Errorf("want %s=%s, got %s", envVar.Name, want[envVar.Name], envVar.Value)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
+	appsv1beta2 "k8s.io/api/apps/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// configureVolumes will create or update the volumes and mounts requested in the function spec.
+// Volumes are removed by name and added again, so the method is safe for both create and update
+// operations. Volume names reserved by the operator are rejected.
+func configureVolumes(function *faasv1.Function, deployment *appsv1beta2.Deployment) error {
+	reserved := map[string]bool{
+		"temp": true,
+		fmt.Sprintf("%s-projected-secrets", function.Spec.Name): true,
+	}
+
+	volumes := []corev1.Volume{}
+	mounts := []corev1.VolumeMount{}
+	seen := map[string]bool{}
+
+	for _, fv := range function.Spec.Volumes {
+		if len(fv.Name) == 0 || len(fv.MountPath) == 0 {
+			return fmt.Errorf("volume name and mountPath are required")
+		}
+		if reserved[fv.Name] {
+			return fmt.Errorf("volume name '%s' is reserved", fv.Name)
+		}
+		if seen[fv.Name] {
+			return fmt.Errorf("volume name '%s' is used more than once", fv.Name)
+		}
+		seen[fv.Name] = true
+
+		source, err := makeVolumeSource(fv)
+		if err != nil {
+			return fmt.Errorf("volume '%s': %v", fv.Name, err)
+		}
+
+		volumes = append(volumes, corev1.Volume{Name: fv.Name, VolumeSource: *source})
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      fv.Name,
+			MountPath: fv.MountPath,
+			SubPath:   fv.SubPath,
+			ReadOnly:  fv.ReadOnly,
+		})
+	}
+
+	existingVolumes := deployment.Spec.Template.Spec.Volumes
+	existingMounts := deployment.Spec.Template.Spec.Containers[0].VolumeMounts
+	for name := range seen {
+		existingVolumes = removeVolume(name, existingVolumes)
+		existingMounts = removeVolumeMount(name, existingMounts)
+	}
+
+	deployment.Spec.Template.Spec.Volumes = append(existingVolumes, volumes...)
+	deployment.Spec.Template.Spec.Containers[0].VolumeMounts = append(existingMounts, mounts...)
+
+	return nil
+}
+
+func makeVolumeSource(fv faasv1.FunctionVolume) (*corev1.VolumeSource, error) {
+	sources := 0
+	source := &corev1.VolumeSource{}
+
+	if fv.ConfigMap != nil {
+		sources++
+
+		items := []corev1.KeyToPath{}
+		keys := []string{}
+		for key := range fv.ConfigMap.Items {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			items = append(items, corev1.KeyToPath{Key: key, Path: fv.ConfigMap.Items[key]})
+		}
+
+		optional := fv.ConfigMap.Optional
+		source.ConfigMap = &corev1.ConfigMapVolumeSource{
+			LocalObjectReference: corev1.LocalObjectReference{Name: fv.ConfigMap.Name},
+			Items:                items,
+			DefaultMode:          fv.ConfigMap.DefaultMode,
+			Optional:             &optional,
+		}
+	}
+
+	if fv.EmptyDir != nil {
+		sources++
+
+		source.EmptyDir = &corev1.EmptyDirVolumeSource{
+			Medium: corev1.StorageMedium(fv.EmptyDir.Medium),
+		}
+		if len(fv.EmptyDir.SizeLimit) > 0 {
+			qty, err := resource.ParseQuantity(fv.EmptyDir.SizeLimit)
+			if err != nil {
+				return nil, err
+			}
+			source.EmptyDir.SizeLimit = &qty
+		}
+	}
+
+	if fv.PersistentVolumeClaim != nil {
+		sources++
+
+		source.PersistentVolumeClaim = &corev1.PersistentVolumeClaimVolumeSource{
+			ClaimName: fv.PersistentVolumeClaim.ClaimName,
+			ReadOnly:  fv.ReadOnly,
+		}
+	}
+
+	if sources != 1 {
+		return nil, fmt.Errorf("exactly one of configMap, emptyDir or persistentVolumeClaim must be set")
+	}
+
+	return source, nil
+}
+
+// checkMountPaths returns an error when two volume mounts of the same container
+// share a mount path or one is mounted inside the other
+func checkMountPaths(deployment *appsv1beta2.Deployment) error {
+	containers := append([]corev1.Container{}, deployment.Spec.Template.Spec.InitContainers...)
+	containers = append(containers, deployment.Spec.Template.Spec.Containers...)
+
+	for _, container := range containers {
+		paths := map[string]string{}
+		for _, mount := range container.VolumeMounts {
+			path := filepath.Clean(mount.MountPath)
+			if name, ok := findPathCollision(path, paths); ok {
+				return fmt.Errorf("volumes '%s' and '%s' have overlapping mount paths in container '%s'",
+					name, mount.Name, container.Name)
+			}
+			paths[path] = mount.Name
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,189 @@
+package controller
+
+import (
+	"testing"
+
+	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
+	appsv1beta2 "k8s.io/api/apps/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func Test_configureVolumes_AddsVolumesAndMounts(t *testing.T) {
+	deployment := &appsv1beta2.Deployment{
+		Spec: appsv1beta2.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{Name: "testfunc", Image: "alpine:latest"},
+					},
+				},
+			},
+		},
+	}
+
+	function := &faasv1.Function{
+		Spec: faasv1.FunctionSpec{
+			Name: "testfunc",
+			Volumes: []faasv1.FunctionVolume{
+				{
+					Name:      "config",
+					MountPath: "/etc/config",
+					ReadOnly:  true,
+					ConfigMap: &faasv1.FunctionConfigMapVolume{
+						Name:  "testconfig",
+						Items: map[string]string{"b": "b.json", "a": "a.json"},
+					},
+				},
+				{
+					Name:      "cache",
+					MountPath: "/cache",
+					EmptyDir:  &faasv1.FunctionEmptyDirVolume{SizeLimit: "64Mi"},
+				},
+				{
+					Name:                  "data",
+					MountPath:             "/data",
+					PersistentVolumeClaim: &faasv1.FunctionPersistentVolumeClaim{ClaimName: "testclaim"},
+				},
+			},
+		},
+	}
+
+	// run twice to check that the update path does not duplicate volumes
+	for i := 0; i < 2; i++ {
+		if err := configureVolumes(function, deployment); err != nil {
+			t.Fatalf("unexpected error %s", err.Error())
+		}
+	}
+
+	volumes := deployment.Spec.Template.Spec.Volumes
+	if len(volumes) != 3 {
+		t.Fatalf("want 3 volumes, got %d", len(volumes))
+	}
+
+	mounts := deployment.Spec.Template.Spec.Containers[0].VolumeMounts
+	if len(mounts) != 3 {
+		t.Fatalf("want 3 volume mounts, got %d", len(mounts))
+	}
+
+	items := volumes[0].ConfigMap.Items
+	if len(items) != 2 || items[0].Key != "a" || items[0].Path != "a.json" {
+		t.Errorf("unexpected config map items %v", items)
+	}
+
+	if volumes[1].EmptyDir.SizeLimit.String() != "64Mi" {
+		t.Errorf("want emptyDir size limit 64Mi, got %s", volumes[1].EmptyDir.SizeLimit.String())
+	}
+
+	if volumes[2].PersistentVolumeClaim.ClaimName != "testclaim" {
+		t.Errorf("want claim testclaim, got %s", volumes[2].PersistentVolumeClaim.ClaimName)
+	}
+}
+
+func Test_configureVolumes_RejectsInvalidVolumes(t *testing.T) {
+	cases := []struct {
+		name   string
+		volume faasv1.FunctionVolume
+	}{
+		{"no source", faasv1.FunctionVolume{Name: "data", MountPath: "/data"}},
+		{"reserved name", faasv1.FunctionVolume{Name: "temp", MountPath: "/data", EmptyDir: &faasv1.FunctionEmptyDirVolume{}}},
+		{"two sources", faasv1.FunctionVolume{
+			Name:      "data",
+			MountPath: "/data",
+			EmptyDir:  &faasv1.FunctionEmptyDirVolume{},
+			ConfigMap: &faasv1.FunctionConfigMapVolume{Name: "testconfig"},
+		}},
+		{"bad size limit", faasv1.FunctionVolume{Name: "data", MountPath: "/data", EmptyDir: &faasv1.FunctionEmptyDirVolume{SizeLimit: "lots"}}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			deployment := &appsv1beta2.Deployment{
+				Spec: appsv1beta2.DeploymentSpec{
+					Template: corev1.PodTemplateSpec{
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{
+								{Name: "testfunc", Image: "alpine:latest"},
+							},
+						},
+					},
+				},
+			}
+			function := &faasv1.Function{
+				Spec: faasv1.FunctionSpec{
+					Name:    "testfunc",
+					Volumes: []faasv1.FunctionVolume{c.volume},
+				},
+			}
+
+			if err := configureVolumes(function, deployment); err == nil {
+				t.Errorf("want error, got nil")
+			}
+		})
+	}
+}
+
+func Test_newDeployment_RejectsMountPathCollisions(t *testing.T) {
+	function := &faasv1.Function{
+		Spec: faasv1.FunctionSpec{
+			Name:                   "testfunc",
+			Image:                  "alpine:latest",
+			ReadOnlyRootFilesystem: true,
+			Volumes: []faasv1.FunctionVolume{
+				{Name: "scratch", MountPath: "/tmp/", EmptyDir: &faasv1.FunctionEmptyDirVolume{}},
+			},
+		},
+	}
+
+	_, err := newDeployment(function, map[string]*corev1.Secret{}, DeploymentConfig{ImagePullPolicy: corev1.PullAlways})
+	if err == nil {
+		t.Errorf("want mount path collision error, got nil")
+	}
+}
+
+func Test_newDeployment_RejectsNestedMountPaths(t *testing.T) {
+	cases := map[string][]faasv1.FunctionVolume{
+		"nested volumes": {
+			{Name: "data", MountPath: "/data", EmptyDir: &faasv1.FunctionEmptyDirVolume{}},
+			{Name: "cache", MountPath: "/data/cache", EmptyDir: &faasv1.FunctionEmptyDirVolume{}},
+		},
+		"volume over the secrets": {
+			{Name: "openfaas", MountPath: "/var/openfaas", EmptyDir: &faasv1.FunctionEmptyDirVolume{}},
+		},
+	}
+
+	for name, volumes := range cases {
+		t.Run(name, func(t *testing.T) {
+			function := &faasv1.Function{
+				Spec: faasv1.FunctionSpec{
+					Name:    "testfunc",
+					Image:   "alpine:latest",
+					Secrets: []string{"testsecret"},
+					Volumes: volumes,
+				},
+			}
+			existingSecrets := map[string]*corev1.Secret{
+				"testsecret": {Type: corev1.SecretTypeOpaque, Data: map[string][]byte{"filename": []byte("contents")}},
+			}
+
+			_, err := newDeployment(function, existingSecrets, DeploymentConfig{})
+			if err == nil {
+				t.Errorf("want mount path collision error, got nil")
+			}
+		})
+	}
+
+	// siblings with a common prefix don't overlap
+	function := &faasv1.Function{
+		Spec: faasv1.FunctionSpec{
+			Name:  "testfunc",
+			Image: "alpine:latest",
+			Volumes: []faasv1.FunctionVolume{
+				{Name: "data", MountPath: "/data", EmptyDir: &faasv1.FunctionEmptyDirVolume{}},
+				{Name: "data2", MountPath: "/data2", EmptyDir: &faasv1.FunctionEmptyDirVolume{}},
+			},
+		},
+	}
+	if _, err := newDeployment(function, map[string]*corev1.Secret{}, DeploymentConfig{}); err != nil {
+		t.Errorf("unexpected error %s", err.Error())
+	}
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	mathrand "math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	accessLogNone = "none"
+	accessLogJSON = "json"
+	accessLogCLF  = "clf"
+
+	// callIDHeader carries the id of an invocation, it's kept when the gateway already set one
+	callIDHeader = "X-Call-Id"
+	maxCallIDLen = 128
+
+	redactedValue = "[redacted]"
+	// redactQueryPrefix redacts the value of a single query parameter, e.g. query.token
+	redactQueryPrefix = "query."
+)
+
+// redactableFields are the access log fields that can be redacted
+var redactableFields = map[string]bool{
+	"path":       true,
+	"query":      true,
+	"client_ip":  true,
+	"user_agent": true,
+}
+
+// accessLogEntry is an access log line in the json format
+type accessLogEntry struct {
+	Time            string  `json:"time"`
+	CallID          string  `json:"call_id"`
+	Function        string  `json:"function"`
+	Method          string  `json:"method"`
+	Path            string  `json:"path"`
+	Query           string  `json:"query,omitempty"`
+	Protocol        string  `json:"protocol"`
+	Status          int     `json:"status"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	RequestSize     int64   `json:"request_size"`
+	ResponseSize    int64   `json:"response_size"`
+	ClientIP        string  `json:"client_ip"`
+	UserAgent       string  `json:"user_agent,omitempty"`
+}
+
+// accessLogger writes a line per invocation. Successful invocations are sampled, responses
+// with a 4xx or 5xx status are always logged.
+type accessLogger struct {
+	format      string
+	sampleRate  float64
+	redact      map[string]bool
+	redactQuery map[string]bool
+	out         *log.Logger
+	// proxies are the trusted proxies the client IP is read from
+	proxies trustedProxies
+}
+
+// newAccessLogger creates a logger for the json or clf format, nil is returned for none.
+// redact lists the fields and query.<name> parameters whose values are left out.
+func newAccessLogger(format string, sampleRate float64, redact []string, out io.Writer) (*accessLogger, error) {
+	switch format {
+	case "", accessLogNone:
+		return nil, nil
+	case accessLogJSON, accessLogCLF:
+	default:
+		return nil, fmt.Errorf("access log format '%s' is not supported", format)
+	}
+
+	l := &accessLogger{
+		format:      format,
+		sampleRate:  sampleRate,
+		redact:      map[string]bool{},
+		redactQuery: map[string]bool{},
+		out:         log.New(out, "", 0),
+	}
+	for _, field := range redact {
+		field = strings.TrimSpace(field)
+		switch {
+		case len(field) == 0:
+		case strings.HasPrefix(field, redactQueryPrefix):
+			l.redactQuery[strings.TrimPrefix(field, redactQueryPrefix)] = true
+		case redactableFields[field]:
+			l.redact[field] = true
+		default:
+			return nil, fmt.Errorf("access log field '%s' can't be redacted", field)
+		}
+	}
+	return l, nil
+}
+
+// accessLog sets the X-Call-Id of the invocation on the request forwarded to the function and
+// on the response, and logs the invocation when there is a logger
+func accessLog(logger *accessLogger, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := callID(r)
+		r.Header.Set(callIDHeader, id)
+		w.Header().Set(callIDHeader, id)
+
+		if logger == nil {
+			next(w, r)
+			return
+		}
+
+		mw := &metricsWriter{ResponseWriter: w}
+		body := &countingReader{reader: r.Body}
+		if r.Body != nil {
+			r.Body = body
+		}
+
+		next(mw, r)
+
+		status := mw.status()
+		if status < http.StatusBadRequest && mathrand.Float64() >= logger.sampleRate {
+			return
+		}
+		logger.log(accessLogEntry{
+			Time:            start.UTC().Format(time.RFC3339Nano),
+			CallID:          id,
+			Function:        mux.Vars(r)["name"],
+			Method:          r.Method,
+			Path:            r.URL.EscapedPath(),
+			Query:           r.URL.RawQuery,
+			Protocol:        r.Proto,
+			Status:          status,
+			DurationSeconds: time.Since(start).Seconds(),
+			RequestSize:     body.count,
+			ResponseSize:    mw.written,
+			ClientIP:        logger.proxies.clientIP(r),
+			UserAgent:       r.UserAgent(),
+		}, start)
+	}
+}
+
+func (l *accessLogger) log(entry accessLogEntry, start time.Time) {
+	l.redactEntry(&entry)
+
+	if l.format == accessLogCLF {
+		l.out.Print(formatCLF(entry, start))
+		return
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	l.out.Print(string(line))
+}
+
+func (l *accessLogger) redactEntry(entry *accessLogEntry) {
+	if l.redact["path"] {
+		entry.Path = redactedValue
+	}
+	if l.redact["query"] && len(entry.Query) > 0 {
+		entry.Query = redactedValue
+	} else if len(l.redactQuery) > 0 {
+		entry.Query = redactQueryParams(entry.Query, l.redactQuery)
+	}
+	if l.redact["client_ip"] {
+		entry.ClientIP = redactedValue
+	}
+	if l.redact["user_agent"] && len(entry.UserAgent) > 0 {
+		entry.UserAgent = redactedValue
+	}
+}
+
+// redactQueryParams replaces the values of the named parameters, the order of the query is kept
+func redactQueryParams(query string, names map[string]bool) string {
+	params := strings.Split(query, "&")
+	for i, param := range params {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) == 2 && names[kv[0]] {
+			params[i] = kv[0] + "=" + redactedValue
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// formatCLF formats the entry in the common log format followed by the function,
+// the call id and the duration in seconds
+func formatCLF(entry accessLogEntry, start time.Time) string {
+	target := entry.Path
+	if len(entry.Query) > 0 {
+		target += "?" + entry.Query
+	}
+	size := "-"
+	if entry.ResponseSize > 0 {
+		size = strconv.FormatInt(entry.ResponseSize, 10)
+	}
+	return fmt.Sprintf(`%s - - [%s] "%s %s %s" %d %s "%s" "%s" %.6f`,
+		entry.ClientIP, start.Format("02/Jan/2006:15:04:05 -0700"),
+		entry.Method, target, entry.Protocol, entry.Status, size,
+		entry.Function, entry.CallID, entry.DurationSeconds)
+}
+
+// callID returns the X-Call-Id of the request, or a new random id when it's missing or
+// isn't safe to log
+func callID(r *http.Request) string {
+	if id := r.Header.Get(callIDHeader); validCallID(id) {
+		return id
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	// a version 4 UUID
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func validCallID(id string) bool {
+	if len(id) == 0 || len(id) > maxCallIDLen {
+		return false
+	}
+	for _, c := range id {
+		valid := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == ':'
+		if !valid {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func newAccessLogRequest(target string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader("hello"))
+	r.RemoteAddr = "10.0.0.1:51234"
+	r.Header.Set("User-Agent", "curl/7.61.0")
+	return mux.SetURLVars(r, map[string]string{"name": "echo"})
+}
+
+func echoHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Forwarded-Call-Id", r.Header.Get(callIDHeader))
+	w.Write([]byte("hello world"))
+}
+
+func Test_accessLog_JSON(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger, err := newAccessLogger(accessLogJSON, 1, nil, out)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	r := newAccessLogRequest("/function/echo?name=a")
+	r.Header.Set(callIDHeader, "gateway-call-1")
+	w := httptest.NewRecorder()
+	accessLog(logger, echoHandler)(w, r)
+
+	if got := w.Header().Get(callIDHeader); got != "gateway-call-1" {
+		t.Errorf("want the propagated call id on the response, got %q", got)
+	}
+	if got := w.Header().Get("X-Forwarded-Call-Id"); got != "gateway-call-1" {
+		t.Errorf("want the propagated call id on the request, got %q", got)
+	}
+
+	entry := accessLogEntry{}
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("want a json line, got %q: %v", out.String(), err)
+	}
+	want := accessLogEntry{
+		Time:            entry.Time,
+		CallID:          "gateway-call-1",
+		Function:        "echo",
+		Method:          http.MethodPost,
+		Path:            "/function/echo",
+		Query:           "name=a",
+		Protocol:        "HTTP/1.1",
+		Status:          http.StatusOK,
+		DurationSeconds: entry.DurationSeconds,
+		RequestSize:     0,
+		ResponseSize:    11,
+		ClientIP:        "10.0.0.1",
+		UserAgent:       "curl/7.61.0",
+	}
+	if entry != want {
+		t.Errorf("want %+v, got %+v", want, entry)
+	}
+}
+
+func Test_accessLog_CLF(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger, _ := newAccessLogger(accessLogCLF, 1, nil, out)
+
+	w := httptest.NewRecorder()
+	accessLog(logger, echoHandler)(w, newAccessLogRequest("/function/echo"))
+
+	line := regexp.MustCompile(`^10\.0\.0\.1 - - \[[^\]]+\] "POST /function/echo HTTP/1\.1" 200 11 "echo" "[0-9a-f-]{36}" [0-9.]+\n$`)
+	if !line.MatchString(out.String()) {
+		t.Errorf("unexpected line: %q", out.String())
+	}
+}
+
+func Test_accessLog_Redact(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger, err := newAccessLogger(accessLogJSON, 1, []string{"client_ip", "query.token"}, out)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	accessLog(logger, echoHandler)(w, newAccessLogRequest("/function/echo?token=s3cret&name=a"))
+
+	entry := accessLogEntry{}
+	json.Unmarshal(out.Bytes(), &entry)
+	if entry.ClientIP != redactedValue || entry.Query != "token="+redactedValue+"&name=a" {
+		t.Errorf("want the client ip and token redacted, got %+v", entry)
+	}
+
+	if _, err := newAccessLogger(accessLogJSON, 1, []string{"status"}, out); err == nil {
+		t.Errorf("want an error for a field that can't be redacted")
+	}
+}
+
+func Test_accessLog_Sampling(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger, _ := newAccessLogger(accessLogJSON, 0, nil, out)
+
+	w := httptest.NewRecorder()
+	accessLog(logger, echoHandler)(w, newAccessLogRequest("/function/echo"))
+	if out.Len() > 0 {
+		t.Errorf("want successful invocations sampled out, got %q", out.String())
+	}
+
+	failing := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	accessLog(logger, failing)(httptest.NewRecorder(), newAccessLogRequest("/function/echo"))
+	if !strings.Contains(out.String(), `"status":502`) {
+		t.Errorf("want errors always logged, got %q", out.String())
+	}
+}
+
+func Test_callID(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/function/echo", nil)
+	generated := callID(r)
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(generated) {
+		t.Errorf("want a UUID, got %q", generated)
+	}
+	if callID(r) == generated {
+		t.Errorf("want a new id for every request")
+	}
+
+	r.Header.Set(callIDHeader, "bad id\n{}")
+	if got := callID(r); got == "bad id\n{}" {
+		t.Errorf("want ids that aren't safe to log replaced")
+	}
+}
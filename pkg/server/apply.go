@@ -30,6 +30,8 @@ func makeApplyHandler(namespace string, client clientset.Interface) http.Handler
 			return
 		}
 
+		spanFromContext(r.Context()).setAttribute("function_name", req.Service)
+
 		newFunc := &v1alpha1.Function{
 			ObjectMeta: metav1.ObjectMeta{
 				Name:      req.Service,
@@ -0,0 +1,280 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	authBasic = "basic"
+	authToken = "token"
+
+	// basicAuthUserFile and basicAuthPasswordFile are the keys of the basic auth secret
+	basicAuthUserFile     = "basic-auth-user"
+	basicAuthPasswordFile = "basic-auth-password"
+
+	// tokenReviewCacheTTL is how long the result of a TokenReview is reused
+	tokenReviewCacheTTL = time.Minute
+)
+
+// authenticator checks the credentials of a request and returns the name of the caller.
+// It returns false when the request carries no credentials it can check.
+type authenticator interface {
+	authenticate(r *http.Request) (string, bool, error)
+	// challenge is the WWW-Authenticate value of the scheme
+	challenge() string
+}
+
+// newAuthenticators creates the authenticators named in the comma separated methods list
+func newAuthenticators(methods string, secretPath string, kube kubernetes.Interface, tokenUsers []string) ([]authenticator, error) {
+	authenticators := []authenticator{}
+	for _, method := range strings.Split(methods, ",") {
+		switch strings.TrimSpace(method) {
+		case "":
+		case authBasic:
+			basic := newBasicAuthenticator(secretPath)
+			if err := basic.load(); err != nil {
+				return nil, err
+			}
+			authenticators = append(authenticators, basic)
+		case authToken:
+			authenticators = append(authenticators, newTokenReviewAuthenticator(kube, tokenUsers))
+		default:
+			return nil, fmt.Errorf("auth method '%s' is not supported", method)
+		}
+	}
+	return authenticators, nil
+}
+
+// requireAuth rejects requests that none of the authenticators accepts with a 401, or a 500
+// when an authenticator failed. Requests pass through when there are no authenticators.
+func requireAuth(authenticators []authenticator, next http.HandlerFunc) http.HandlerFunc {
+	if len(authenticators) == 0 {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if authenticate(authenticators, w, r) {
+			next(w, r)
+		}
+	}
+}
+
+// authenticate returns true when one of the authenticators accepts the request or there are
+// none, otherwise the 401 or 500 is written and false is returned
+func authenticate(authenticators []authenticator, w http.ResponseWriter, r *http.Request) bool {
+	if len(authenticators) == 0 {
+		return true
+	}
+
+	failed := false
+	for _, a := range authenticators {
+		user, ok, err := a.authenticate(r)
+		if err != nil {
+			// another method may still accept the request
+			glog.Errorf("Authentication error: %s", err.Error())
+			failed = true
+			continue
+		}
+		if ok {
+			glog.V(2).Infof("%s %s authenticated as %s", r.Method, r.URL.Path, user)
+			// the credentials are not passed on to the handlers and functions
+			r.Header.Del("Authorization")
+			return true
+		}
+	}
+
+	if failed {
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+
+	for _, a := range authenticators {
+		w.Header().Add("WWW-Authenticate", a.challenge())
+	}
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write([]byte("Unauthorized"))
+	return false
+}
+
+// basicAuthenticator checks basic auth credentials against the user and password files of a
+// mounted secret. The files are read again when they change, so rotated secrets apply without
+// a restart.
+type basicAuthenticator struct {
+	secretPath string
+
+	mu       sync.Mutex
+	modified time.Time
+	user     []byte
+	password []byte
+}
+
+func newBasicAuthenticator(secretPath string) *basicAuthenticator {
+	return &basicAuthenticator{secretPath: secretPath}
+}
+
+func (b *basicAuthenticator) authenticate(r *http.Request) (string, bool, error) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return "", false, nil
+	}
+
+	expectedUser, expectedPassword, err := b.credentials()
+	if err != nil {
+		return "", false, err
+	}
+
+	userMatch := subtle.ConstantTimeCompare([]byte(user), expectedUser) == 1
+	passwordMatch := subtle.ConstantTimeCompare([]byte(password), expectedPassword) == 1
+	return user, userMatch && passwordMatch, nil
+}
+
+func (b *basicAuthenticator) challenge() string {
+	return `Basic realm="OpenFaaS"`
+}
+
+// credentials returns the current user and password, reloading them when the files changed
+func (b *basicAuthenticator) credentials() ([]byte, []byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.load(); err != nil {
+		return nil, nil, err
+	}
+	return b.user, b.password, nil
+}
+
+// load reads the secret files when they were modified since the last read, b.mu must be
+// held unless the authenticator isn't shared yet
+func (b *basicAuthenticator) load() error {
+	userPath := filepath.Join(b.secretPath, basicAuthUserFile)
+	passwordPath := filepath.Join(b.secretPath, basicAuthPasswordFile)
+
+	modified := time.Time{}
+	for _, path := range []string{userPath, passwordPath} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("basic auth secret: %v", err)
+		}
+		if info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+	}
+	if b.user != nil && modified.Equal(b.modified) {
+		return nil
+	}
+
+	user, err := ioutil.ReadFile(userPath)
+	if err != nil {
+		return fmt.Errorf("basic auth secret: %v", err)
+	}
+	password, err := ioutil.ReadFile(passwordPath)
+	if err != nil {
+		return fmt.Errorf("basic auth secret: %v", err)
+	}
+
+	b.user = []byte(strings.TrimSpace(string(user)))
+	b.password = []byte(strings.TrimSpace(string(password)))
+	b.modified = modified
+	glog.Infof("Loaded basic auth credentials from %s", b.secretPath)
+	return nil
+}
+
+// tokenReviewAuthenticator checks bearer tokens with a Kubernetes TokenReview, such as the
+// service account token of the gateway. When users isn't empty only those users are accepted.
+type tokenReviewAuthenticator struct {
+	kube  kubernetes.Interface
+	users map[string]bool
+
+	mu      sync.Mutex
+	reviews map[[sha256.Size]byte]tokenReview
+}
+
+type tokenReview struct {
+	user          string
+	authenticated bool
+	expires       time.Time
+}
+
+func newTokenReviewAuthenticator(kube kubernetes.Interface, users []string) *tokenReviewAuthenticator {
+	allowed := map[string]bool{}
+	for _, user := range users {
+		if user = strings.TrimSpace(user); len(user) > 0 {
+			allowed[user] = true
+		}
+	}
+
+	return &tokenReviewAuthenticator{
+		kube:    kube,
+		users:   allowed,
+		reviews: map[[sha256.Size]byte]tokenReview{},
+	}
+}
+
+func (t *tokenReviewAuthenticator) authenticate(r *http.Request) (string, bool, error) {
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		return "", false, nil
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+	if len(token) == 0 {
+		return "", false, nil
+	}
+
+	// tokens are cached by their hash, so the cache holds no credentials
+	key := sha256.Sum256([]byte(token))
+	now := time.Now()
+
+	t.mu.Lock()
+	review, cached := t.reviews[key]
+	t.mu.Unlock()
+
+	if !cached || now.After(review.expires) {
+		result, err := t.kube.AuthenticationV1().TokenReviews().Create(&authenticationv1.TokenReview{
+			Spec: authenticationv1.TokenReviewSpec{Token: token},
+		})
+		if err != nil {
+			return "", false, fmt.Errorf("token review: %v", err)
+		}
+
+		review = tokenReview{
+			user:          result.Status.User.Username,
+			authenticated: result.Status.Authenticated,
+			expires:       now.Add(tokenReviewCacheTTL),
+		}
+
+		t.mu.Lock()
+		for k, v := range t.reviews {
+			if now.After(v.expires) {
+				delete(t.reviews, k)
+			}
+		}
+		t.reviews[key] = review
+		t.mu.Unlock()
+	}
+
+	if !review.authenticated {
+		return "", false, nil
+	}
+	if len(t.users) > 0 && !t.users[review.user] {
+		glog.Warningf("Token of %s is not allowed", review.user)
+		return review.user, false, nil
+	}
+	return review.user, true, nil
+}
+
+func (t *tokenReviewAuthenticator) challenge() string {
+	return `Bearer realm="OpenFaaS"`
+}
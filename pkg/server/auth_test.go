@@ -0,0 +1,170 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func writeBasicAuthSecret(t *testing.T, dir, user, password string, modified time.Time) {
+	for name, value := range map[string]string{basicAuthUserFile: user, basicAuthPasswordFile: password} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(value+"\n"), 0600); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		os.Chtimes(path, modified, modified)
+	}
+}
+
+func Test_requireAuth_Basic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "basic-auth")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	writeBasicAuthSecret(t, dir, "admin", "secret", time.Now().Add(-time.Hour))
+
+	authenticators, err := newAuthenticators("basic", dir, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	handler := requireAuth(authenticators, func(w http.ResponseWriter, r *http.Request) {
+		if len(r.Header.Get("Authorization")) > 0 {
+			t.Errorf("want credentials removed")
+		}
+	})
+
+	call := func(user, password string) int {
+		r := httptest.NewRequest(http.MethodGet, "/system/functions", nil)
+		if len(user) > 0 {
+			r.SetBasicAuth(user, password)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	if code := call("", ""); code != http.StatusUnauthorized {
+		t.Errorf("want 401 without credentials, got %d", code)
+	}
+	if code := call("admin", "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("want 401 for a wrong password, got %d", code)
+	}
+	if code := call("admin", "secret"); code != http.StatusOK {
+		t.Errorf("want 200, got %d", code)
+	}
+
+	// a rotated secret applies without a restart
+	writeBasicAuthSecret(t, dir, "admin", "rotated", time.Now())
+	if code := call("admin", "secret"); code != http.StatusUnauthorized {
+		t.Errorf("want 401 for the old password, got %d", code)
+	}
+	if code := call("admin", "rotated"); code != http.StatusOK {
+		t.Errorf("want 200 for the rotated password, got %d", code)
+	}
+}
+
+func Test_newAuthenticators_Invalid(t *testing.T) {
+	if _, err := newAuthenticators("basic", "/does/not/exist", nil, nil); err == nil {
+		t.Errorf("want error for a missing secret")
+	}
+	if _, err := newAuthenticators("oauth", "", nil, nil); err == nil {
+		t.Errorf("want error for an unknown method")
+	}
+	if authenticators, err := newAuthenticators("", "", nil, nil); err != nil || len(authenticators) != 0 {
+		t.Errorf("want no authenticators, got %v %v", authenticators, err)
+	}
+}
+
+func Test_requireAuth_TokenReview(t *testing.T) {
+	reviews := 0
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		review := authenticationv1.TokenReview{}
+		json.NewDecoder(r.Body).Decode(&review)
+		reviews++
+
+		review.Status.Authenticated = review.Spec.Token != "invalid"
+		review.Status.User.Username = "system:serviceaccount:openfaas:" + review.Spec.Token
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(review)
+	}))
+	defer apiServer.Close()
+
+	kube, err := kubernetes.NewForConfig(&rest.Config{Host: apiServer.URL})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	authenticators, _ := newAuthenticators("token", "", kube, []string{"system:serviceaccount:openfaas:gateway"})
+	handler := requireAuth(authenticators, func(w http.ResponseWriter, r *http.Request) {})
+
+	call := func(token string) int {
+		r := httptest.NewRequest(http.MethodGet, "/system/functions", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	cases := map[string]int{
+		"gateway": http.StatusOK,
+		"invalid": http.StatusUnauthorized,
+		"other":   http.StatusUnauthorized,
+	}
+	for token, want := range cases {
+		if code := call(token); code != want {
+			t.Errorf("%s: want %d, got %d", token, want, code)
+		}
+	}
+
+	call("gateway")
+	if reviews != 3 {
+		t.Errorf("want reviews cached, got %d reviews", reviews)
+	}
+}
+
+// stubAuthenticator accepts or fails every request
+type stubAuthenticator struct {
+	ok  bool
+	err error
+}
+
+func (s stubAuthenticator) authenticate(r *http.Request) (string, bool, error) {
+	return "stub", s.ok, s.err
+}
+
+func (s stubAuthenticator) challenge() string {
+	return `Stub realm="OpenFaaS"`
+}
+
+func Test_requireAuth_FailingAuthenticator(t *testing.T) {
+	failing := stubAuthenticator{err: fmt.Errorf("token review: connection refused")}
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+
+	cases := []struct {
+		name           string
+		authenticators []authenticator
+		want           int
+	}{
+		{"other method accepts", []authenticator{failing, stubAuthenticator{ok: true}}, http.StatusOK},
+		{"other method rejects", []authenticator{failing, stubAuthenticator{}}, http.StatusInternalServerError},
+		{"only method fails", []authenticator{failing}, http.StatusInternalServerError},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		requireAuth(c.authenticators, handler)(w, httptest.NewRequest(http.MethodGet, "/system/functions", nil))
+		if w.Code != c.want {
+			t.Errorf("%s: want %d, got %d", c.name, c.want, w.Code)
+		}
+	}
+}
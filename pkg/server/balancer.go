@@ -0,0 +1,226 @@
+package server
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+const (
+	// leastConnections sends a request to the endpoint with the fewest requests in flight
+	leastConnections = "least-connections"
+	// powerOfTwoChoices compares two random endpoints and sends a request to the least busy
+	powerOfTwoChoices = "p2c"
+)
+
+// backend is a ready endpoint of a function
+type backend struct {
+	host     string
+	port     int
+	inflight int
+	// failures counts the consecutive failed requests, the endpoint is skipped
+	// until ejectedUntil once they reach the ejection threshold
+	failures     int
+	ejectedUntil time.Time
+}
+
+func (b *backend) address() string {
+	return net.JoinHostPort(b.host, strconv.Itoa(b.port))
+}
+
+// endpointBalancer picks a ready endpoint of a function Service for every request. The endpoints
+// are read from the informer cache, so pods are dropped as soon as they stop being ready or start
+// terminating, and requests in flight are tracked per endpoint.
+type endpointBalancer struct {
+	lister   corelisters.EndpointsNamespaceLister
+	strategy string
+	// ejectAfter consecutive failures eject an endpoint for ejectFor, zero disables ejection
+	ejectAfter int
+	ejectFor   time.Duration
+
+	mu        sync.Mutex
+	functions map[string]map[string]*backend
+	used      map[string]time.Time
+	swept     time.Time
+	random    *rand.Rand
+}
+
+func newEndpointBalancer(lister corelisters.EndpointsNamespaceLister, strategy string) (*endpointBalancer, error) {
+	switch strategy {
+	case leastConnections, powerOfTwoChoices:
+	default:
+		return nil, fmt.Errorf("load balancer '%s' is not supported", strategy)
+	}
+
+	return &endpointBalancer{
+		lister:    lister,
+		strategy:  strategy,
+		functions: map[string]map[string]*backend{},
+		used:      map[string]time.Time{},
+		random:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+// pick returns the endpoint a request to the function is sent to, release must be
+// called when the request is done. Ejected endpoints and the endpoints in skip, such as
+// those already tried by a request, are avoided unless no other endpoint is ready.
+func (b *endpointBalancer) pick(service string, skip ...*backend) (*backend, error) {
+	endpoints, err := b.lister.Get(service)
+	if err != nil {
+		return nil, fmt.Errorf("no endpoints for %s: %v", service, err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(b.swept) > time.Minute {
+		b.sweep(now)
+	}
+	b.used[service] = now
+
+	ready := b.update(service, endpoints)
+	if len(ready) == 0 {
+		return nil, fmt.Errorf("no ready endpoints for %s", service)
+	}
+
+	candidates := []*backend{}
+	for _, candidate := range ready {
+		if now.After(candidate.ejectedUntil) && !containsBackend(skip, candidate) {
+			candidates = append(candidates, candidate)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = ready
+	}
+
+	var picked *backend
+	switch b.strategy {
+	case powerOfTwoChoices:
+		first := candidates[b.random.Intn(len(candidates))]
+		second := candidates[b.random.Intn(len(candidates))]
+		picked = first
+		if second.inflight < first.inflight {
+			picked = second
+		}
+	default:
+		// start at a random endpoint so ties are spread evenly
+		offset := b.random.Intn(len(candidates))
+		for i := range candidates {
+			candidate := candidates[(offset+i)%len(candidates)]
+			if picked == nil || candidate.inflight < picked.inflight {
+				picked = candidate
+			}
+		}
+	}
+
+	picked.inflight++
+	return picked, nil
+}
+
+// ready returns the number of ready endpoints of the function
+func (b *endpointBalancer) ready(service string) int {
+	endpoints, err := b.lister.Get(service)
+	if err != nil {
+		return 0
+	}
+
+	count := 0
+	for _, subset := range endpoints.Subsets {
+		count += len(subset.Addresses)
+	}
+	return count
+}
+
+// release marks a request to the endpoint as done
+func (b *endpointBalancer) release(picked *backend) {
+	b.mu.Lock()
+	picked.inflight--
+	b.mu.Unlock()
+}
+
+// report records the outcome of a request to the endpoint, an endpoint is ejected once
+// ejectAfter requests in a row have failed
+func (b *endpointBalancer) report(picked *backend, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		picked.failures = 0
+		return
+	}
+
+	picked.failures++
+	if b.ejectAfter > 0 && picked.failures >= b.ejectAfter {
+		glog.Warningf("Ejecting endpoint %s for %s after %d failures", picked.address(), b.ejectFor, picked.failures)
+		picked.failures = 0
+		picked.ejectedUntil = time.Now().Add(b.ejectFor)
+	}
+}
+
+// sweep removes the endpoints of functions without recent requests, b.mu must be held.
+// Functions with requests in flight, such as WebSockets, are kept.
+func (b *endpointBalancer) sweep(now time.Time) {
+	b.swept = now
+	for service, used := range b.used {
+		if now.Sub(used) <= functionIdleTime || inflight(b.functions[service]) {
+			continue
+		}
+		delete(b.functions, service)
+		delete(b.used, service)
+	}
+}
+
+func inflight(backends map[string]*backend) bool {
+	for _, backend := range backends {
+		if backend.inflight > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func containsBackend(backends []*backend, b *backend) bool {
+	for _, candidate := range backends {
+		if candidate == b {
+			return true
+		}
+	}
+	return false
+}
+
+// update syncs the tracked endpoints of a function with the ready addresses of its Endpoints
+// and returns them, the requests in flight of known endpoints are kept
+func (b *endpointBalancer) update(service string, endpoints *corev1.Endpoints) []*backend {
+	tracked := b.functions[service]
+	current := map[string]*backend{}
+	candidates := []*backend{}
+
+	for _, subset := range endpoints.Subsets {
+		port := watchdogPort
+		if len(subset.Ports) > 0 {
+			port = int(subset.Ports[0].Port)
+		}
+
+		// only ready addresses are used, pods that are terminating or failing
+		// their readiness probe are listed in NotReadyAddresses
+		for _, address := range subset.Addresses {
+			next := &backend{host: address.IP, port: port}
+			if existing, ok := tracked[next.address()]; ok {
+				next = existing
+			}
+			current[next.address()] = next
+			candidates = append(candidates, next)
+		}
+	}
+
+	b.functions[service] = current
+	return candidates
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newTestBalancer(t *testing.T, strategy string, endpoints ...*corev1.Endpoints) *endpointBalancer {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, e := range endpoints {
+		indexer.Add(e)
+	}
+
+	balancer, err := newEndpointBalancer(corelisters.NewEndpointsLister(indexer).Endpoints("openfaas-fn"), strategy)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return balancer
+}
+
+func newTestEndpoints(name string, ready []string, notReady []string) *corev1.Endpoints {
+	subset := corev1.EndpointSubset{Ports: []corev1.EndpointPort{{Name: "http", Port: 8080}}}
+	for _, ip := range ready {
+		subset.Addresses = append(subset.Addresses, corev1.EndpointAddress{IP: ip})
+	}
+	for _, ip := range notReady {
+		subset.NotReadyAddresses = append(subset.NotReadyAddresses, corev1.EndpointAddress{IP: ip})
+	}
+
+	return &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "openfaas-fn"},
+		Subsets:    []corev1.EndpointSubset{subset},
+	}
+}
+
+func Test_endpointBalancer_LeastConnections(t *testing.T) {
+	balancer := newTestBalancer(t, leastConnections,
+		newTestEndpoints("echo", []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, nil))
+
+	picked := map[string]int{}
+	for i := 0; i < 6; i++ {
+		b, err := balancer.pick("echo")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		picked[b.host]++
+	}
+
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		if picked[ip] != 2 {
+			t.Errorf("want 2 requests in flight on %s, got %d", ip, picked[ip])
+		}
+	}
+}
+
+func Test_endpointBalancer_Release(t *testing.T) {
+	balancer := newTestBalancer(t, leastConnections,
+		newTestEndpoints("echo", []string{"10.0.0.1", "10.0.0.2"}, nil))
+
+	first, _ := balancer.pick("echo")
+	second, _ := balancer.pick("echo")
+	balancer.release(first)
+
+	next, _ := balancer.pick("echo")
+	if next.host != first.host {
+		t.Errorf("want the released endpoint %s, got %s", first.host, next.host)
+	}
+	if second.inflight != 1 {
+		t.Errorf("want 1 request in flight on %s, got %d", second.host, second.inflight)
+	}
+}
+
+func Test_endpointBalancer_SkipsNotReady(t *testing.T) {
+	balancer := newTestBalancer(t, powerOfTwoChoices,
+		newTestEndpoints("echo", []string{"10.0.0.1"}, []string{"10.0.0.2"}),
+		newTestEndpoints("drained", nil, []string{"10.0.0.3"}))
+
+	for i := 0; i < 10; i++ {
+		b, err := balancer.pick("echo")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if b.address() != "10.0.0.1:8080" {
+			t.Errorf("want 10.0.0.1:8080, got %s", b.address())
+		}
+	}
+
+	if _, err := balancer.pick("drained"); err == nil {
+		t.Errorf("want error without ready endpoints")
+	}
+	if _, err := balancer.pick("missing"); err == nil {
+		t.Errorf("want error without endpoints")
+	}
+}
+
+func Test_newEndpointBalancer_Invalid(t *testing.T) {
+	if _, err := newEndpointBalancer(nil, "round-robin"); err == nil {
+		t.Errorf("want error for an unsupported strategy")
+	}
+}
+
+func Test_endpointBalancer_Ejection(t *testing.T) {
+	balancer := newTestBalancer(t, leastConnections,
+		newTestEndpoints("echo", []string{"10.0.0.1", "10.0.0.2"}, nil))
+	balancer.ejectAfter = 2
+	balancer.ejectFor = time.Minute
+
+	failing, _ := balancer.pick("echo")
+	balancer.release(failing)
+	balancer.report(failing, errors.New("connection refused"))
+	balancer.report(failing, errors.New("connection refused"))
+
+	for i := 0; i < 4; i++ {
+		b, _ := balancer.pick("echo")
+		if b == failing {
+			t.Errorf("want ejected endpoint %s skipped", failing.host)
+		}
+	}
+}
+
+func Test_endpointBalancer_Skip(t *testing.T) {
+	balancer := newTestBalancer(t, leastConnections,
+		newTestEndpoints("echo", []string{"10.0.0.1", "10.0.0.2"}, nil))
+
+	first, _ := balancer.pick("echo")
+	balancer.release(first)
+	if second, _ := balancer.pick("echo", first); second == first {
+		t.Errorf("want an endpoint other than %s", first.host)
+	}
+
+	// the skipped endpoints are used when no other endpoint is ready
+	only := newTestBalancer(t, leastConnections, newTestEndpoints("echo", []string{"10.0.0.1"}, nil))
+	b, _ := only.pick("echo")
+	only.release(b)
+	if again, err := only.pick("echo", b); err != nil || again != b {
+		t.Errorf("want the only endpoint again, got %v %v", again, err)
+	}
+}
+
+func Test_endpointBalancer_SweepsIdleFunctions(t *testing.T) {
+	balancer := newTestBalancer(t, leastConnections,
+		newTestEndpoints("echo", []string{"10.0.0.1"}, nil),
+		newTestEndpoints("ws", []string{"10.0.0.2"}, nil))
+
+	echo, _ := balancer.pick("echo")
+	balancer.release(echo)
+	balancer.pick("ws")
+
+	balancer.mu.Lock()
+	balancer.sweep(time.Now().Add(functionIdleTime + time.Minute))
+	_, echoKept := balancer.functions["echo"]
+	_, wsKept := balancer.functions["ws"]
+	balancer.mu.Unlock()
+
+	if echoKept {
+		t.Errorf("want the idle function removed")
+	}
+	if !wsKept {
+		t.Errorf("want the function with a request in flight kept")
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// annotationMaxBodySize is the largest request body a function accepts, such as 512Ki or 10Mi
+const annotationMaxBodySize = "com.openfaas.max-body-size"
+
+var errBodyTooLarge = errors.New("request body too large")
+
+// maxBodySize returns the largest request body of a function in bytes, the fallback is
+// returned when the annotation is missing or invalid, zero means no limit
+func maxBodySize(annotations map[string]string, fallback int64) int64 {
+	val, ok := annotations[annotationMaxBodySize]
+	if !ok {
+		return fallback
+	}
+	quantity, err := resource.ParseQuantity(val)
+	if err != nil || quantity.Sign() < 0 {
+		glog.Warningf("Invalid %s annotation: %s", annotationMaxBodySize, val)
+		return fallback
+	}
+	return quantity.Value()
+}
+
+// limitedBody fails reads past the limit, it's used for bodies of unknown length, which
+// can't be rejected before they are forwarded. Unlike http.MaxBytesReader it records that
+// the limit was hit, so the proxy can answer with a 413 once the transport gives up.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	exceeded  int32
+}
+
+func newLimitedBody(body io.ReadCloser, limit int64) *limitedBody {
+	return &limitedBody{ReadCloser: body, remaining: limit}
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.tooLarge() {
+		return 0, errBodyTooLarge
+	}
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.remaining {
+		n = int(b.remaining)
+		b.remaining = 0
+		atomic.StoreInt32(&b.exceeded, 1)
+		return n, errBodyTooLarge
+	}
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// tooLarge returns true once the body went past the limit, the transport reads the body
+// in its own goroutine
+func (b *limitedBody) tooLarge() bool {
+	return atomic.LoadInt32(&b.exceeded) == 1
+}
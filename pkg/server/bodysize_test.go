@@ -0,0 +1,108 @@
+package server
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
+	listers "github.com/openfaas-incubator/openfaas-operator/pkg/client/listers/openfaas/v1alpha2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func Test_maxBodySize(t *testing.T) {
+	cases := []struct {
+		annotations map[string]string
+		want        int64
+	}{
+		{map[string]string{}, 100},
+		{map[string]string{annotationMaxBodySize: "1Ki"}, 1024},
+		{map[string]string{annotationMaxBodySize: "2M"}, 2000000},
+		{map[string]string{annotationMaxBodySize: "0"}, 0},
+		{map[string]string{annotationMaxBodySize: "lots"}, 100},
+	}
+	for _, c := range cases {
+		if got := maxBodySize(c.annotations, 100); got != c.want {
+			t.Errorf("%v: want %d, got %d", c.annotations, c.want, got)
+		}
+	}
+}
+
+func Test_limitedBody(t *testing.T) {
+	body := newLimitedBody(ioutil.NopCloser(strings.NewReader("hello")), 5)
+	data, err := ioutil.ReadAll(body)
+	if err != nil || string(data) != "hello" || body.tooLarge() {
+		t.Errorf("want the body within the limit, got %q %v", data, err)
+	}
+
+	body = newLimitedBody(ioutil.NopCloser(strings.NewReader("hello world")), 5)
+	data, err = ioutil.ReadAll(body)
+	if err != errBodyTooLarge || string(data) != "hello" || !body.tooLarge() {
+		t.Errorf("want the body cut off at the limit, got %q %v", data, err)
+	}
+}
+
+func Test_makeProxy_RejectsLargeBodies(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return
+		}
+		w.Write(body)
+	}))
+	defer upstream.Close()
+
+	endpoints := &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "echo", Namespace: "openfaas-fn"},
+		Subsets: []corev1.EndpointSubset{{
+			Addresses: []corev1.EndpointAddress{{IP: "127.0.0.1"}},
+			Ports:     []corev1.EndpointPort{{Port: int32(upstream.Listener.Addr().(*net.TCPAddr).Port)}},
+		}},
+	}
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	indexer.Add(&faasv1.Function{
+		ObjectMeta: metav1.ObjectMeta{Name: "echo", Namespace: "openfaas-fn"},
+		Spec: faasv1.FunctionSpec{
+			Name:        "echo",
+			Annotations: &map[string]string{annotationMaxBodySize: "8"},
+		},
+	})
+
+	proxy := makeProxy(proxyConfig{
+		functionNamespace: "openfaas-fn",
+		timeout:           time.Second,
+		balancer:          newTestBalancer(t, leastConnections, endpoints),
+	}, listers.NewFunctionLister(indexer).Functions("openfaas-fn"))
+
+	cases := []struct {
+		name   string
+		body   string
+		length int64
+		want   int
+	}{
+		{"small body", "hello", 5, http.StatusOK},
+		{"large body", "hello world", 11, http.StatusRequestEntityTooLarge},
+		{"small body of unknown length", "hello", -1, http.StatusOK},
+		{"large body of unknown length", "hello world", -1, http.StatusRequestEntityTooLarge},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodPost, "/function/echo", ioutil.NopCloser(strings.NewReader(c.body)))
+		r.ContentLength = c.length
+		r = mux.SetURLVars(r, map[string]string{"name": "echo"})
+		w := httptest.NewRecorder()
+
+		proxy(w, r)
+
+		if w.Code != c.want {
+			t.Errorf("%s: want %d, got %d %q", c.name, c.want, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// annotationCompression is the encoding of the responses of a function, gzip or none
+	annotationCompression = "com.openfaas.compression"
+	// annotationCompressionMinSize is the smallest response in bytes that is compressed
+	annotationCompressionMinSize = "com.openfaas.compression.min-size"
+
+	compressionNone = "none"
+	compressionGzip = "gzip"
+
+	defaultCompressionMinSize = 1024
+)
+
+// compressibleTypes are the media types compressed besides text/*, +json and +xml
+var compressibleTypes = map[string]bool{
+	"application/json":       true,
+	"application/javascript": true,
+	"application/xml":        true,
+	"application/x-ndjson":   true,
+	"image/svg+xml":          true,
+}
+
+var gzipWriters = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(nil)
+	},
+}
+
+// functionCompression returns the encoding of the responses of a function, the
+// fallback is used when the function has no com.openfaas.compression annotation
+func functionCompression(annotations map[string]string, fallback string) string {
+	encoding := fallback
+	if val, ok := annotations[annotationCompression]; ok {
+		encoding = val
+	}
+
+	switch encoding {
+	case "", compressionNone:
+		return compressionNone
+	case compressionGzip:
+		return compressionGzip
+	}
+	glog.Warningf("Compression '%s' is not supported", encoding)
+	return compressionNone
+}
+
+// acceptsEncoding returns true when the Accept-Encoding header of the request allows the
+// encoding, either by name or with * and a non-zero quality
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, value := range r.Header["Accept-Encoding"] {
+		for _, item := range strings.Split(value, ",") {
+			params := strings.Split(item, ";")
+			name := strings.TrimSpace(params[0])
+			if !strings.EqualFold(name, encoding) && name != "*" {
+				continue
+			}
+			accepted := true
+			for _, param := range params[1:] {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") {
+					q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+					accepted = err == nil && q > 0
+				}
+			}
+			return accepted
+		}
+	}
+	return false
+}
+
+func isCompressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return (strings.HasPrefix(mediaType, "text/") && mediaType != "text/event-stream") ||
+		strings.HasSuffix(mediaType, "+json") ||
+		strings.HasSuffix(mediaType, "+xml") ||
+		compressibleTypes[mediaType]
+}
+
+// gzipWriter compresses the response body when the response is compressible. The decision
+// is made when the header is written: responses that are already encoded, smaller than
+// minSize, or have no body are sent as they are.
+type gzipWriter struct {
+	http.ResponseWriter
+	head    bool
+	minSize int
+
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func newGzipWriter(w http.ResponseWriter, r *http.Request, minSize int) *gzipWriter {
+	return &gzipWriter{
+		ResponseWriter: w,
+		head:           r.Method == http.MethodHead,
+		minSize:        minSize,
+	}
+}
+
+func (g *gzipWriter) WriteHeader(code int) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+
+	header := g.Header()
+	if g.compress(code, header) {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", compressionGzip)
+		// the compressed representation is a different entity
+		if etag := header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			header.Set("ETag", "W/"+etag)
+		}
+
+		g.gz = gzipWriters.Get().(*gzip.Writer)
+		g.gz.Reset(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(code)
+}
+
+func (g *gzipWriter) compress(code int, header http.Header) bool {
+	if !isCompressible(header.Get("Content-Type")) || len(header.Get("Content-Encoding")) > 0 {
+		return false
+	}
+	header.Add("Vary", "Accept-Encoding")
+
+	if g.head || code < http.StatusOK || code == http.StatusNoContent || code == http.StatusNotModified {
+		return false
+	}
+	if val := header.Get("Content-Length"); len(val) > 0 {
+		length, err := strconv.Atoi(val)
+		if err == nil && length < g.minSize {
+			return false
+		}
+	}
+	return true
+}
+
+func (g *gzipWriter) Write(data []byte) (int, error) {
+	if !g.wroteHeader {
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.gz == nil {
+		return g.ResponseWriter.Write(data)
+	}
+	return g.gz.Write(data)
+}
+
+// Flush sends the data compressed so far, so streamed responses stay streamed
+func (g *gzipWriter) Flush() {
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	if flusher, ok := g.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// close writes the end of the compressed body, it must be called before the handler returns
+func (g *gzipWriter) close() {
+	if g.gz == nil {
+		return
+	}
+	if err := g.gz.Close(); err != nil {
+		glog.V(2).Infof("Error closing gzip response: %s", err.Error())
+	}
+	g.gz.Reset(nil)
+	gzipWriters.Put(g.gz)
+	g.gz = nil
+}
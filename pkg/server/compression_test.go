@@ -0,0 +1,112 @@
+package server
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_acceptsEncoding(t *testing.T) {
+	cases := []struct {
+		acceptEncoding string
+		want           bool
+	}{
+		{"gzip", true},
+		{"deflate, gzip;q=0.5", true},
+		{"br, *", true},
+		{"gzip;q=0", false},
+		{"br", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/function/echo", nil)
+		r.Header.Set("Accept-Encoding", c.acceptEncoding)
+		if got := acceptsEncoding(r, compressionGzip); got != c.want {
+			t.Errorf("%q: want %v, got %v", c.acceptEncoding, c.want, got)
+		}
+	}
+}
+
+func Test_functionCompression(t *testing.T) {
+	if got := functionCompression(map[string]string{}, compressionGzip); got != compressionGzip {
+		t.Errorf("want the fallback, got %s", got)
+	}
+	if got := functionCompression(map[string]string{annotationCompression: "none"}, compressionGzip); got != compressionNone {
+		t.Errorf("want compression off, got %s", got)
+	}
+	if got := functionCompression(map[string]string{annotationCompression: "zstd"}, compressionNone); got != compressionNone {
+		t.Errorf("want unsupported encodings off, got %s", got)
+	}
+}
+
+func Test_gzipWriter_Compresses(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/function/echo", nil)
+	w := httptest.NewRecorder()
+	body := strings.Repeat("hello world ", 200)
+
+	gw := newGzipWriter(w, r, defaultCompressionMinSize)
+	gw.Header().Set("Content-Type", "application/json")
+	gw.Header().Set("Content-Length", "2400")
+	gw.Header().Set("ETag", `"abc"`)
+	gw.WriteHeader(http.StatusOK)
+	gw.Write([]byte(body))
+	gw.close()
+
+	if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("want gzip, got %q", got)
+	}
+	if got := w.Header().Get("Content-Length"); len(got) > 0 {
+		t.Errorf("want no Content-Length, got %q", got)
+	}
+	if got := w.Header().Get("ETag"); got != `W/"abc"` {
+		t.Errorf("want a weak ETag, got %q", got)
+	}
+	if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+		t.Errorf("want Vary: Accept-Encoding, got %q", got)
+	}
+
+	reader, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	decompressed, _ := ioutil.ReadAll(reader)
+	if string(decompressed) != body {
+		t.Errorf("want the body back, got %d bytes", len(decompressed))
+	}
+}
+
+func Test_gzipWriter_Skips(t *testing.T) {
+	cases := []struct {
+		name   string
+		header map[string]string
+		code   int
+	}{
+		{"small body", map[string]string{"Content-Type": "text/plain", "Content-Length": "5"}, http.StatusOK},
+		{"encoded body", map[string]string{"Content-Type": "text/plain", "Content-Encoding": "br"}, http.StatusOK},
+		{"image", map[string]string{"Content-Type": "image/png"}, http.StatusOK},
+		{"event stream", map[string]string{"Content-Type": "text/event-stream"}, http.StatusOK},
+		{"no content", map[string]string{"Content-Type": "text/plain"}, http.StatusNoContent},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/function/echo", nil)
+		w := httptest.NewRecorder()
+
+		gw := newGzipWriter(w, r, defaultCompressionMinSize)
+		for name, value := range c.header {
+			gw.Header().Set(name, value)
+		}
+		gw.WriteHeader(c.code)
+		gw.Write([]byte("hello"))
+		gw.close()
+
+		if got := w.Header().Get("Content-Encoding"); got == "gzip" {
+			t.Errorf("%s: want no compression", c.name)
+		}
+		if c.code == http.StatusOK && w.Body.String() != "hello" {
+			t.Errorf("%s: want the body as is, got %q", c.name, w.Body.String())
+		}
+	}
+}
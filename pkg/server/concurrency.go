@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// annotationMaxInflight is the number of requests a replica of the function handles at once
+	annotationMaxInflight = "com.openfaas.max-inflight"
+	// annotationQueueMaxLength is the number of requests waiting for a free replica
+	annotationQueueMaxLength = "com.openfaas.queue.max-length"
+	// annotationQueueMaxWait is how long a request waits in the queue
+	annotationQueueMaxWait = "com.openfaas.queue.max-wait"
+
+	defaultQueueMaxWait = 10 * time.Second
+)
+
+var (
+	errQueueFull    = errors.New("queue is full")
+	errQueueTimeout = errors.New("timed out in queue")
+)
+
+// concurrencyLimits tracks the requests in flight and the queued requests of each function
+type concurrencyLimits struct {
+	mu        sync.Mutex
+	functions map[string]*concurrencyLimit
+	swept     time.Time
+}
+
+// concurrencyLimit admits requests to a function while fewer than limit are in flight,
+// the others wait in a FIFO queue
+type concurrencyLimit struct {
+	limit    int
+	inflight int
+	waiting  []chan struct{}
+	used     time.Time
+}
+
+func newConcurrencyLimits() *concurrencyLimits {
+	return &concurrencyLimits{functions: map[string]*concurrencyLimit{}}
+}
+
+// acquire admits a request to the function once fewer than limit requests are in flight. Up to
+// maxLength requests wait for maxWait, errQueueFull is returned when the queue is full and
+// errQueueTimeout when the wait is over. release must be called when an admitted request is done.
+func (c *concurrencyLimits) acquire(ctx context.Context, service string, limit, maxLength int, maxWait time.Duration) error {
+	c.mu.Lock()
+	now := time.Now()
+	if now.Sub(c.swept) > time.Minute {
+		c.sweep(now)
+	}
+	fl, ok := c.functions[service]
+	if !ok {
+		fl = &concurrencyLimit{}
+		c.functions[service] = fl
+	}
+	fl.used = now
+	// the limit follows the number of replicas, a higher limit admits queued requests
+	fl.limit = limit
+	c.admit(service, fl)
+
+	if fl.inflight < fl.limit && len(fl.waiting) == 0 {
+		fl.inflight++
+		c.mu.Unlock()
+		return nil
+	}
+	if len(fl.waiting) >= maxLength {
+		c.mu.Unlock()
+		return errQueueFull
+	}
+
+	ready := make(chan struct{})
+	fl.waiting = append(fl.waiting, ready)
+	proxyQueueLength.WithLabelValues(service).Set(float64(len(fl.waiting)))
+	c.mu.Unlock()
+
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-ready:
+		return nil
+	case <-timer.C:
+		err = errQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-ready:
+		// admitted while giving up, hand the slot to the next request
+		fl.inflight--
+		c.admit(service, fl)
+	default:
+		fl.waiting = removeWaiter(fl.waiting, ready)
+		proxyQueueLength.WithLabelValues(service).Set(float64(len(fl.waiting)))
+	}
+	return err
+}
+
+// release marks an admitted request to the function as done
+func (c *concurrencyLimits) release(service string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fl := c.functions[service]
+	fl.inflight--
+	c.admit(service, fl)
+}
+
+// admit lets queued requests in while the function is below its limit, c.mu must be held
+func (c *concurrencyLimits) admit(service string, fl *concurrencyLimit) {
+	if len(fl.waiting) == 0 {
+		return
+	}
+	for fl.inflight < fl.limit && len(fl.waiting) > 0 {
+		close(fl.waiting[0])
+		fl.waiting = fl.waiting[1:]
+		fl.inflight++
+	}
+	proxyQueueLength.WithLabelValues(service).Set(float64(len(fl.waiting)))
+}
+
+// sweep removes the limits of functions without recent requests, c.mu must be held.
+// Functions with requests in flight or queued are kept.
+func (c *concurrencyLimits) sweep(now time.Time) {
+	c.swept = now
+	for service, fl := range c.functions {
+		if now.Sub(fl.used) <= functionIdleTime || fl.inflight > 0 || len(fl.waiting) > 0 {
+			continue
+		}
+		delete(c.functions, service)
+		proxyQueueLength.DeleteLabelValues(service)
+	}
+}
+
+func removeWaiter(waiting []chan struct{}, ready chan struct{}) []chan struct{} {
+	for i, w := range waiting {
+		if w == ready {
+			return append(waiting[:i], waiting[i+1:]...)
+		}
+	}
+	return waiting
+}
+
+// retryAfterSeconds is the Retry-After of a rejected request, the time a queued request may wait
+func retryAfterSeconds(maxWait time.Duration) int {
+	seconds := int((maxWait + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
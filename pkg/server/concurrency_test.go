@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_concurrencyLimits_QueueFull(t *testing.T) {
+	limits := newConcurrencyLimits()
+	ctx := context.Background()
+
+	if err := limits.acquire(ctx, "testfunc", 1, 0, time.Second); err != nil {
+		t.Fatalf("want first request admitted, got %v", err)
+	}
+	if err := limits.acquire(ctx, "testfunc", 1, 0, time.Second); err != errQueueFull {
+		t.Errorf("want %v, got %v", errQueueFull, err)
+	}
+}
+
+func Test_concurrencyLimits_QueuedUntilRelease(t *testing.T) {
+	limits := newConcurrencyLimits()
+	ctx := context.Background()
+
+	if err := limits.acquire(ctx, "testfunc", 1, 1, time.Second); err != nil {
+		t.Fatalf("want first request admitted, got %v", err)
+	}
+
+	admitted := make(chan error)
+	go func() {
+		admitted <- limits.acquire(ctx, "testfunc", 1, 1, 5*time.Second)
+	}()
+
+	select {
+	case err := <-admitted:
+		t.Fatalf("want request queued, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	limits.release("testfunc")
+	if err := <-admitted; err != nil {
+		t.Errorf("want queued request admitted, got %v", err)
+	}
+	if inflight := limits.functions["testfunc"].inflight; inflight != 1 {
+		t.Errorf("want 1 request in flight, got %d", inflight)
+	}
+}
+
+func Test_concurrencyLimits_QueueTimeout(t *testing.T) {
+	limits := newConcurrencyLimits()
+	ctx := context.Background()
+
+	limits.acquire(ctx, "testfunc", 1, 1, time.Second)
+	if err := limits.acquire(ctx, "testfunc", 1, 1, 10*time.Millisecond); err != errQueueTimeout {
+		t.Errorf("want %v, got %v", errQueueTimeout, err)
+	}
+	if waiting := len(limits.functions["testfunc"].waiting); waiting != 0 {
+		t.Errorf("want empty queue, got %d", waiting)
+	}
+}
+
+func Test_concurrencyLimits_SweepsIdleFunctions(t *testing.T) {
+	limits := newConcurrencyLimits()
+	ctx := context.Background()
+
+	limits.acquire(ctx, "echo", 1, 0, time.Second)
+	limits.release("echo")
+	limits.acquire(ctx, "ws", 1, 0, time.Second)
+
+	limits.mu.Lock()
+	limits.sweep(time.Now().Add(functionIdleTime + time.Minute))
+	_, echoKept := limits.functions["echo"]
+	_, wsKept := limits.functions["ws"]
+	limits.mu.Unlock()
+
+	if echoKept {
+		t.Errorf("want the idle function removed")
+	}
+	if !wsKept {
+		t.Errorf("want the function with a request in flight kept")
+	}
+}
+
+func Test_retryAfterSeconds(t *testing.T) {
+	cases := map[time.Duration]int{0: 1, 500 * time.Millisecond: 1, 10 * time.Second: 10, 1500 * time.Millisecond: 2}
+	for maxWait, want := range cases {
+		if got := retryAfterSeconds(maxWait); got != want {
+			t.Errorf("%s: want %d, got %d", maxWait, want, got)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// annotationCORSAllowOrigins is a comma separated list of origins or * for any origin,
+	// the proxy handles CORS for the function when it's set
+	annotationCORSAllowOrigins = "com.openfaas.cors.allow-origins"
+	// annotationCORSAllowMethods lists the methods of preflight requests
+	annotationCORSAllowMethods = "com.openfaas.cors.allow-methods"
+	// annotationCORSAllowHeaders lists the request headers of preflight requests
+	annotationCORSAllowHeaders = "com.openfaas.cors.allow-headers"
+	// annotationCORSExposeHeaders lists the response headers the browser may read
+	annotationCORSExposeHeaders = "com.openfaas.cors.expose-headers"
+	// annotationCORSAllowCredentials allows cookies and auth headers when true
+	annotationCORSAllowCredentials = "com.openfaas.cors.allow-credentials"
+	// annotationCORSMaxAge is how long browsers cache a preflight response
+	annotationCORSMaxAge = "com.openfaas.cors.max-age"
+
+	defaultCORSAllowMethods = "GET, HEAD, POST, PUT, PATCH, DELETE"
+	defaultCORSAllowHeaders = "Content-Type"
+	defaultCORSMaxAge       = 10 * time.Minute
+)
+
+// corsPolicy is the CORS configuration of a function
+type corsPolicy struct {
+	origins          []string
+	methods          string
+	headers          string
+	exposeHeaders    string
+	allowCredentials bool
+	maxAge           time.Duration
+}
+
+// functionCORS reads the CORS policy of a function, false is returned when the
+// function handles CORS itself
+func functionCORS(annotations map[string]string) (corsPolicy, bool) {
+	origins := splitList(annotations[annotationCORSAllowOrigins])
+	if len(origins) == 0 {
+		return corsPolicy{}, false
+	}
+
+	policy := corsPolicy{
+		origins:          origins,
+		methods:          defaultCORSAllowMethods,
+		headers:          defaultCORSAllowHeaders,
+		exposeHeaders:    strings.Join(splitList(annotations[annotationCORSExposeHeaders]), ", "),
+		allowCredentials: annotations[annotationCORSAllowCredentials] == "true",
+		maxAge:           durationAnnotation(annotations, annotationCORSMaxAge, defaultCORSMaxAge),
+	}
+	if methods := splitList(annotations[annotationCORSAllowMethods]); len(methods) > 0 {
+		policy.methods = strings.Join(methods, ", ")
+	}
+	if headers := splitList(annotations[annotationCORSAllowHeaders]); len(headers) > 0 {
+		policy.headers = strings.Join(headers, ", ")
+	}
+	return policy, true
+}
+
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions &&
+		len(r.Header.Get("Origin")) > 0 &&
+		len(r.Header.Get("Access-Control-Request-Method")) > 0
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for the origin, or false when
+// the origin isn't allowed. Listed origins get the origin back, any other origin gets *
+// when the policy has one.
+func (p corsPolicy) allowOrigin(origin string) (string, bool) {
+	if len(origin) == 0 {
+		return "", false
+	}
+	wildcard := false
+	for _, allowed := range p.origins {
+		if allowed == "*" {
+			wildcard = true
+		} else if strings.EqualFold(allowed, origin) {
+			return origin, true
+		}
+	}
+	if wildcard {
+		return "*", true
+	}
+	return "", false
+}
+
+// credentials reports whether a response to the allowed origin may carry
+// Access-Control-Allow-Credentials. Origins only allowed by * never get credentials,
+// otherwise any website could make credentialed calls and read the responses.
+func (p corsPolicy) credentials(allowed string) bool {
+	return p.allowCredentials && allowed != "*"
+}
+
+// setHeaders sets the CORS headers of a response to an allowed origin, replacing
+// any set by the function
+func (p corsPolicy) setHeaders(header http.Header, origin string) {
+	header.Add("Vary", "Origin")
+
+	allowed, ok := p.allowOrigin(origin)
+	if !ok {
+		return
+	}
+	header.Set("Access-Control-Allow-Origin", allowed)
+	if p.credentials(allowed) {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	} else {
+		header.Del("Access-Control-Allow-Credentials")
+	}
+	if len(p.exposeHeaders) > 0 {
+		header.Set("Access-Control-Expose-Headers", p.exposeHeaders)
+	}
+}
+
+// corsWriter sets the CORS headers when the header is written, so they apply to the
+// responses of the proxy and replace the ones of the function
+type corsWriter struct {
+	http.ResponseWriter
+	policy corsPolicy
+	origin string
+
+	wroteHeader bool
+}
+
+func (c *corsWriter) WriteHeader(code int) {
+	if c.wroteHeader {
+		return
+	}
+	c.wroteHeader = true
+	c.policy.setHeaders(c.Header(), c.origin)
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *corsWriter) Write(data []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	return c.ResponseWriter.Write(data)
+}
+
+func (c *corsWriter) Flush() {
+	if flusher, ok := c.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// preflight answers a preflight request without calling the function, preflight requests
+// from origins that aren't allowed get a 403
+func (p corsPolicy) preflight(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	header := w.Header()
+	header.Add("Vary", "Origin")
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+
+	allowed, ok := p.allowOrigin(origin)
+	if !ok {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	header.Set("Access-Control-Allow-Origin", allowed)
+	header.Set("Access-Control-Allow-Methods", p.methods)
+	header.Set("Access-Control-Allow-Headers", p.headers)
+	header.Set("Access-Control-Max-Age", strconv.Itoa(int(p.maxAge.Seconds())))
+	if p.credentials(allowed) {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_functionCORS(t *testing.T) {
+	if _, ok := functionCORS(map[string]string{}); ok {
+		t.Errorf("want no policy without allowed origins")
+	}
+
+	policy, ok := functionCORS(map[string]string{
+		annotationCORSAllowOrigins: "https://a.example.com, https://b.example.com",
+		annotationCORSAllowMethods: "GET,POST",
+		annotationCORSMaxAge:       "1h",
+	})
+	if !ok || len(policy.origins) != 2 || policy.methods != "GET, POST" ||
+		policy.headers != defaultCORSAllowHeaders || policy.maxAge.Hours() != 1 {
+		t.Errorf("unexpected policy: %v", policy)
+	}
+}
+
+func Test_corsPolicy_allowOrigin(t *testing.T) {
+	cases := []struct {
+		name        string
+		origins     []string
+		credentials bool
+		origin      string
+		want        string
+		wantOK      bool
+	}{
+		{"listed origin", []string{"https://a.example.com"}, false, "https://a.example.com", "https://a.example.com", true},
+		{"unlisted origin", []string{"https://a.example.com"}, false, "https://evil.example.com", "", false},
+		{"any origin", []string{"*"}, false, "https://a.example.com", "*", true},
+		{"any origin with credentials", []string{"*"}, true, "https://a.example.com", "*", true},
+		{"listed origin before *", []string{"*", "https://a.example.com"}, true, "https://a.example.com", "https://a.example.com", true},
+		{"no origin", []string{"*"}, false, "", "", false},
+	}
+	for _, c := range cases {
+		policy := corsPolicy{origins: c.origins, allowCredentials: c.credentials}
+		got, ok := policy.allowOrigin(c.origin)
+		if got != c.want || ok != c.wantOK {
+			t.Errorf("%s: want %q %v, got %q %v", c.name, c.want, c.wantOK, got, ok)
+		}
+	}
+}
+
+func Test_corsPolicy_preflight(t *testing.T) {
+	policy, _ := functionCORS(map[string]string{
+		annotationCORSAllowOrigins:     "https://a.example.com",
+		annotationCORSAllowCredentials: "true",
+	})
+
+	r := httptest.NewRequest(http.MethodOptions, "/function/echo", nil)
+	r.Header.Set("Origin", "https://a.example.com")
+	r.Header.Set("Access-Control-Request-Method", "POST")
+	if !isPreflight(r) {
+		t.Fatalf("want a preflight request")
+	}
+
+	w := httptest.NewRecorder()
+	policy.preflight(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("want 204, got %d", w.Code)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://a.example.com" {
+		t.Errorf("want the origin allowed, got %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Methods"); got != defaultCORSAllowMethods {
+		t.Errorf("want the default methods, got %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Max-Age"); got != "600" {
+		t.Errorf("want a max age of 600, got %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Errorf("want credentials allowed, got %q", got)
+	}
+
+	r.Header.Set("Origin", "https://evil.example.com")
+	w = httptest.NewRecorder()
+	policy.preflight(w, r)
+	if w.Code != http.StatusForbidden || len(w.Header().Get("Access-Control-Allow-Origin")) > 0 {
+		t.Errorf("want 403 without CORS headers, got %d %v", w.Code, w.Header())
+	}
+}
+
+func Test_corsWriter_ReplacesFunctionHeaders(t *testing.T) {
+	policy, _ := functionCORS(map[string]string{
+		annotationCORSAllowOrigins:  "https://a.example.com",
+		annotationCORSExposeHeaders: "X-Duration",
+	})
+
+	w := httptest.NewRecorder()
+	cw := &corsWriter{ResponseWriter: w, policy: policy, origin: "https://a.example.com"}
+	cw.Header().Set("Access-Control-Allow-Origin", "*")
+	cw.Header().Set("Access-Control-Allow-Credentials", "true")
+	cw.Write([]byte("hello"))
+
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://a.example.com" {
+		t.Errorf("want the origin allowed, got %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Credentials"); len(got) > 0 {
+		t.Errorf("want no credentials header, got %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Expose-Headers"); got != "X-Duration" {
+		t.Errorf("want X-Duration exposed, got %q", got)
+	}
+	if got := w.Header().Get("Vary"); got != "Origin" {
+		t.Errorf("want Vary: Origin, got %q", got)
+	}
+}
+
+func Test_corsPolicy_NoCredentialsForAnyOrigin(t *testing.T) {
+	policy, _ := functionCORS(map[string]string{
+		annotationCORSAllowOrigins:     "https://a.example.com, *",
+		annotationCORSAllowCredentials: "true",
+	})
+
+	r := httptest.NewRequest(http.MethodOptions, "/function/echo", nil)
+	r.Header.Set("Origin", "https://evil.example.com")
+	r.Header.Set("Access-Control-Request-Method", "POST")
+	w := httptest.NewRecorder()
+	policy.preflight(w, r)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("want *, got %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Credentials"); len(got) > 0 {
+		t.Errorf("want no credentials for an arbitrary origin, got %q", got)
+	}
+
+	w = httptest.NewRecorder()
+	cw := &corsWriter{ResponseWriter: w, policy: policy, origin: "https://evil.example.com"}
+	cw.Header().Set("Access-Control-Allow-Credentials", "true")
+	cw.Write([]byte("hello"))
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("want *, got %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Credentials"); len(got) > 0 {
+		t.Errorf("want no credentials for an arbitrary origin, got %q", got)
+	}
+
+	w = httptest.NewRecorder()
+	cw = &corsWriter{ResponseWriter: w, policy: policy, origin: "https://a.example.com"}
+	cw.Write([]byte("hello"))
+	if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Errorf("want credentials for a listed origin, got %q", got)
+	}
+}
@@ -26,6 +26,8 @@ func makeDeleteHandler(namespace string, client clientset.Interface) http.Handle
 			return
 		}
 
+		spanFromContext(r.Context()).setAttribute("function_name", request.FunctionName)
+
 		if len(request.FunctionName) == 0 {
 			w.WriteHeader(http.StatusBadRequest)
 		}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+const (
+	// annotationAuth turns on invocation auth for a function: api-key or jwt
+	annotationAuth = "com.openfaas.auth"
+	// annotationAuthSecret names the Secret whose values are the API keys of the function
+	annotationAuthSecret = "com.openfaas.auth.secret"
+	// annotationAuthHeader is the header that carries the API key
+	annotationAuthHeader = "com.openfaas.auth.header"
+	// annotationAuthAudience is the audience a JWT must be issued for
+	annotationAuthAudience = "com.openfaas.auth.audience"
+	// annotationAuthIssuer is the issuer of the JWTs, any issuer when empty
+	annotationAuthIssuer = "com.openfaas.auth.issuer"
+	// annotationAuthClaims lists the claims a JWT must have as name=value pairs
+	annotationAuthClaims = "com.openfaas.auth.claims"
+	// annotationAuthForwardClaims lists the claims passed to the function in headers
+	annotationAuthForwardClaims = "com.openfaas.auth.forward-claims"
+
+	functionAuthAPIKey = "api-key"
+	functionAuthJWT    = "jwt"
+
+	defaultAPIKeyHeader       = "X-Api-Key"
+	defaultForwardedClaims    = "sub"
+	claimHeaderPrefix         = "X-Openfaas-Claim-"
+	apiKeyNameHeader          = claimHeaderPrefix + "Key"
+	functionAuthMisconfigured = "Invocation auth is misconfigured"
+)
+
+// functionAuth checks the credentials of invocations of functions that opt in with
+// the com.openfaas.auth annotation
+type functionAuth struct {
+	// secrets reads the API key Secrets of the functions
+	secrets corelisters.SecretNamespaceLister
+	// keys verifies JWTs, JWT auth is unavailable when it's nil
+	keys *jwks
+}
+
+// authError is a rejected invocation and the status code returned to the caller
+type authError struct {
+	status  int
+	message string
+}
+
+func (e *authError) Error() string {
+	return e.message
+}
+
+// authorize checks the credentials of an invocation when the function requires them. The
+// credentials are removed from the request and the verified identity is passed to the function
+// in X-Openfaas-Claim headers, headers of that name sent by the caller are always removed.
+func (a *functionAuth) authorize(annotations map[string]string, r *http.Request) *authError {
+	for name := range r.Header {
+		if strings.HasPrefix(name, claimHeaderPrefix) {
+			r.Header.Del(name)
+		}
+	}
+
+	switch annotations[annotationAuth] {
+	case "":
+		return nil
+	case functionAuthAPIKey:
+		return a.authorizeAPIKey(annotations, r)
+	case functionAuthJWT:
+		return a.authorizeJWT(annotations, r)
+	}
+	return &authError{http.StatusInternalServerError, functionAuthMisconfigured}
+}
+
+func (a *functionAuth) authorizeAPIKey(annotations map[string]string, r *http.Request) *authError {
+	secretName := annotations[annotationAuthSecret]
+	if a == nil || a.secrets == nil || len(secretName) == 0 {
+		return &authError{http.StatusInternalServerError, functionAuthMisconfigured}
+	}
+	secret, err := a.secrets.Get(secretName)
+	if err != nil {
+		return &authError{http.StatusInternalServerError, fmt.Sprintf("%s: %v", functionAuthMisconfigured, err)}
+	}
+
+	header := defaultAPIKeyHeader
+	if val, ok := annotations[annotationAuthHeader]; ok && len(val) > 0 {
+		header = val
+	}
+	key := r.Header.Get(header)
+	if len(key) == 0 {
+		return &authError{http.StatusUnauthorized, "API key required"}
+	}
+
+	// every value of the Secret is a valid key, so keys can be rotated one at a time
+	names := []string{}
+	for name := range secret.Data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if subtle.ConstantTimeCompare([]byte(key), secret.Data[name]) == 1 {
+			r.Header.Del(header)
+			r.Header.Set(apiKeyNameHeader, name)
+			return nil
+		}
+	}
+	return &authError{http.StatusUnauthorized, "Invalid API key"}
+}
+
+func (a *functionAuth) authorizeJWT(annotations map[string]string, r *http.Request) *authError {
+	audience := annotations[annotationAuthAudience]
+	if a == nil || a.keys == nil || len(audience) == 0 {
+		return &authError{http.StatusInternalServerError, functionAuthMisconfigured}
+	}
+
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		return &authError{http.StatusUnauthorized, "Bearer token required"}
+	}
+
+	claims, err := verifyJWT(strings.TrimPrefix(authorization, "Bearer "), a.keys, time.Now())
+	if err != nil {
+		return &authError{http.StatusUnauthorized, "Invalid token: " + err.Error()}
+	}
+	if !claimContains(claims, "aud", audience) {
+		return &authError{http.StatusUnauthorized, "Invalid token: wrong audience"}
+	}
+	if issuer := annotations[annotationAuthIssuer]; len(issuer) > 0 && !claimContains(claims, "iss", issuer) {
+		return &authError{http.StatusUnauthorized, "Invalid token: wrong issuer"}
+	}
+
+	for _, pair := range splitList(annotations[annotationAuthClaims]) {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || !claimContains(claims, kv[0], kv[1]) {
+			return &authError{http.StatusForbidden, "Missing claim: " + kv[0]}
+		}
+	}
+
+	r.Header.Del("Authorization")
+
+	forwarded := defaultForwardedClaims
+	if val, ok := annotations[annotationAuthForwardClaims]; ok {
+		forwarded = val
+	}
+	for _, name := range splitList(forwarded) {
+		if value, ok := claimValue(claims, name); ok {
+			r.Header.Set(claimHeaderPrefix+name, value)
+		}
+	}
+	return nil
+}
+
+// claimValue formats a claim for a header, arrays are comma separated
+func claimValue(claims map[string]interface{}, name string) (string, bool) {
+	switch claim := claims[name].(type) {
+	case string:
+		return claim, true
+	case float64, bool:
+		return fmt.Sprint(claim), true
+	case []interface{}:
+		values := []string{}
+		for _, item := range claim {
+			values = append(values, fmt.Sprint(item))
+		}
+		return strings.Join(values, ","), true
+	}
+	return "", false
+}
+
+// splitList splits a comma separated annotation and drops empty items
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
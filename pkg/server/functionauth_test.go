@@ -0,0 +1,277 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
+	listers "github.com/openfaas-incubator/openfaas-operator/pkg/client/listers/openfaas/v1alpha2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func encodeSegment(t *testing.T, v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func encodeBigInt(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.Bytes())
+}
+
+func signJWT(t *testing.T, key crypto.Signer, alg, kid string, claims map[string]interface{}) string {
+	signed := encodeSegment(t, map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + encodeSegment(t, claims)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		r, s, _ := ecdsa.Sign(rand.Reader, k, digest[:])
+		signature = make([]byte, 64)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(signature[32-len(rb):32], rb)
+		copy(signature[64-len(sb):], sb)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func newTestJWKS(t *testing.T, rsaKey *rsa.PrivateKey, ecKey *ecdsa.PrivateKey) (*jwks, func()) {
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	set := map[string]interface{}{
+		"keys": []map[string]string{
+			{"kid": "rsa", "kty": "RSA", "use": "sig", "n": encodeBigInt(rsaKey.N), "e": encodeBigInt(big.NewInt(int64(rsaKey.E)))},
+			{"kid": "ec", "kty": "EC", "crv": "P-256", "x": encodeBigInt(ecKey.X), "y": encodeBigInt(ecKey.Y)},
+		},
+	}
+	data, _ := json.Marshal(set)
+	path := filepath.Join(dir, "jwks.json")
+	ioutil.WriteFile(path, data, 0600)
+
+	return newJWKS(path), func() { os.RemoveAll(dir) }
+}
+
+func Test_functionAuth_JWT(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	keys, cleanup := newTestJWKS(t, rsaKey, ecKey)
+	defer cleanup()
+
+	auth := &functionAuth{keys: keys}
+	annotations := map[string]string{
+		annotationAuth:              functionAuthJWT,
+		annotationAuthAudience:      "billing",
+		annotationAuthIssuer:        "https://issuer.example.com",
+		annotationAuthClaims:        "groups=finance",
+		annotationAuthForwardClaims: "sub,groups",
+	}
+
+	exp := time.Now().Add(time.Hour).Unix()
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub":    "alice",
+			"aud":    []string{"billing", "reports"},
+			"iss":    "https://issuer.example.com",
+			"groups": []string{"staff", "finance"},
+			"exp":    exp,
+		}
+		for k, v := range overrides {
+			c[k] = v
+		}
+		return c
+	}
+
+	cases := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"RS256", signJWT(t, rsaKey, "RS256", "rsa", claims(nil)), 0},
+		{"ES256", signJWT(t, ecKey, "ES256", "ec", claims(nil)), 0},
+		{"wrong key", signJWT(t, rsaKey, "RS256", "ec", claims(nil)), http.StatusUnauthorized},
+		{"unknown kid", signJWT(t, rsaKey, "RS256", "other", claims(nil)), http.StatusUnauthorized},
+		{"expired", signJWT(t, rsaKey, "RS256", "rsa", claims(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()})), http.StatusUnauthorized},
+		{"audience", signJWT(t, rsaKey, "RS256", "rsa", claims(map[string]interface{}{"aud": "reports"})), http.StatusUnauthorized},
+		{"issuer", signJWT(t, rsaKey, "RS256", "rsa", claims(map[string]interface{}{"iss": "https://other.example.com"})), http.StatusUnauthorized},
+		{"claims", signJWT(t, rsaKey, "RS256", "rsa", claims(map[string]interface{}{"groups": []string{"staff"}})), http.StatusForbidden},
+		{"none", "", http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/function/billing", nil)
+		r.Header.Set(claimHeaderPrefix+"Sub", "mallory")
+		if len(c.token) > 0 {
+			r.Header.Set("Authorization", "Bearer "+c.token)
+		}
+
+		err := auth.authorize(annotations, r)
+		status := 0
+		if err != nil {
+			status = err.status
+		}
+		if status != c.status {
+			t.Errorf("%s: want status %d, got %v", c.name, c.status, err)
+			continue
+		}
+
+		if status == 0 {
+			if sub := r.Header.Get(claimHeaderPrefix + "Sub"); sub != "alice" {
+				t.Errorf("%s: want sub claim alice, got %s", c.name, sub)
+			}
+			if groups := r.Header.Get(claimHeaderPrefix + "Groups"); groups != "staff,finance" {
+				t.Errorf("%s: want groups claim, got %s", c.name, groups)
+			}
+			if len(r.Header.Get("Authorization")) > 0 {
+				t.Errorf("%s: want token removed", c.name)
+			}
+		} else if sub := r.Header.Get(claimHeaderPrefix + "Sub"); len(sub) > 0 {
+			t.Errorf("%s: want caller claim headers removed, got %s", c.name, sub)
+		}
+	}
+}
+
+func Test_functionAuth_APIKey(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	indexer.Add(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "billing-keys", Namespace: "openfaas-fn"},
+		Data:       map[string][]byte{"partner-a": []byte("key-a"), "partner-b": []byte("key-b")},
+	})
+	auth := &functionAuth{secrets: corelisters.NewSecretLister(indexer).Secrets("openfaas-fn")}
+
+	annotations := map[string]string{annotationAuth: functionAuthAPIKey, annotationAuthSecret: "billing-keys"}
+	cases := map[string]int{"key-b": 0, "wrong": http.StatusUnauthorized, "": http.StatusUnauthorized}
+
+	for key, want := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/function/billing", nil)
+		if len(key) > 0 {
+			r.Header.Set(defaultAPIKeyHeader, key)
+		}
+
+		err := auth.authorize(annotations, r)
+		status := 0
+		if err != nil {
+			status = err.status
+		}
+		if status != want {
+			t.Errorf("%q: want status %d, got %v", key, want, err)
+		}
+		if status == 0 && (r.Header.Get(apiKeyNameHeader) != "partner-b" || len(r.Header.Get(defaultAPIKeyHeader)) > 0) {
+			t.Errorf("%q: want key name passed and key removed, got %v", key, r.Header)
+		}
+	}
+
+	annotations[annotationAuthSecret] = "missing"
+	r := httptest.NewRequest(http.MethodGet, "/function/billing", nil)
+	r.Header.Set(defaultAPIKeyHeader, "key-a")
+	if err := auth.authorize(annotations, r); err == nil || err.status != http.StatusInternalServerError {
+		t.Errorf("want 500 for a missing secret, got %v", err)
+	}
+}
+
+func Test_makeProxy_FunctionAuthWithInvocationAuth(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get(claimHeaderPrefix+"sub") + r.Header.Get(apiKeyNameHeader)))
+	}))
+	defer upstream.Close()
+	port := int32(upstream.Listener.Addr().(*net.TCPAddr).Port)
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	keys, cleanup := newTestJWKS(t, rsaKey, ecKey)
+	defer cleanup()
+
+	functions := map[string]map[string]string{
+		"reports": {annotationAuth: functionAuthJWT, annotationAuthAudience: "reports"},
+		"billing": {annotationAuth: functionAuthAPIKey, annotationAuthSecret: "billing-keys"},
+		"echo":    {},
+	}
+	functionIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	endpoints := []*corev1.Endpoints{}
+	for name, annotations := range functions {
+		annotations := annotations
+		functionIndexer.Add(&faasv1.Function{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "openfaas-fn"},
+			Spec:       faasv1.FunctionSpec{Name: name, Annotations: &annotations},
+		})
+		endpoints = append(endpoints, &corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "openfaas-fn"},
+			Subsets: []corev1.EndpointSubset{{
+				Addresses: []corev1.EndpointAddress{{IP: "127.0.0.1"}},
+				Ports:     []corev1.EndpointPort{{Port: port}},
+			}},
+		})
+	}
+	secretIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	secretIndexer.Add(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "billing-keys", Namespace: "openfaas-fn"},
+		Data:       map[string][]byte{"partner-a": []byte("key-a")},
+	})
+
+	// the operator credentials are never sent, so only function auth can let a request through
+	proxy := makeProxy(proxyConfig{
+		functionNamespace: "openfaas-fn",
+		timeout:           time.Second,
+		balancer:          newTestBalancer(t, leastConnections, endpoints...),
+		functionAuth: &functionAuth{
+			secrets: corelisters.NewSecretLister(secretIndexer).Secrets("openfaas-fn"),
+			keys:    keys,
+		},
+		authenticators: []authenticator{stubAuthenticator{}},
+	}, listers.NewFunctionLister(functionIndexer).Functions("openfaas-fn"))
+
+	token := signJWT(t, rsaKey, "RS256", "rsa", map[string]interface{}{
+		"sub": "alice", "aud": "reports", "exp": time.Now().Add(time.Hour).Unix(),
+	})
+	cases := []struct {
+		function string
+		header   string
+		value    string
+		want     int
+		body     string
+	}{
+		{"reports", "Authorization", "Bearer " + token, http.StatusOK, "alice"},
+		{"billing", defaultAPIKeyHeader, "key-a", http.StatusOK, "partner-a"},
+		{"echo", "", "", http.StatusUnauthorized, "Unauthorized"},
+		{"missing", "", "", http.StatusUnauthorized, "Unauthorized"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/function/"+c.function, nil)
+		if len(c.header) > 0 {
+			r.Header.Set(c.header, c.value)
+		}
+		r = mux.SetURLVars(r, map[string]string{"name": c.function})
+		w := httptest.NewRecorder()
+
+		proxy(w, r)
+
+		if w.Code != c.want || w.Body.String() != c.body {
+			t.Errorf("%s: want %d %q, got %d %q", c.function, c.want, c.body, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
+)
+
+const (
+	annotationTimeoutWrite = "com.openfaas.timeout.write"
+	annotationTimeoutExec  = "com.openfaas.timeout.exec"
+)
+
+// functionAnnotations returns the annotations of a function, the proxy settings
+// of a function are read from them
+func functionAnnotations(function *faasv1.Function) map[string]string {
+	if function == nil || function.Spec.Annotations == nil {
+		return map[string]string{}
+	}
+	return *function.Spec.Annotations
+}
+
+// durationAnnotation parses a duration annotation such as 30s or 5m, the fallback is
+// returned when the annotation is missing or invalid
+func durationAnnotation(annotations map[string]string, key string, fallback time.Duration) time.Duration {
+	val, ok := annotations[key]
+	if !ok {
+		return fallback
+	}
+	duration, err := time.ParseDuration(val)
+	if err != nil || duration < 0 {
+		glog.Warningf("Invalid %s annotation: %s", key, val)
+		return fallback
+	}
+	return duration
+}
+
+// intAnnotation parses an integer annotation, the fallback is returned when the
+// annotation is missing or invalid
+func intAnnotation(annotations map[string]string, key string, fallback int) int {
+	val, ok := annotations[key]
+	if !ok {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(val)
+	if err != nil || parsed < 0 {
+		glog.Warningf("Invalid %s annotation: %s", key, val)
+		return fallback
+	}
+	return parsed
+}
+
+// invocationTimeout returns how long the proxy waits for the function, the write timeout of
+// the function or else its exec timeout. The timeouts in the function spec take precedence
+// over the com.openfaas.timeout annotations, zero means no deadline.
+func invocationTimeout(function *faasv1.Function) time.Duration {
+	annotations := functionAnnotations(function)
+
+	write, exec := annotations[annotationTimeoutWrite], annotations[annotationTimeoutExec]
+	if timeouts := function.Spec.Timeouts; timeouts != nil {
+		if len(timeouts.Write) > 0 {
+			write = timeouts.Write
+		}
+		if len(timeouts.Exec) > 0 {
+			exec = timeouts.Exec
+		}
+	}
+
+	for _, val := range []string{write, exec} {
+		if len(val) == 0 {
+			continue
+		}
+		timeout, err := time.ParseDuration(val)
+		if err != nil || timeout < 0 {
+			glog.Warningf("Function %s has an invalid timeout: %s", function.Spec.Name, val)
+			continue
+		}
+		return timeout
+	}
+	return 0
+}
+
+// deadline returns how long the proxy waits for the function: its own timeout or else the
+// default timeout, capped by the max timeout. Zero means no deadline.
+func (c proxyConfig) deadline(function *faasv1.Function) time.Duration {
+	timeout := invocationTimeout(function)
+	if timeout == 0 {
+		timeout = c.defaultTimeout
+	}
+	if c.maxTimeout > 0 && (timeout == 0 || timeout > c.maxTimeout) {
+		timeout = c.maxTimeout
+	}
+	return timeout
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
+	listers "github.com/openfaas-incubator/openfaas-operator/pkg/client/listers/openfaas/v1alpha2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func Test_invocationTimeout(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		timeouts    *faasv1.FunctionTimeouts
+		want        time.Duration
+	}{
+		{"none", nil, nil, 0},
+		{"write annotation", map[string]string{annotationTimeoutWrite: "30s"}, nil, 30 * time.Second},
+		{"exec annotation", map[string]string{annotationTimeoutExec: "1m"}, nil, time.Minute},
+		{"spec over annotation", map[string]string{annotationTimeoutWrite: "30s"}, &faasv1.FunctionTimeouts{Write: "5s"}, 5 * time.Second},
+		{"invalid write", map[string]string{annotationTimeoutWrite: "soon"}, &faasv1.FunctionTimeouts{Exec: "2s"}, 2 * time.Second},
+	}
+
+	for _, c := range cases {
+		function := &faasv1.Function{Spec: faasv1.FunctionSpec{Name: "testfunc", Timeouts: c.timeouts}}
+		if c.annotations != nil {
+			function.Spec.Annotations = &c.annotations
+		}
+		if got := invocationTimeout(function); got != c.want {
+			t.Errorf("%s: want %s, got %s", c.name, c.want, got)
+		}
+	}
+}
+
+func Test_proxyConfig_deadline(t *testing.T) {
+	config := proxyConfig{defaultTimeout: 8 * time.Second, maxTimeout: time.Minute}
+	cases := []struct {
+		name     string
+		timeouts *faasv1.FunctionTimeouts
+		want     time.Duration
+	}{
+		{"default", nil, 8 * time.Second},
+		{"longer than the default", &faasv1.FunctionTimeouts{Write: "30s"}, 30 * time.Second},
+		{"above the max", &faasv1.FunctionTimeouts{Write: "5m"}, time.Minute},
+	}
+	for _, c := range cases {
+		function := &faasv1.Function{Spec: faasv1.FunctionSpec{Name: "testfunc", Timeouts: c.timeouts}}
+		if got := config.deadline(function); got != c.want {
+			t.Errorf("%s: want %s, got %s", c.name, c.want, got)
+		}
+	}
+}
+
+func Test_makeProxy_TimeoutLongerThanWriteTimeout(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		w.Write([]byte("done"))
+	}))
+	defer upstream.Close()
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	endpoints := []*corev1.Endpoints{}
+	for name, timeouts := range map[string]*faasv1.FunctionTimeouts{"slow": {Write: "1s"}, "default": nil} {
+		indexer.Add(&faasv1.Function{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "openfaas-fn"},
+			Spec:       faasv1.FunctionSpec{Name: name, Timeouts: timeouts},
+		})
+		endpoints = append(endpoints, &corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "openfaas-fn"},
+			Subsets: []corev1.EndpointSubset{{
+				Addresses: []corev1.EndpointAddress{{IP: "127.0.0.1"}},
+				Ports:     []corev1.EndpointPort{{Port: int32(upstream.Listener.Addr().(*net.TCPAddr).Port)}},
+			}},
+		})
+	}
+
+	// write_timeout is 100ms, the slow function allows 1s
+	config := proxyConfig{
+		functionNamespace: "openfaas-fn",
+		timeout:           time.Second,
+		defaultTimeout:    100 * time.Millisecond,
+		maxTimeout:        2 * time.Second,
+		balancer:          newTestBalancer(t, leastConnections, endpoints...),
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/function/{name}", makeProxy(config, listers.NewFunctionLister(indexer).Functions("openfaas-fn")))
+
+	server := httptest.NewUnstartedServer(router)
+	server.Config.WriteTimeout = serverWriteTimeout(config.maxTimeout)
+	server.Start()
+	defer server.Close()
+
+	cases := map[string]int{"slow": http.StatusOK, "default": http.StatusGatewayTimeout}
+	for name, want := range cases {
+		res, err := http.Get(server.URL + "/function/" + name)
+		if err != nil {
+			t.Fatalf("%s: want a response, got %v", name, err)
+		}
+		res.Body.Close()
+		if res.StatusCode != want {
+			t.Errorf("%s: want %d, got %d", name, want, res.StatusCode)
+		}
+	}
+}
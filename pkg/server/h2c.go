@@ -0,0 +1,108 @@
+package server
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	"golang.org/x/net/http2"
+)
+
+const (
+	// annotationProtocol is the protocol spoken by the function: http, h2c or grpc
+	annotationProtocol = "com.openfaas.protocol"
+
+	// http2Preface is the part of the HTTP/2 client preface read as a request by the HTTP/1 server
+	http2Preface = "PRI * HTTP/2.0\r\n\r\n"
+)
+
+// usesHTTP2 returns true when the function upstream speaks HTTP/2 over cleartext
+func usesHTTP2(annotations map[string]string) bool {
+	switch annotations[annotationProtocol] {
+	case "h2c", "grpc":
+		return true
+	}
+	return false
+}
+
+// makeH2CTransport creates a transport that speaks HTTP/2 without TLS to the functions,
+// the streams to a function share a single connection
+func makeH2CTransport(dialer *net.Dialer) *http2.Transport {
+	return &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return dialer.Dial(network, addr)
+		},
+	}
+}
+
+// makeH2CHandler serves HTTP/2 with prior knowledge on the HTTP/1 port, so gRPC clients can
+// call the proxy. The HTTP/1 server reads the start of the client preface as a PRI request,
+// the connection is then hijacked and handed over to the HTTP/2 server.
+func makeH2CHandler(handler http.Handler) http.HandlerFunc {
+	server := &http2.Server{}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PRI" || r.URL.Path != "*" || r.ProtoMajor != 2 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		hijacker, ok := w.(http.Hijacker)
+		if !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		conn, buf, err := hijacker.Hijack()
+		if err != nil {
+			glog.Errorf("h2c hijack error: %s", err.Error())
+			return
+		}
+		defer conn.Close()
+
+		// the HTTP/1 server deadlines don't apply to the HTTP/2 connection
+		conn.SetDeadline(time.Time{})
+
+		server.ServeConn(&prefaceConn{
+			Conn:   conn,
+			reader: io.MultiReader(strings.NewReader(http2Preface), buf.Reader),
+		}, &http2.ServeConnOpts{Handler: handler})
+	}
+}
+
+// prefaceConn replays the part of the client preface consumed by the HTTP/1 server
+type prefaceConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c *prefaceConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// makeGRPCRouter sends gRPC requests to the function named by the first label of the authority,
+// gRPC clients call /package.Service/Method which doesn't match the /function/<name> route.
+// Other requests are served by the router.
+func makeGRPCRouter(router http.Handler, proxy http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") ||
+			strings.HasPrefix(r.URL.Path, "/function/") {
+			router.ServeHTTP(w, r)
+			return
+		}
+
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		name := strings.SplitN(host, ".", 2)[0]
+
+		proxy(w, mux.SetURLVars(r, map[string]string{"name": name}))
+	}
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func Test_makeH2CHandler_ServesHTTP2(t *testing.T) {
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/grpc")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(r.Proto + " " + string(body)))
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		makeH2CHandler(inner)(w, r)
+	}))
+	defer server.Close()
+
+	transport := makeH2CTransport(&net.Dialer{Timeout: time.Second})
+	request, _ := http.NewRequest(http.MethodPost, server.URL+"/function/echo", strings.NewReader("hello"))
+
+	response, err := transport.RoundTrip(request)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer response.Body.Close()
+
+	body, _ := ioutil.ReadAll(response.Body)
+	if string(body) != "HTTP/2.0 hello" {
+		t.Errorf("want HTTP/2.0 hello, got %s", string(body))
+	}
+	if got := response.Trailer.Get("Grpc-Status"); got != "0" {
+		t.Errorf("want Grpc-Status trailer 0, got '%s'", got)
+	}
+}
+
+func Test_usesHTTP2(t *testing.T) {
+	scenarios := map[string]bool{"": false, "http": false, "h2c": true, "grpc": true}
+
+	for protocol, want := range scenarios {
+		annotations := map[string]string{annotationProtocol: protocol}
+		if got := usesHTTP2(annotations); got != want {
+			t.Errorf("protocol '%s': want %v, got %v", protocol, want, got)
+		}
+	}
+}
+
+func Test_makeGRPCRouter_RoutesByAuthority(t *testing.T) {
+	var routed, proxied string
+	router := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routed = r.URL.Path
+	})
+	proxy := func(w http.ResponseWriter, r *http.Request) {
+		proxied = mux.Vars(r)["name"]
+	}
+	handler := makeGRPCRouter(router, proxy)
+
+	r := httptest.NewRequest(http.MethodPost, "http://greeter.openfaas-fn:8081/helloworld.Greeter/SayHello", nil)
+	r.Header.Set("Content-Type", "application/grpc")
+	handler(httptest.NewRecorder(), r)
+	if proxied != "greeter" {
+		t.Errorf("want gRPC request proxied to greeter, got '%s'", proxied)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "http://localhost:8081/system/functions", nil)
+	handler(httptest.NewRecorder(), r)
+	if routed != "/system/functions" {
+		t.Errorf("want request served by the router, got '%s'", routed)
+	}
+}
@@ -0,0 +1,329 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// jwksRefreshInterval is how often keys fetched from a URL are refreshed
+	jwksRefreshInterval = 5 * time.Minute
+	// jwksMinRefreshInterval limits the refreshes triggered by tokens signed with an unknown key
+	jwksMinRefreshInterval = 30 * time.Second
+	// jwtLeeway is the clock skew allowed when checking exp and nbf
+	jwtLeeway = time.Minute
+)
+
+// jwks holds the public keys of a JSON Web Key Set read from a file or a URL. A file is read
+// again when it changes, keys from a URL are refreshed periodically and when a token is signed
+// with an unknown key. URLs are fetched in the background, so a slow key server only delays
+// the tokens signed with a key that isn't known yet.
+type jwks struct {
+	source string
+	client *http.Client
+
+	mu       sync.Mutex
+	keys     map[string]crypto.PublicKey
+	modified time.Time
+	fetched  time.Time
+	// refreshing is closed when the fetch in progress is done, nil when there is none
+	refreshing chan struct{}
+	// err is the error of the last fetch
+	err error
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func newJWKS(source string) *jwks {
+	return &jwks{
+		source: source,
+		client: &http.Client{Timeout: 10 * time.Second},
+		keys:   map[string]crypto.PublicKey{},
+	}
+}
+
+func (j *jwks) isURL() bool {
+	return strings.HasPrefix(j.source, "https://") || strings.HasPrefix(j.source, "http://")
+}
+
+// key returns the public key with the key id
+func (j *jwks) key(kid string) (crypto.PublicKey, error) {
+	if !j.isURL() {
+		return j.fileKey(kid)
+	}
+
+	j.mu.Lock()
+	now := time.Now()
+	key, known := j.keys[kid]
+	var done chan struct{}
+	switch {
+	case now.Sub(j.fetched) > jwksRefreshInterval,
+		!known && now.Sub(j.fetched) > jwksMinRefreshInterval:
+		done = j.refresh(now)
+	case !known:
+		// the key may be in the fetch in progress, refreshes for unknown keys are
+		// limited so callers can't make the provider fetch on every request
+		done = j.refreshing
+	}
+	j.mu.Unlock()
+
+	if known {
+		return key, nil
+	}
+	if done != nil {
+		<-done
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if key, ok := j.keys[kid]; ok {
+		return key, nil
+	}
+	if len(j.keys) == 0 && j.err != nil {
+		return nil, j.err
+	}
+	return nil, fmt.Errorf("no key with kid '%s'", kid)
+}
+
+// refresh fetches the key set in the background unless a fetch is in progress and returns
+// the channel closed when it's done, j.mu must be held
+func (j *jwks) refresh(now time.Time) chan struct{} {
+	if j.refreshing != nil {
+		return j.refreshing
+	}
+
+	j.fetched = now
+	done := make(chan struct{})
+	j.refreshing = done
+
+	go func() {
+		keys, err := j.fetch()
+
+		j.mu.Lock()
+		if err != nil {
+			glog.Errorf("Error refreshing JWKS, using the previous keys: %s", err.Error())
+		} else {
+			j.keys = keys
+			glog.Infof("Loaded %d keys from %s", len(keys), j.source)
+		}
+		j.err = err
+		j.refreshing = nil
+		j.mu.Unlock()
+
+		close(done)
+	}()
+	return done
+}
+
+// fetch reads the key set from the URL
+func (j *jwks) fetch() (map[string]crypto.PublicKey, error) {
+	res, err := j.client.Get(j.source)
+	if err != nil {
+		return nil, fmt.Errorf("jwks: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks: %s returned %d", j.source, res.StatusCode)
+	}
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("jwks: %v", err)
+	}
+	return parseJWKS(data)
+}
+
+// fileKey returns the public key with the key id from the key set file, the file is read
+// again when it changed
+func (j *jwks) fileKey(kid string) (crypto.PublicKey, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.loadFile(); err != nil {
+		if len(j.keys) == 0 {
+			return nil, err
+		}
+		glog.Errorf("Error refreshing JWKS, using the previous keys: %s", err.Error())
+	}
+
+	key, ok := j.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("no key with kid '%s'", kid)
+	}
+	return key, nil
+}
+
+// loadFile reads the key set file when it was modified, j.mu must be held
+func (j *jwks) loadFile() error {
+	info, err := os.Stat(j.source)
+	if err != nil {
+		return fmt.Errorf("jwks: %v", err)
+	}
+	if len(j.keys) > 0 && info.ModTime().Equal(j.modified) {
+		return nil
+	}
+	data, err := ioutil.ReadFile(j.source)
+	if err != nil {
+		return fmt.Errorf("jwks: %v", err)
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+	j.keys = keys
+	j.modified = info.ModTime()
+	glog.Infof("Loaded %d keys from %s", len(keys), j.source)
+	return nil
+}
+
+// parseJWKS parses the RSA and P-256 signing keys of a key set, other keys are skipped
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	set := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("jwks: %v", err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch {
+		case k.Kty == "RSA":
+			n, errN := decodeBigInt(k.N)
+			e, errE := decodeBigInt(k.E)
+			if errN != nil || errE != nil || !e.IsInt64() {
+				return nil, fmt.Errorf("jwks: invalid RSA key '%s'", k.Kid)
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case k.Kty == "EC" && k.Crv == "P-256":
+			x, errX := decodeBigInt(k.X)
+			y, errY := decodeBigInt(k.Y)
+			if errX != nil || errY != nil || !elliptic.P256().IsOnCurve(x, y) {
+				return nil, fmt.Errorf("jwks: invalid EC key '%s'", k.Kid)
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		}
+	}
+	return keys, nil
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// verifyJWT checks the RS256 or ES256 signature of a compact JWT with the key set
+// and the exp and nbf claims, and returns the claims
+func verifyJWT(token string, keys *jwks, now time.Time) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed header: %v", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature: %v", err)
+	}
+
+	key, err := keys.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch header.Alg {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature) != nil {
+			return nil, fmt.Errorf("invalid signature")
+		}
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return nil, fmt.Errorf("invalid signature")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, digest[:], r, s) {
+			return nil, fmt.Errorf("invalid signature")
+		}
+	default:
+		return nil, fmt.Errorf("algorithm '%s' is not supported", header.Alg)
+	}
+
+	claims := map[string]interface{}{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed claims: %v", err)
+	}
+
+	if exp, ok := claims["exp"].(float64); !ok || now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return nil, fmt.Errorf("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, fmt.Errorf("token not valid yet")
+	}
+	return claims, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// claimContains returns true when the claim is the value or an array that contains it
+func claimContains(claims map[string]interface{}, name, value string) bool {
+	switch claim := claims[name].(type) {
+	case string:
+		return claim == value
+	case []interface{}:
+		for _, item := range claim {
+			if s, ok := item.(string); ok && s == value {
+				return true
+			}
+		}
+	case float64, bool:
+		return fmt.Sprint(claim) == value
+	}
+	return false
+}
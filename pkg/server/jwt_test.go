@@ -0,0 +1,75 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_jwks_FetchesOutsideTheLock(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	set, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{
+			{"kid": "rsa", "kty": "RSA", "n": encodeBigInt(rsaKey.N), "e": encodeBigInt(big.NewInt(int64(rsaKey.E)))},
+		},
+	})
+
+	var fetches, blocked int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		if atomic.LoadInt32(&blocked) == 1 {
+			<-release
+		}
+		w.Write(set)
+	}))
+	defer server.Close()
+
+	keys := newJWKS(server.URL)
+	if _, err := keys.key("rsa"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the key server hangs on the next refresh
+	atomic.StoreInt32(&blocked, 1)
+	keys.mu.Lock()
+	keys.fetched = time.Now().Add(-jwksRefreshInterval - time.Second)
+	keys.mu.Unlock()
+
+	start := time.Now()
+	if _, err := keys.key("rsa"); err != nil {
+		t.Errorf("want the known key during the refresh, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("want the known key without waiting for the refresh, took %s", elapsed)
+	}
+
+	// unknown keys wait for the refresh in progress instead of starting new ones
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := keys.key("unknown"); err == nil {
+				t.Errorf("want an error for an unknown key")
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if _, err := keys.key("unknown"); err == nil {
+		t.Errorf("want an error for an unknown key")
+	}
+	if got := atomic.LoadInt32(&fetches); got != 2 {
+		t.Errorf("want 2 fetches, got %d", got)
+	}
+}
@@ -12,7 +12,7 @@ import (
 	"k8s.io/client-go/listers/apps/v1beta2"
 )
 
-func makeListHandler(namespace string, client clientset.Interface, kube kubernetes.Interface, deploymentLister v1beta2.DeploymentNamespaceLister) http.HandlerFunc {
+func makeListHandler(namespace string, client clientset.Interface, kube kubernetes.Interface, deploymentLister v1beta2.DeploymentNamespaceLister, invocations *invocationCounts) http.HandlerFunc {
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Body != nil {
@@ -43,6 +43,7 @@ func makeListHandler(namespace string, client clientset.Interface, kube kubernet
 				Image:             item.Spec.Image,
 				Labels:            item.Spec.Labels,
 				Annotations:       item.Spec.Annotations,
+				InvocationCount:   invocations.get(item.Spec.Name),
 			}
 
 			functions = append(functions, function)
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	websocketConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "openfaas",
+		Subsystem: "proxy",
+		Name:      "websocket_connections",
+		Help:      "Open upgraded connections to a function",
+	}, []string{"function_name"})
+
+	websocketConnectionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "openfaas",
+		Subsystem: "proxy",
+		Name:      "websocket_connections_total",
+		Help:      "Upgraded connections to a function",
+	}, []string{"function_name"})
+
+	proxyQueueLength = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "openfaas",
+		Subsystem: "proxy",
+		Name:      "queue_length",
+		Help:      "Requests waiting for a function below its max in-flight limit",
+	}, []string{"function_name"})
+
+	functionInvocations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "openfaas",
+		Subsystem: "proxy",
+		Name:      "invocations_total",
+		Help:      "Invocations of a function by status code",
+	}, []string{"function_name", "code"})
+
+	functionInvocationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "openfaas",
+		Subsystem: "proxy",
+		Name:      "invocation_duration_seconds",
+		Help:      "Duration of the invocations of a function by status code",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"function_name", "code"})
+
+	functionInflight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "openfaas",
+		Subsystem: "proxy",
+		Name:      "inflight_requests",
+		Help:      "Invocations of a function in flight, including queued requests",
+	}, []string{"function_name"})
+
+	functionRequestSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "openfaas",
+		Subsystem: "proxy",
+		Name:      "request_size_bytes",
+		Help:      "Size of the request bodies sent to a function",
+		Buckets:   prometheus.ExponentialBuckets(100, 10, 7),
+	}, []string{"function_name"})
+
+	functionResponseSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "openfaas",
+		Subsystem: "proxy",
+		Name:      "response_size_bytes",
+		Help:      "Size of the response bodies returned by a function",
+		Buckets:   prometheus.ExponentialBuckets(100, 10, 7),
+	}, []string{"function_name"})
+)
+
+func init() {
+	prometheus.MustRegister(websocketConnections)
+	prometheus.MustRegister(websocketConnectionsTotal)
+	prometheus.MustRegister(proxyQueueLength)
+	prometheus.MustRegister(functionInvocations)
+	prometheus.MustRegister(functionInvocationDuration)
+	prometheus.MustRegister(functionInflight)
+	prometheus.MustRegister(functionRequestSize)
+	prometheus.MustRegister(functionResponseSize)
+}
+
+// invocationCounts counts the invocations of each function for the function list
+type invocationCounts struct {
+	mu     sync.Mutex
+	counts map[string]float64
+}
+
+func newInvocationCounts() *invocationCounts {
+	return &invocationCounts{counts: map[string]float64{}}
+}
+
+func (c *invocationCounts) inc(service string) {
+	c.mu.Lock()
+	c.counts[service]++
+	c.mu.Unlock()
+}
+
+func (c *invocationCounts) get(service string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[service]
+}
+
+// instrumentInvocation records the in-flight invocation of a function and wraps the response
+// writer and request body to measure them. The returned func records the status code, duration
+// and sizes and must be called when the invocation is done.
+func instrumentInvocation(service string, w http.ResponseWriter, r *http.Request, counts *invocationCounts) (*metricsWriter, func()) {
+	start := time.Now()
+	functionInflight.WithLabelValues(service).Inc()
+
+	mw := &metricsWriter{ResponseWriter: w}
+	body := &countingReader{reader: r.Body}
+	if r.Body != nil {
+		r.Body = body
+	}
+
+	return mw, func() {
+		functionInflight.WithLabelValues(service).Dec()
+
+		code := strconv.Itoa(mw.status())
+		functionInvocations.WithLabelValues(service, code).Inc()
+		functionInvocationDuration.WithLabelValues(service, code).Observe(time.Since(start).Seconds())
+		functionRequestSize.WithLabelValues(service).Observe(float64(body.count))
+		functionResponseSize.WithLabelValues(service).Observe(float64(mw.written))
+		if counts != nil {
+			counts.inc(service)
+		}
+	}
+}
+
+// metricsWriter records the status code and body size written to the caller, upgraded
+// connections are recorded with 101 Switching Protocols
+type metricsWriter struct {
+	http.ResponseWriter
+	code    int
+	written int64
+}
+
+func (m *metricsWriter) WriteHeader(code int) {
+	if m.code == 0 {
+		m.code = code
+	}
+	m.ResponseWriter.WriteHeader(code)
+}
+
+func (m *metricsWriter) Write(p []byte) (int, error) {
+	if m.code == 0 {
+		m.code = http.StatusOK
+	}
+	n, err := m.ResponseWriter.Write(p)
+	m.written += int64(n)
+	return n, err
+}
+
+func (m *metricsWriter) Flush() {
+	if flusher, ok := m.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (m *metricsWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := m.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer can't be hijacked")
+	}
+	conn, buf, err := hijacker.Hijack()
+	if err == nil && m.code == 0 {
+		m.code = http.StatusSwitchingProtocols
+	}
+	return conn, buf, err
+}
+
+func (m *metricsWriter) status() int {
+	if m.code == 0 {
+		return http.StatusOK
+	}
+	return m.code
+}
+
+// countingReader counts the bytes read from a request body
+type countingReader struct {
+	reader io.ReadCloser
+	count  int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.count += int64(n)
+	return n, err
+}
+
+func (c *countingReader) Close() error {
+	return c.reader.Close()
+}
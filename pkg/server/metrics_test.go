@@ -0,0 +1,46 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_instrumentInvocation(t *testing.T) {
+	counts := newInvocationCounts()
+	r := httptest.NewRequest(http.MethodPost, "/function/echo", strings.NewReader("hello"))
+	w := httptest.NewRecorder()
+
+	mw, done := instrumentInvocation("echo", w, r, counts)
+	ioutil.ReadAll(r.Body)
+	mw.WriteHeader(http.StatusCreated)
+	mw.Write([]byte("hello world"))
+	mw.Flush()
+	done()
+
+	if mw.status() != http.StatusCreated || mw.written != 11 {
+		t.Errorf("want 201 with 11 bytes, got %d with %d bytes", mw.status(), mw.written)
+	}
+	if read := r.Body.(*countingReader).count; read != 5 {
+		t.Errorf("want 5 bytes read, got %d", read)
+	}
+	if !w.Flushed {
+		t.Errorf("want the response flushed")
+	}
+	if count := counts.get("echo"); count != 1 {
+		t.Errorf("want 1 invocation, got %f", count)
+	}
+}
+
+func Test_metricsWriter_DefaultStatus(t *testing.T) {
+	mw := &metricsWriter{ResponseWriter: httptest.NewRecorder()}
+	if mw.status() != http.StatusOK {
+		t.Errorf("want 200 when nothing was written, got %d", mw.status())
+	}
+
+	if _, _, err := mw.Hijack(); err == nil {
+		t.Errorf("want error when the writer can't be hijacked")
+	}
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	tracingExporterNone = "none"
+	tracingExporterOTLP = "otlp"
+	tracingExporterLog  = "log"
+
+	tracingServiceName = "openfaas-operator"
+)
+
+// newSpanExporter creates the exporter named by the tracing_exporter setting, nil for none
+func newSpanExporter(name, endpoint string) (spanExporter, error) {
+	switch name {
+	case "", tracingExporterNone:
+		return nil, nil
+	case tracingExporterOTLP:
+		return &otlpExporter{
+			endpoint: endpoint,
+			client:   &http.Client{Timeout: 10 * time.Second},
+		}, nil
+	case tracingExporterLog:
+		return logExporter{}, nil
+	}
+	return nil, fmt.Errorf("tracing exporter '%s' is not supported", name)
+}
+
+// otlpExporter sends spans to an OpenTelemetry collector with OTLP over HTTP using
+// the JSON encoding, the endpoint is the traces URL such as http://collector:4318/v1/traces
+type otlpExporter struct {
+	endpoint string
+	client   *http.Client
+}
+
+type otlpKeyValue struct {
+	Key   string            `json:"key"`
+	Value map[string]string `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+func (e *otlpExporter) export(spans []*span) error {
+	converted := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		converted = append(converted, makeOTLPSpan(s))
+	}
+
+	payload := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []otlpKeyValue{makeKeyValue("service.name", tracingServiceName)},
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": tracingServiceName},
+						"spans": converted,
+					},
+				},
+			},
+		},
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	res, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("collector returned %d", res.StatusCode)
+	}
+	return nil
+}
+
+func makeOTLPSpan(s *span) otlpSpan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	converted := otlpSpan{
+		TraceID:           hex.EncodeToString(s.context.traceID[:]),
+		SpanID:            hex.EncodeToString(s.context.spanID[:]),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+	}
+	if s.parentID != ([8]byte{}) {
+		converted.ParentSpanID = hex.EncodeToString(s.parentID[:])
+	}
+	if len(s.errMessage) > 0 {
+		// STATUS_CODE_ERROR
+		converted.Status = otlpStatus{Code: 2, Message: s.errMessage}
+	}
+
+	keys := []string{}
+	for key := range s.attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		converted.Attributes = append(converted.Attributes, makeKeyValue(key, s.attributes[key]))
+	}
+	return converted
+}
+
+func makeKeyValue(key, value string) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: map[string]string{"stringValue": value}}
+}
+
+// logExporter writes spans to the log, for debugging the tracing setup
+type logExporter struct{}
+
+func (logExporter) export(spans []*span) error {
+	for _, s := range spans {
+		converted := makeOTLPSpan(s)
+		out, _ := json.Marshal(converted)
+		glog.Infof("span %s", out)
+	}
+	return nil
+}
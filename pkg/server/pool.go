@@ -0,0 +1,95 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// functionIdleTime is how long the connection pool and balancer state of a function are
+// kept without requests, so the state of deleted functions is dropped
+const functionIdleTime = 10 * time.Minute
+
+// transportPools keeps a connection pool per function, so a busy function can't
+// use the idle connections of another one and keep-alives are reused
+type transportPools struct {
+	dialer          *net.Dialer
+	maxIdleConns    int
+	idleConnTimeout time.Duration
+
+	mu    sync.Mutex
+	http1 map[string]*http.Transport
+	http2 map[string]http.RoundTripper
+	used  map[string]time.Time
+	swept time.Time
+}
+
+func newTransportPools(dialer *net.Dialer, maxIdleConns int, idleConnTimeout time.Duration) *transportPools {
+	return &transportPools{
+		dialer:          dialer,
+		maxIdleConns:    maxIdleConns,
+		idleConnTimeout: idleConnTimeout,
+		http1:           map[string]*http.Transport{},
+		http2:           map[string]http.RoundTripper{},
+		used:            map[string]time.Time{},
+	}
+}
+
+// get returns the transport of a function, HTTP/2 functions share a connection per endpoint
+func (p *transportPools) get(service string, useHTTP2 bool) http.RoundTripper {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(p.swept) > time.Minute {
+		p.sweep(now)
+	}
+	p.used[service] = now
+
+	if useHTTP2 {
+		transport, ok := p.http2[service]
+		if !ok {
+			transport = makeH2CTransport(p.dialer)
+			p.http2[service] = transport
+		}
+		return transport
+	}
+
+	transport, ok := p.http1[service]
+	if !ok {
+		transport = &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           p.dialer.DialContext,
+			MaxIdleConnsPerHost:   p.maxIdleConns,
+			IdleConnTimeout:       p.idleConnTimeout,
+			ExpectContinueTimeout: 1500 * time.Millisecond,
+			// the body is passed through as is, compressed or not
+			DisableCompression: true,
+		}
+		p.http1[service] = transport
+	}
+	return transport
+}
+
+// sweep closes and removes the pools of functions without recent requests, p.mu must be held.
+// Requests still using a removed transport carry on, its idle connections are closed.
+func (p *transportPools) sweep(now time.Time) {
+	p.swept = now
+	for service, used := range p.used {
+		if now.Sub(used) <= functionIdleTime {
+			continue
+		}
+		if transport, ok := p.http1[service]; ok {
+			transport.CloseIdleConnections()
+			delete(p.http1, service)
+		}
+		if transport, ok := p.http2[service]; ok {
+			if closer, ok := transport.(interface{ CloseIdleConnections() }); ok {
+				closer.CloseIdleConnections()
+			}
+			delete(p.http2, service)
+		}
+		delete(p.used, service)
+	}
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_transportPools_SweepsIdleFunctions(t *testing.T) {
+	pools := newTransportPools(&net.Dialer{}, 8, time.Minute)
+
+	first := pools.get("echo", false)
+	if pools.get("echo", false) != first {
+		t.Errorf("want the pool of the function reused")
+	}
+	pools.get("grpc", true)
+
+	pools.mu.Lock()
+	pools.sweep(time.Now().Add(functionIdleTime + time.Minute))
+	remaining := len(pools.http1) + len(pools.http2) + len(pools.used)
+	pools.mu.Unlock()
+
+	if remaining != 0 {
+		t.Errorf("want the pools of idle functions removed, %d left", remaining)
+	}
+	if pools.get("echo", false) == first {
+		t.Errorf("want a new pool after the sweep")
+	}
+}
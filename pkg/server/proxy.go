@@ -2,78 +2,496 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang/glog"
 	"github.com/gorilla/mux"
+	listers "github.com/openfaas-incubator/openfaas-operator/pkg/client/listers/openfaas/v1alpha2"
 	"github.com/openfaas/faas/gateway/requests"
 )
 
+const watchdogPort = 8080
+
+// hopHeaders are removed from requests and responses before they are forwarded,
+// they only apply to a single connection. See RFC 7230, section 6.1
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// proxyConfig holds the settings of the function proxy, the per-function
+// annotations take precedence
+type proxyConfig struct {
+	functionNamespace string
+	// timeout is the dial timeout
+	timeout time.Duration
+	// defaultTimeout is the deadline of functions without a timeout of their own and
+	// maxTimeout caps the timeout of every function, zero means no deadline or cap
+	defaultTimeout time.Duration
+	maxTimeout     time.Duration
+	// websocketIdleTimeout and websocketMaxLifetime apply to upgraded connections
+	websocketIdleTimeout time.Duration
+	websocketMaxLifetime time.Duration
+	// maxIdleConns and idleConnTimeout size the connection pool to each endpoint
+	maxIdleConns    int
+	idleConnTimeout time.Duration
+	// balancer picks the endpoint of a request, requests are sent to the
+	// function Service when it's nil
+	balancer *endpointBalancer
+	// retries is the number of times a failed request is retried
+	retries int
+	// invocations counts the invocations of each function for the function list
+	invocations *invocationCounts
+	// tracer records the spans of the invocations, tracing is off when it's nil
+	tracer *tracer
+	// functionAuth checks the credentials of functions that require invocation auth
+	functionAuth *functionAuth
+	// trustedProxies are the proxies whose X-Forwarded-For entries give the client IP
+	trustedProxies trustedProxies
+	// authenticators check the operator credentials of invocations when auth_invocations is
+	// set, functions annotated with com.openfaas.auth only take their own credentials
+	authenticators []authenticator
+	// compression is the response encoding of functions without a com.openfaas.compression annotation
+	compression string
+	// maxBodySize is the largest request body in bytes of functions without a
+	// com.openfaas.max-body-size annotation, zero means no limit
+	maxBodySize int64
+}
+
+// pick returns the endpoint a request to the function is sent to, the function Service when
+// there is no balancer. release must be called when the request is done.
+func (c proxyConfig) pick(service string, skip []*backend) (*backend, error) {
+	if c.balancer == nil {
+		return &backend{host: fmt.Sprintf("%s.%s", service, c.functionNamespace), port: watchdogPort}, nil
+	}
+	return c.balancer.pick(service, skip...)
+}
+
+func (c proxyConfig) release(picked *backend) {
+	if c.balancer != nil {
+		c.balancer.release(picked)
+	}
+}
+
+func (c proxyConfig) report(picked *backend, err error) {
+	if c.balancer != nil {
+		c.balancer.report(picked, err)
+	}
+}
+
 // makeProxy creates a proxy for HTTP web requests which can be routed to a function.
-func makeProxy(functionNamespace string, timeout time.Duration) http.HandlerFunc {
-	proxyClient := http.Client{
-		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			DialContext: (&net.Dialer{
-				Timeout:   timeout,
-				KeepAlive: 1 * time.Second,
-			}).DialContext,
-			IdleConnTimeout:       120 * time.Millisecond,
-			ExpectContinueTimeout: 1500 * time.Millisecond,
-		},
+// Every method is forwarded, the status code, headers and trailers of the function are
+// passed back to the caller and bodies are streamed in both directions. Upgrade requests
+// such as WebSockets are tunneled to the function. Functions annotated with the h2c or grpc
+// protocol are called over HTTP/2, which carries trailers and bidirectional streams.
+// Functions annotated with com.openfaas.max-inflight admit that many requests per ready
+// replica and queue the others, a full queue is answered with a 429.
+// Failed requests are retried on another replica when the connection could not be opened
+// or the method is idempotent. Requests that outlive the write timeout of the function are
+// answered with a 504, a 503 means no replica accepted the connection and a 502 that the
+// function closed the connection or sent an invalid response. Functions annotated with
+// com.openfaas.auth are only invoked with a valid API key or JWT, other functions with the
+// operator credentials when there are invocation authenticators, and functions with a rate
+// limit answer callers over it with a 429. Request bodies over the max body size of the function
+// are answered with a 413. Functions annotated with allowed CORS origins have their preflight
+// requests answered by the proxy, and responses are compressed for callers that accept gzip
+// when compression is on. Every invocation is traced with a span per call to the function,
+// which carries the trace context to the function.
+func makeProxy(config proxyConfig, lister listers.FunctionNamespaceLister) http.HandlerFunc {
+	dialer := &net.Dialer{
+		Timeout:   config.timeout,
+		KeepAlive: 30 * time.Second,
 	}
 
+	pools := newTransportPools(dialer, config.maxIdleConns, config.idleConnTimeout)
+	limits := newConcurrencyLimits()
+	rateLimits := newRateLimiters()
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Body != nil {
 			defer r.Body.Close()
 		}
 
-		switch r.Method {
-		case http.MethodPost,
-			http.MethodPut,
-			http.MethodPatch,
-			http.MethodDelete,
-			http.MethodGet:
+		vars := mux.Vars(r)
+		service := vars["name"]
 
-			vars := mux.Vars(r)
-			service := vars["name"]
+		defer func(when time.Time) {
+			seconds := time.Since(when).Seconds()
+			glog.V(2).Infof("%s took %f seconds", service, seconds)
+		}(time.Now())
 
-			defer func(when time.Time) {
-				seconds := time.Since(when).Seconds()
-				glog.V(2).Infof("%s took %f seconds", service, seconds)
-			}(time.Now())
+		function, err := lister.Get(service)
+		if err != nil {
+			// callers without credentials can't tell which functions exist
+			if !authenticate(config.authenticators, w, r) {
+				return
+			}
+			writeHead(service, http.StatusNotFound, w)
+			w.Write([]byte("Function not found: " + service))
+			return
+		}
+		annotations := functionAnnotations(function)
+
+		mw, done := instrumentInvocation(service, w, r, config.invocations)
+		defer done()
+		w = mw
 
-			forwardReq := requests.NewForwardRequest(r.Method, *r.URL)
+		invocation, r := config.tracer.startServer(r, "invoke "+service)
+		invocation.setAttribute("function_name", service)
+		invocation.setAttribute("call_id", r.Header.Get(callIDHeader))
+		defer func() {
+			invocation.setStatus(mw.status())
+			invocation.finish()
+		}()
+
+		if !isUpgrade(r) {
+			if policy, ok := functionCORS(annotations); ok {
+				// preflight requests carry no credentials, so they are answered before auth
+				if isPreflight(r) {
+					policy.preflight(w, r)
+					return
+				}
+				w = &corsWriter{ResponseWriter: w, policy: policy, origin: r.Header.Get("Origin")}
+			}
 
-			url := forwardReq.ToURL(fmt.Sprintf("%s.%s", service, functionNamespace), 8080)
+			if functionCompression(annotations, config.compression) == compressionGzip && acceptsEncoding(r, compressionGzip) {
+				gw := newGzipWriter(w, r, intAnnotation(annotations, annotationCompressionMinSize, defaultCompressionMinSize))
+				defer gw.close()
+				w = gw
+			}
+		}
 
-			request, _ := http.NewRequest(r.Method, url, r.Body)
+		// the operator credentials and the function credentials can both use the Authorization
+		// header, so functions with their own auth skip the operator authenticators
+		if len(annotations[annotationAuth]) == 0 && !authenticate(config.authenticators, w, r) {
+			return
+		}
+		if authErr := config.functionAuth.authorize(annotations, r); authErr != nil {
+			message := authErr.message
+			if authErr.status == http.StatusInternalServerError {
+				glog.Errorf("%s auth error: %s", service, authErr.message)
+				message = functionAuthMisconfigured
+			}
+			if authErr.status == http.StatusUnauthorized && annotations[annotationAuth] == functionAuthJWT {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s", error="invalid_token"`, service))
+			}
+			writeHead(service, authErr.status, w)
+			w.Write([]byte(message))
+			return
+		}
 
-			copyHeaders(&request.Header, &r.Header)
+		if limit, limited := functionRateLimit(function); limited {
+			caller, err := limit.callerKey(r, config.trustedProxies)
+			if err != nil {
+				glog.Warningf("%s: %s, using a global rate limit", service, err.Error())
+			}
 
-			defer request.Body.Close()
+			result := rateLimits.allow(service, limit, caller, time.Now())
+			result.setHeaders(w.Header())
+			if !result.allowed {
+				writeHead(service, http.StatusTooManyRequests, w)
+				w.Write([]byte("Rate limit exceeded for service: " + service))
+				return
+			}
+		}
 
-			response, err := proxyClient.Do(request)
+		// bodies of unknown length are cut off at the limit while they are forwarded
+		var limited *limitedBody
+		if limit := maxBodySize(annotations, config.maxBodySize); limit > 0 && r.ContentLength != 0 {
+			if r.ContentLength > limit {
+				writeHead(service, http.StatusRequestEntityTooLarge, w)
+				w.Write([]byte("Request body too large for service: " + service))
+				return
+			}
+			if r.ContentLength < 0 {
+				limited = newLimitedBody(r.Body, limit)
+				r.Body = limited
+			}
+		}
+
+		if maxInflight := intAnnotation(annotations, annotationMaxInflight, 0); maxInflight > 0 {
+			replicas := int(function.Status.AvailableReplicas)
+			if config.balancer != nil {
+				replicas = config.balancer.ready(service)
+			}
+			if replicas < 1 {
+				replicas = 1
+			}
+
+			maxWait := durationAnnotation(annotations, annotationQueueMaxWait, defaultQueueMaxWait)
+			queued := config.tracer.start(r.Context(), "queue", spanKindInternal)
+			err := limits.acquire(r.Context(), service, maxInflight*replicas,
+				intAnnotation(annotations, annotationQueueMaxLength, 0), maxWait)
+			if err != nil {
+				queued.setError(err.Error())
+			}
+			queued.finish()
+			if err != nil {
+				glog.V(2).Infof("%s rejected: %s", service, err.Error())
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(maxWait)))
+				writeHead(service, http.StatusTooManyRequests, w)
+				w.Write([]byte("Too many requests for service: " + service))
+				return
+			}
+			defer limits.release(service)
+		}
+
+		forwardReq := requests.NewForwardRequest(r.Method, *r.URL)
+
+		if isUpgrade(r) {
+			picked, err := config.pick(service, nil)
+			if err != nil {
+				glog.Errorf("%s error: %s", service, err.Error())
+				writeHead(service, http.StatusServiceUnavailable, w)
+				w.Write([]byte("No ready replicas for service: " + service))
+				return
+			}
+			defer config.release(picked)
+
+			proxyUpgrade(service, w, r, forwardReq.ToURL(picked.host, picked.port), dialer.Dial,
+				durationAnnotation(annotations, annotationWebsocketIdleTimeout, config.websocketIdleTimeout),
+				durationAnnotation(annotations, annotationWebsocketMaxLifetime, config.websocketMaxLifetime))
+			return
+		}
+
+		ctx := r.Context()
+		if timeout := config.deadline(function); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		in := r.WithContext(ctx)
+
+		// small bodies are buffered so the request can be sent again
+		retries := intAnnotation(annotations, annotationRetryAttempts, config.retries)
+		backoff := durationAnnotation(annotations, annotationRetryBackoff, defaultRetryBackoff)
+		var body []byte
+		if retries > 0 && r.ContentLength > 0 && r.ContentLength <= maxRetryBody {
+			if body, err = ioutil.ReadAll(r.Body); err != nil {
+				glog.Errorf("%s error: %s", service, err.Error())
+				writeHead(service, http.StatusBadRequest, w)
+				return
+			}
+		} else if r.ContentLength != 0 {
+			retries = 0
+		}
+
+		transport := pools.get(service, usesHTTP2(annotations))
+
+		tried := []*backend{}
+		for attempt := 0; ; attempt++ {
+			picked, err := config.pick(service, tried)
+			if err != nil {
+				glog.Errorf("%s error: %s", service, err.Error())
+				writeHead(service, http.StatusServiceUnavailable, w)
+				w.Write([]byte("No ready replicas for service: " + service))
+				return
+			}
+			tried = append(tried, picked)
 
+			if body != nil {
+				in.Body = ioutil.NopCloser(bytes.NewReader(body))
+			}
+			request, err := makeForwardRequest(in, forwardReq.ToURL(picked.host, picked.port))
 			if err != nil {
+				config.release(picked)
 				glog.Errorf("%s error: %s", service, err.Error())
-				writeHead(service, http.StatusInternalServerError, w)
-				buf := bytes.NewBufferString("Can't reach service: " + service)
-				w.Write(buf.Bytes())
+				writeHead(service, http.StatusBadRequest, w)
+				return
+			}
+
+			call := config.tracer.start(ctx, "call "+service, spanKindClient)
+			call.setAttribute("net.peer.name", picked.address())
+			call.setAttribute("retry.attempt", strconv.Itoa(attempt))
+			call.inject(request.Header)
+			request = traceConnection(request, call)
+
+			response, err := transport.RoundTrip(request)
+			if ctx.Err() == nil {
+				config.report(picked, err)
+			}
+			if err == nil {
+				defer config.release(picked)
+				defer response.Body.Close()
+				defer call.finish()
+				call.setStatus(response.StatusCode)
+
+				writeResponse(service, w, response)
 				return
 			}
+			config.release(picked)
+			call.setError(err.Error())
+			call.finish()
 
-			clientHeader := w.Header()
-			copyHeaders(&clientHeader, &response.Header)
+			if limited != nil && limited.tooLarge() {
+				glog.V(2).Infof("%s rejected: %s", service, errBodyTooLarge.Error())
+				writeHead(service, http.StatusRequestEntityTooLarge, w)
+				w.Write([]byte("Request body too large for service: " + service))
+				return
+			}
+
+			if attempt >= retries || ctx.Err() != nil || !canRetry(r.Method, err) {
+				status, message := errorStatus(ctx, err)
+				glog.Errorf("%s error: %s", service, err.Error())
+				writeHead(service, status, w)
+				w.Write([]byte(message + ": " + service))
+				return
+			}
+
+			glog.V(2).Infof("%s retrying after error: %s", service, err.Error())
+			sleepContext(ctx, backoffDelay(backoff, attempt))
+		}
+	}
+}
+
+// makeForwardRequest creates the request sent to the function from the caller request. The body
+// is streamed, hop-by-hop headers are removed and the X-Forwarded headers are set.
+func makeForwardRequest(r *http.Request, url string) (*http.Request, error) {
+	request, err := http.NewRequest(r.Method, url, r.Body)
+	if err != nil {
+		return nil, err
+	}
+	request = request.WithContext(r.Context())
+
+	request.ContentLength = r.ContentLength
+	if r.ContentLength == 0 {
+		request.Body = http.NoBody
+	}
+
+	copyHeaders(&request.Header, &r.Header)
+	removeHopHeaders(request.Header)
+
+	// trailers are only sent to the function when the caller accepts them, e.g. gRPC
+	if headerContains(r.Header, "Te", "trailers") {
+		request.Header.Set("Te", "trailers")
+	}
+	request.Trailer = r.Trailer
+
+	setForwardedHeaders(request, r)
+
+	return request, nil
+}
+
+// writeResponse writes the status code, headers, body and trailers of the function response.
+// Bodies of unknown length and event streams are flushed after every write.
+func writeResponse(service string, w http.ResponseWriter, response *http.Response) {
+	removeHopHeaders(response.Header)
+
+	clientHeader := w.Header()
+	copyHeaders(&clientHeader, &response.Header)
+
+	announced := map[string]bool{}
+	for name := range response.Trailer {
+		announced[name] = true
+		clientHeader.Add("Trailer", name)
+	}
 
-			writeHead(service, http.StatusOK, w)
-			io.Copy(w, response.Body)
+	writeHead(service, response.StatusCode, w)
+
+	flush := response.ContentLength == -1 ||
+		strings.HasPrefix(response.Header.Get("Content-Type"), "text/event-stream")
+	if err := copyBody(w, response.Body, flush); err != nil {
+		glog.V(2).Infof("%s response body error: %s", service, err.Error())
+	}
+
+	// trailers not announced before the body was written are sent with the TrailerPrefix
+	for name, values := range response.Trailer {
+		if announced[name] {
+			clientHeader[name] = values
+		} else {
+			clientHeader[http.TrailerPrefix+name] = values
+		}
+	}
+}
+
+// copyBody copies the body to the caller, flushing after every write when flush is true
+func copyBody(w http.ResponseWriter, body io.Reader, flush bool) error {
+	flusher, ok := w.(http.Flusher)
+	if !flush || !ok {
+		_, err := io.Copy(w, body)
+		return err
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, writeErr := w.Write(buf[:n]); writeErr != nil {
+				return writeErr
+			}
+			flusher.Flush()
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// setForwardedHeaders appends the caller address to X-Forwarded-For and sets X-Forwarded-Host
+// and X-Forwarded-Proto unless a proxy in front of the provider already did
+func setForwardedHeaders(request *http.Request, r *http.Request) {
+	if clientIP, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if prior := r.Header["X-Forwarded-For"]; len(prior) > 0 {
+			clientIP = strings.Join(prior, ", ") + ", " + clientIP
+		}
+		request.Header.Set("X-Forwarded-For", clientIP)
+	}
+
+	if len(request.Header.Get("X-Forwarded-Host")) == 0 {
+		request.Header.Set("X-Forwarded-Host", r.Host)
+	}
+
+	if len(request.Header.Get("X-Forwarded-Proto")) == 0 {
+		proto := "http"
+		if r.TLS != nil {
+			proto = "https"
+		}
+		request.Header.Set("X-Forwarded-Proto", proto)
+	}
+}
+
+// removeHopHeaders removes the hop-by-hop headers and the headers named in Connection
+func removeHopHeaders(header http.Header) {
+	for _, value := range header["Connection"] {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); len(name) > 0 {
+				header.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		header.Del(name)
+	}
+}
+
+// headerContains returns true when one of the comma separated values of the header is token
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(name)] {
+		for _, item := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(item), token) {
+				return true
+			}
 		}
 	}
+	return false
 }
 
 func writeHead(service string, code int, w http.ResponseWriter) {
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_makeForwardRequest_Headers(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "http://gateway:8080/function/echo", strings.NewReader("hello"))
+	r.RemoteAddr = "10.0.0.5:41234"
+	r.Header.Set("Connection", "keep-alive, X-Session")
+	r.Header.Set("X-Session", "abc")
+	r.Header.Set("Keep-Alive", "timeout=5")
+	r.Header.Set("X-Forwarded-For", "192.168.1.1")
+	r.Header.Set("Te", "trailers, deflate")
+	r.Header.Set("Content-Type", "text/plain")
+
+	request, err := makeForwardRequest(r, "http://echo.openfaas-fn:8080/function/echo")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, name := range []string{"Connection", "X-Session", "Keep-Alive"} {
+		if value := request.Header.Get(name); len(value) > 0 {
+			t.Errorf("want %s removed, got %s", name, value)
+		}
+	}
+	if got := request.Header.Get("Te"); got != "trailers" {
+		t.Errorf("want Te trailers, got %s", got)
+	}
+	if got := request.Header.Get("X-Forwarded-For"); got != "192.168.1.1, 10.0.0.5" {
+		t.Errorf("want X-Forwarded-For appended, got %s", got)
+	}
+	if got := request.Header.Get("X-Forwarded-Host"); got != "gateway:8080" {
+		t.Errorf("want X-Forwarded-Host gateway:8080, got %s", got)
+	}
+	if got := request.Header.Get("X-Forwarded-Proto"); got != "http" {
+		t.Errorf("want X-Forwarded-Proto http, got %s", got)
+	}
+	if got := request.Header.Get("Content-Type"); got != "text/plain" {
+		t.Errorf("want Content-Type passed through, got %s", got)
+	}
+	if request.ContentLength != 5 {
+		t.Errorf("want content length 5, got %d", request.ContentLength)
+	}
+}
+
+func Test_writeResponse_PassesThrough(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Connection", "close")
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte(`{"error":"teapot"}`))
+		w.Header().Set("X-Checksum", "123")
+	}))
+	defer upstream.Close()
+
+	response, err := http.Get(upstream.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer response.Body.Close()
+
+	recorder := httptest.NewRecorder()
+	writeResponse("echo", recorder, response)
+
+	if recorder.Code != http.StatusTeapot {
+		t.Errorf("want status %d, got %d", http.StatusTeapot, recorder.Code)
+	}
+	if got := recorder.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("want Content-Type application/json, got %s", got)
+	}
+	if got := recorder.Header().Get("Connection"); len(got) > 0 {
+		t.Errorf("want Connection removed, got %s", got)
+	}
+	body, _ := ioutil.ReadAll(recorder.Body)
+	if string(body) != `{"error":"teapot"}` {
+		t.Errorf("want body passed through, got %s", string(body))
+	}
+	if got := recorder.Result().Trailer.Get("X-Checksum"); got != "123" {
+		t.Errorf("want trailer X-Checksum 123, got %s", got)
+	}
+}
@@ -0,0 +1,280 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// annotationRateLimitRPS, annotationRateLimitBurst and annotationRateLimitKey set the rate
+	// limit of functions deployed through the gateway, the rateLimit of the spec takes precedence
+	annotationRateLimitRPS   = "com.openfaas.rate-limit.rps"
+	annotationRateLimitBurst = "com.openfaas.rate-limit.burst"
+	annotationRateLimitKey   = "com.openfaas.rate-limit.key"
+
+	rateLimitKeyGlobal = "global"
+	rateLimitKeyIP     = "ip"
+	rateLimitKeyAPIKey = "api-key"
+	rateLimitKeyHeader = "header:"
+
+	// rateLimitIdleTime is how long the bucket of a caller is kept without requests
+	rateLimitIdleTime = 10 * time.Minute
+	// rateLimitMaxCallers caps the buckets of a function, callers beyond it share a bucket
+	rateLimitMaxCallers = 10000
+	rateLimitOverflow   = "overflow"
+)
+
+// rateLimit is the rate limit of a function
+type rateLimit struct {
+	rate  float64
+	burst int
+	key   string
+}
+
+// functionRateLimit reads the rate limit of a function from the spec or else the annotations,
+// false is returned when the function isn't limited
+func functionRateLimit(function *faasv1.Function) (rateLimit, bool) {
+	annotations := functionAnnotations(function)
+	limit := rateLimit{key: annotations[annotationRateLimitKey]}
+
+	if spec := function.Spec.RateLimit; spec != nil {
+		limit.rate = float64(spec.RequestsPerSecond.MilliValue()) / 1000
+		limit.burst = int(spec.Burst)
+		limit.key = spec.Key
+	} else if val, ok := annotations[annotationRateLimitRPS]; ok {
+		quantity, err := resource.ParseQuantity(val)
+		if err != nil {
+			glog.Warningf("Invalid %s annotation: %s", annotationRateLimitRPS, val)
+			return limit, false
+		}
+		limit.rate = float64(quantity.MilliValue()) / 1000
+		limit.burst = intAnnotation(annotations, annotationRateLimitBurst, 0)
+	}
+
+	if limit.rate <= 0 {
+		return limit, false
+	}
+	if limit.burst <= 0 {
+		limit.burst = int(math.Ceil(limit.rate))
+	}
+	if len(limit.key) == 0 {
+		limit.key = rateLimitKeyGlobal
+	}
+	return limit, true
+}
+
+// callerKey returns the bucket of the caller for the key of the limit
+func (l rateLimit) callerKey(r *http.Request, proxies trustedProxies) (string, error) {
+	switch {
+	case l.key == rateLimitKeyGlobal:
+		return "", nil
+	case l.key == rateLimitKeyIP:
+		return proxies.clientIP(r), nil
+	case l.key == rateLimitKeyAPIKey:
+		// only the name of a key verified by the function auth, keys sent by the caller
+		// could be new on every request
+		if name := r.Header.Get(apiKeyNameHeader); len(name) > 0 {
+			return "key:" + name, nil
+		}
+		return proxies.clientIP(r), nil
+	case strings.HasPrefix(l.key, rateLimitKeyHeader):
+		name := strings.TrimPrefix(l.key, rateLimitKeyHeader)
+		return "header:" + r.Header.Get(name), nil
+	}
+	return "", fmt.Errorf("rate limit key '%s' is not supported", l.key)
+}
+
+// trustedProxies are the networks of the proxies in front of the provider, such as the
+// gateway, whose X-Forwarded-For entries are trusted
+type trustedProxies []*net.IPNet
+
+// parseTrustedProxies parses a list of CIDRs or IP addresses
+func parseTrustedProxies(items []string) (trustedProxies, error) {
+	proxies := trustedProxies{}
+	for _, item := range items {
+		if !strings.Contains(item, "/") {
+			if ip := net.ParseIP(item); ip != nil && ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy '%s' is not a CIDR or IP address", item)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+func (p trustedProxies) trusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range p {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP is the remote address of the request. When the request comes from a trusted
+// proxy it's the last X-Forwarded-For entry that wasn't added by a trusted proxy, the
+// entries before it can be set by the caller.
+func (p trustedProxies) clientIP(r *http.Request) string {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ip = host
+	}
+
+	if !p.trusted(ip) {
+		return ip
+	}
+	entries := []string{}
+	for _, header := range r.Header["X-Forwarded-For"] {
+		entries = append(entries, strings.Split(header, ",")...)
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := strings.TrimSpace(entries[i])
+		if net.ParseIP(entry) == nil {
+			break
+		}
+		ip = entry
+		if !p.trusted(entry) {
+			break
+		}
+	}
+	return ip
+}
+
+// tokenBucket holds up to burst tokens and gains rate tokens a second, every request takes one.
+// It's used over golang.org/x/time/rate since the remaining tokens are reported to the caller.
+type tokenBucket struct {
+	tokens   float64
+	last     time.Time
+	lastSeen time.Time
+}
+
+// take takes a token from the bucket and returns the tokens left, or how long
+// the caller has to wait for a token
+func (b *tokenBucket) take(limit rateLimit, now time.Time) (bool, float64, time.Duration) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(float64(limit.burst), b.tokens+elapsed*limit.rate)
+		b.last = now
+	}
+	b.lastSeen = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, b.tokens, 0
+	}
+	wait := time.Duration((1 - b.tokens) / limit.rate * float64(time.Second))
+	return false, b.tokens, wait
+}
+
+// rateLimiters holds the token buckets of the functions. The limit of a function is read on
+// every request, so changes to the Function apply to the next request.
+type rateLimiters struct {
+	mu        sync.Mutex
+	functions map[string]*functionBuckets
+	swept     time.Time
+}
+
+type functionBuckets struct {
+	limit   rateLimit
+	buckets map[string]*tokenBucket
+}
+
+// rateLimitResult is the outcome of a request and the values of the X-RateLimit headers
+type rateLimitResult struct {
+	allowed   bool
+	limit     int
+	remaining int
+	reset     time.Duration
+	retry     time.Duration
+}
+
+func newRateLimiters() *rateLimiters {
+	return &rateLimiters{functions: map[string]*functionBuckets{}}
+}
+
+// allow takes a token from the bucket of the caller
+func (l *rateLimiters) allow(service string, limit rateLimit, caller string, now time.Time) rateLimitResult {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.swept) > time.Minute {
+		l.sweep(now)
+	}
+
+	fb, ok := l.functions[service]
+	if !ok || fb.limit.key != limit.key {
+		fb = &functionBuckets{buckets: map[string]*tokenBucket{}}
+		l.functions[service] = fb
+	}
+	// a new rate or burst applies to the tokens already in the buckets
+	fb.limit = limit
+
+	bucket, ok := fb.buckets[caller]
+	if !ok && len(fb.buckets) >= rateLimitMaxCallers {
+		// callers can pick their keys, so the buckets are capped
+		caller = rateLimitOverflow
+		bucket, ok = fb.buckets[caller]
+	}
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(limit.burst), last: now}
+		fb.buckets[caller] = bucket
+	}
+
+	allowed, tokens, wait := bucket.take(limit, now)
+	return rateLimitResult{
+		allowed:   allowed,
+		limit:     limit.burst,
+		remaining: int(math.Floor(tokens)),
+		reset:     time.Duration((float64(limit.burst) - tokens) / limit.rate * float64(time.Second)),
+		retry:     wait,
+	}
+}
+
+// sweep removes the buckets of callers without recent requests, l.mu must be held
+func (l *rateLimiters) sweep(now time.Time) {
+	l.swept = now
+	for service, fb := range l.functions {
+		for caller, bucket := range fb.buckets {
+			if now.Sub(bucket.lastSeen) > rateLimitIdleTime {
+				delete(fb.buckets, caller)
+			}
+		}
+		if len(fb.buckets) == 0 {
+			delete(l.functions, service)
+		}
+	}
+}
+
+// setHeaders sets the X-RateLimit headers and the Retry-After of a rejected request
+func (r rateLimitResult) setHeaders(header http.Header) {
+	header.Set("X-RateLimit-Limit", strconv.Itoa(r.limit))
+	header.Set("X-RateLimit-Remaining", strconv.Itoa(r.remaining))
+	header.Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(r.reset)))
+	if !r.allowed {
+		header.Set("Retry-After", strconv.Itoa(ceilSeconds(r.retry)))
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
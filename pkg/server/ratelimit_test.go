@@ -0,0 +1,170 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func Test_functionRateLimit(t *testing.T) {
+	function := &faasv1.Function{
+		Spec: faasv1.FunctionSpec{
+			Name:        "testfunc",
+			Annotations: &map[string]string{annotationRateLimitRPS: "5", annotationRateLimitKey: "ip"},
+		},
+	}
+
+	limit, ok := functionRateLimit(function)
+	if !ok || limit.rate != 5 || limit.burst != 5 || limit.key != rateLimitKeyIP {
+		t.Errorf("want 5 rps with burst 5 by ip from annotations, got %v", limit)
+	}
+
+	function.Spec.RateLimit = &faasv1.FunctionRateLimit{RequestsPerSecond: resource.MustParse("500m"), Burst: 3}
+	limit, ok = functionRateLimit(function)
+	if !ok || limit.rate != 0.5 || limit.burst != 3 || limit.key != rateLimitKeyGlobal {
+		t.Errorf("want 0.5 rps with burst 3 from the spec, got %v", limit)
+	}
+
+	if _, ok := functionRateLimit(&faasv1.Function{Spec: faasv1.FunctionSpec{Name: "testfunc"}}); ok {
+		t.Errorf("want no limit")
+	}
+}
+
+func Test_rateLimiters_Burst(t *testing.T) {
+	limiters := newRateLimiters()
+	limit := rateLimit{rate: 1, burst: 2, key: rateLimitKeyGlobal}
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if result := limiters.allow("testfunc", limit, "", now); !result.allowed || result.remaining != 1-i {
+			t.Errorf("request %d: want allowed with %d remaining, got %v", i, 1-i, result)
+		}
+	}
+
+	result := limiters.allow("testfunc", limit, "", now)
+	if result.allowed || result.retry != time.Second {
+		t.Errorf("want rejected for a second, got %v", result)
+	}
+
+	header := http.Header{}
+	result.setHeaders(header)
+	if header.Get("X-RateLimit-Limit") != "2" || header.Get("X-RateLimit-Remaining") != "0" ||
+		header.Get("X-RateLimit-Reset") != "2" || header.Get("Retry-After") != "1" {
+		t.Errorf("unexpected headers %v", header)
+	}
+
+	if result := limiters.allow("testfunc", limit, "", now.Add(time.Second)); !result.allowed {
+		t.Errorf("want allowed after a second, got %v", result)
+	}
+
+	// a higher rate applies to the next request
+	faster := rateLimit{rate: 10, burst: 2, key: rateLimitKeyGlobal}
+	if result := limiters.allow("testfunc", faster, "", now.Add(1100*time.Millisecond)); !result.allowed {
+		t.Errorf("want allowed at the new rate, got %v", result)
+	}
+}
+
+func Test_rateLimiters_ByCaller(t *testing.T) {
+	limiters := newRateLimiters()
+	limit := rateLimit{rate: 1, burst: 1, key: rateLimitKeyIP}
+	now := time.Now()
+
+	if !limiters.allow("testfunc", limit, "10.0.0.1", now).allowed || !limiters.allow("testfunc", limit, "10.0.0.2", now).allowed {
+		t.Errorf("want a bucket per caller")
+	}
+	if limiters.allow("testfunc", limit, "10.0.0.1", now).allowed {
+		t.Errorf("want the second request of the caller rejected")
+	}
+
+	limiters.allow("testfunc", limit, "10.0.0.3", now.Add(rateLimitIdleTime+time.Minute))
+	if buckets := len(limiters.functions["testfunc"].buckets); buckets != 1 {
+		t.Errorf("want idle buckets removed, got %d", buckets)
+	}
+}
+
+func Test_rateLimit_callerKey(t *testing.T) {
+	proxies, _ := parseTrustedProxies([]string{"10.0.0.0/8"})
+
+	r := httptest.NewRequest(http.MethodGet, "/function/testfunc", nil)
+	r.RemoteAddr = "10.0.0.1:4000"
+	r.Header.Set("X-Forwarded-For", "203.0.113.9, 192.0.2.7")
+	r.Header.Set(apiKeyNameHeader, "partner-a")
+	r.Header.Set("X-Tenant", "acme")
+
+	cases := map[string]string{
+		rateLimitKeyGlobal: "",
+		rateLimitKeyIP:     "192.0.2.7",
+		rateLimitKeyAPIKey: "key:partner-a",
+		"header:X-Tenant":  "header:acme",
+	}
+	for key, want := range cases {
+		if got, err := (rateLimit{key: key}).callerKey(r, proxies); err != nil || got != want {
+			t.Errorf("%s: want %q, got %q %v", key, want, got, err)
+		}
+	}
+
+	if _, err := (rateLimit{key: "cookie"}).callerKey(r, proxies); err == nil {
+		t.Errorf("want error for an unknown key")
+	}
+
+	// keys that weren't verified by the function auth are ignored
+	r.Header.Del(apiKeyNameHeader)
+	r.Header.Set(defaultAPIKeyHeader, "random-key")
+	if got, _ := (rateLimit{key: rateLimitKeyAPIKey}).callerKey(r, proxies); got != "192.0.2.7" {
+		t.Errorf("want the client IP for an unverified key, got %q", got)
+	}
+}
+
+func Test_trustedProxies_clientIP(t *testing.T) {
+	proxies, err := parseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.7"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := parseTrustedProxies([]string{"gateway"}); err == nil {
+		t.Errorf("want error for an invalid proxy")
+	}
+
+	cases := []struct {
+		name      string
+		remote    string
+		forwarded string
+		want      string
+	}{
+		{"direct caller", "203.0.113.9:4000", "", "203.0.113.9"},
+		{"spoofed header from an untrusted caller", "203.0.113.9:4000", "198.51.100.1", "203.0.113.9"},
+		{"trusted proxy", "10.0.0.1:4000", "198.51.100.1, 203.0.113.9", "203.0.113.9"},
+		{"chain of trusted proxies", "10.0.0.1:4000", "203.0.113.9, 192.0.2.7", "203.0.113.9"},
+		{"trusted proxy without header", "10.0.0.1:4000", "", "10.0.0.1"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/function/testfunc", nil)
+		r.RemoteAddr = c.remote
+		if len(c.forwarded) > 0 {
+			r.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		if got := proxies.clientIP(r); got != c.want {
+			t.Errorf("%s: want %s, got %s", c.name, c.want, got)
+		}
+	}
+}
+
+func Test_rateLimiters_CapsCallers(t *testing.T) {
+	limiters := newRateLimiters()
+	limit := rateLimit{rate: 1, burst: 1, key: "header:X-Tenant"}
+	now := time.Now()
+
+	for i := 0; i < rateLimitMaxCallers+10; i++ {
+		limiters.allow("testfunc", limit, fmt.Sprintf("header:%d", i), now)
+	}
+	if buckets := len(limiters.functions["testfunc"].buckets); buckets != rateLimitMaxCallers+1 {
+		t.Errorf("want %d buckets, got %d", rateLimitMaxCallers+1, buckets)
+	}
+	if limiters.allow("testfunc", limit, "header:new", now).allowed {
+		t.Errorf("want new callers to share the overflow bucket")
+	}
+}
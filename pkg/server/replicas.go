@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 
 	"github.com/golang/glog"
 	"github.com/gorilla/mux"
@@ -15,7 +16,7 @@ import (
 	"k8s.io/client-go/listers/apps/v1beta2"
 )
 
-func makeReplicaReader(namespace string, client clientset.Interface, kube kubernetes.Interface, lister v1beta2.DeploymentNamespaceLister) http.HandlerFunc {
+func makeReplicaReader(namespace string, client clientset.Interface, kube kubernetes.Interface, lister v1beta2.DeploymentNamespaceLister, invocations *invocationCounts) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		functionName := vars["name"]
@@ -40,6 +41,7 @@ func makeReplicaReader(namespace string, client clientset.Interface, kube kubern
 			Name:              k8sfunc.Spec.Name,
 			EnvProcess:        k8sfunc.Spec.Handler,
 			Image:             k8sfunc.Spec.Image,
+			InvocationCount:   invocations.get(k8sfunc.Spec.Name),
 		}
 
 		res, _ := json.Marshal(result)
@@ -84,6 +86,10 @@ func makeReplicaHandler(namespace string, client clientset.Interface) http.Handl
 			return
 		}
 
+		scaled := spanFromContext(r.Context())
+		scaled.setAttribute("function_name", functionName)
+		scaled.setAttribute("replicas", strconv.FormatUint(req.Replicas, 10))
+
 		k8sfunc.Spec.Replicas = int32p(int32(req.Replicas))
 		_, err = client.OpenfaasV1alpha2().Functions(namespace).Update(k8sfunc)
 		if err != nil {
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// annotationRetryAttempts is the number of times a failed request to the function is retried
+	annotationRetryAttempts = "com.openfaas.retry.attempts"
+	// annotationRetryBackoff is the delay before the first retry, it doubles for every retry
+	annotationRetryBackoff = "com.openfaas.retry.backoff"
+
+	defaultRetryBackoff = 50 * time.Millisecond
+
+	// maxRetryBody is the largest request body buffered so the request can be retried,
+	// requests with larger or streamed bodies are sent once
+	maxRetryBody = 64 * 1024
+)
+
+// canRetry returns true when a request that failed with err can be sent again. Requests
+// that never reached the function are always retried, others only when the method is idempotent.
+func canRetry(method string, err error) bool {
+	if isConnectError(err) {
+		return true
+	}
+
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// isConnectError returns true when the connection to the function could not be opened
+func isConnectError(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	opErr, ok := err.(*net.OpError)
+	return ok && opErr.Op == "dial"
+}
+
+// errorStatus maps a failed request to the status returned to the caller: 504 when the function
+// timed out, 503 when no replica accepted the connection and 502 when the function closed the
+// connection or sent an invalid response
+func errorStatus(ctx context.Context, err error) (int, string) {
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		return http.StatusGatewayTimeout, "Function timed out"
+	case isConnectError(err):
+		return http.StatusServiceUnavailable, "Can't reach service"
+	default:
+		return http.StatusBadGateway, "Bad response from service"
+	}
+}
+
+// backoffDelay returns the jittered delay before a retry, between half and all of
+// the base delay doubled for every previous retry
+func backoffDelay(base time.Duration, attempt int) time.Duration {
+	delay := base << uint(attempt)
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// sleepContext waits for the delay or until the context is done
+func sleepContext(ctx context.Context, delay time.Duration) {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
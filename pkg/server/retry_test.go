@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
+	listers "github.com/openfaas-incubator/openfaas-operator/pkg/client/listers/openfaas/v1alpha2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// closedPort returns a local port nothing listens on
+func closedPort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	return port
+}
+
+func Test_isConnectError(t *testing.T) {
+	request, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1:"+strconv.Itoa(closedPort(t)), nil)
+	_, err := http.DefaultTransport.RoundTrip(request)
+	if err == nil || !isConnectError(err) {
+		t.Errorf("want connect error, got %v", err)
+	}
+
+	status, _ := errorStatus(context.Background(), err)
+	if status != http.StatusServiceUnavailable {
+		t.Errorf("want %d, got %d", http.StatusServiceUnavailable, status)
+	}
+}
+
+func Test_errorStatus_Timeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	<-ctx.Done()
+
+	if status, _ := errorStatus(ctx, context.DeadlineExceeded); status != http.StatusGatewayTimeout {
+		t.Errorf("want %d, got %d", http.StatusGatewayTimeout, status)
+	}
+	if status, _ := errorStatus(context.Background(), net.ErrWriteToConnected); status != http.StatusBadGateway {
+		t.Errorf("want %d, got %d", http.StatusBadGateway, status)
+	}
+}
+
+func Test_canRetry(t *testing.T) {
+	dialErr := &net.OpError{Op: "dial", Err: net.ErrWriteToConnected}
+	readErr := &net.OpError{Op: "read", Err: net.ErrWriteToConnected}
+
+	if !canRetry(http.MethodPost, dialErr) {
+		t.Errorf("want POST retried when the connection failed")
+	}
+	if canRetry(http.MethodPost, readErr) {
+		t.Errorf("want POST not retried after it was sent")
+	}
+	if !canRetry(http.MethodGet, readErr) {
+		t.Errorf("want GET retried after it was sent")
+	}
+}
+
+func Test_backoffDelay(t *testing.T) {
+	for attempt := 0; attempt < 4; attempt++ {
+		max := 100 * time.Millisecond << uint(attempt)
+		if delay := backoffDelay(100*time.Millisecond, attempt); delay < max/2 || delay > max {
+			t.Errorf("attempt %d: want delay between %s and %s, got %s", attempt, max/2, max, delay)
+		}
+	}
+}
+
+func Test_makeProxy_RetriesOnAnotherEndpoint(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer upstream.Close()
+	upstreamPort := upstream.Listener.Addr().(*net.TCPAddr).Port
+
+	endpoints := &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "echo", Namespace: "openfaas-fn"},
+		Subsets: []corev1.EndpointSubset{
+			{
+				Addresses: []corev1.EndpointAddress{{IP: "127.0.0.1"}},
+				Ports:     []corev1.EndpointPort{{Port: int32(closedPort(t))}},
+			},
+			{
+				Addresses: []corev1.EndpointAddress{{IP: "127.0.0.1"}},
+				Ports:     []corev1.EndpointPort{{Port: int32(upstreamPort)}},
+			},
+		},
+	}
+	balancer := newTestBalancer(t, leastConnections, endpoints)
+	balancer.ejectAfter = 1
+	balancer.ejectFor = time.Minute
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	indexer.Add(&faasv1.Function{
+		ObjectMeta: metav1.ObjectMeta{Name: "echo", Namespace: "openfaas-fn"},
+		Spec:       faasv1.FunctionSpec{Name: "echo"},
+	})
+
+	proxy := makeProxy(proxyConfig{
+		functionNamespace: "openfaas-fn",
+		timeout:           time.Second,
+		balancer:          balancer,
+		retries:           1,
+	}, listers.NewFunctionLister(indexer).Functions("openfaas-fn"))
+
+	// every request is answered, requests that hit the closed port are retried
+	for i := 0; i < 4; i++ {
+		r := httptest.NewRequest(http.MethodPost, "/function/echo", strings.NewReader("hello"))
+		r = mux.SetURLVars(r, map[string]string{"name": "echo"})
+		w := httptest.NewRecorder()
+
+		proxy(w, r)
+
+		if w.Code != http.StatusOK || w.Body.String() != "hello" {
+			t.Errorf("request %d: want 200 hello, got %d %q", i, w.Code, w.Body.String())
+		}
+	}
+}
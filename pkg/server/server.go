@@ -5,15 +5,19 @@ import (
 	_ "net/http/pprof"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang/glog"
 	clientset "github.com/openfaas-incubator/openfaas-operator/pkg/client/clientset/versioned"
+	informers "github.com/openfaas-incubator/openfaas-operator/pkg/client/informers/externalversions"
 	"github.com/openfaas/faas-provider"
 	"github.com/openfaas/faas-provider/types"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/apimachinery/pkg/api/resource"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
 )
 
 // TODO: Move to config pattern used else-where across project
@@ -21,9 +25,25 @@ import (
 const defaultHTTPPort = 8081
 const defaultReadTimeout = 8
 const defaultWriteTimeout = 8
+const defaultMaxFunctionTimeout = 300
+
+// writeTimeoutGrace leaves the proxy time to answer with a 504 before the server
+// stops writing responses
+const writeTimeoutGrace = 5 * time.Second
+const defaultWebsocketIdleTimeout = 60
+const defaultWebsocketMaxLifetime = 3600
+const defaultMaxIdleConns = 32
+const defaultIdleConnTimeout = 90
+const defaultRetries = 2
+const defaultEjectFailures = 5
+const defaultEjectTime = 30
+const defaultTracingEndpoint = "http://localhost:4318/v1/traces"
+const defaultTracingSampleRate = 1.0
+const defaultBasicAuthSecretPath = "/var/secrets/"
+const defaultAccessLogSampleRate = 1.0
 
 // Start starts HTTP Server for API
-func Start(client clientset.Interface, kube kubernetes.Interface, kubeInformerFactory kubeinformers.SharedInformerFactory) {
+func Start(client clientset.Interface, kube kubernetes.Interface, kubeInformerFactory kubeinformers.SharedInformerFactory, faasInformerFactory informers.SharedInformerFactory, stopCh <-chan struct{}) {
 	functionNamespace := "openfaas-fn"
 	if namespace, exists := os.LookupEnv("function_namespace"); exists {
 		functionNamespace = namespace
@@ -53,6 +73,182 @@ func Start(client clientset.Interface, kube kubernetes.Interface, kubeInformerFa
 		}
 	}
 
+	// the longest function timeout, function timeouts above it are lowered to it
+	maxFunctionTimeout := defaultMaxFunctionTimeout
+	if val, exists := os.LookupEnv("max_function_timeout"); exists {
+		parsedVal, parseErr := strconv.Atoi(val)
+		if parseErr == nil && parsedVal > 0 {
+			maxFunctionTimeout = parsedVal
+		}
+	}
+	if maxFunctionTimeout < writeTimeout {
+		maxFunctionTimeout = writeTimeout
+	}
+
+	websocketIdleTimeout := defaultWebsocketIdleTimeout
+	if val, exists := os.LookupEnv("websocket_idle_timeout"); exists {
+		parsedVal, parseErr := strconv.Atoi(val)
+		if parseErr == nil && parsedVal >= 0 {
+			websocketIdleTimeout = parsedVal
+		}
+	}
+
+	websocketMaxLifetime := defaultWebsocketMaxLifetime
+	if val, exists := os.LookupEnv("websocket_max_lifetime"); exists {
+		parsedVal, parseErr := strconv.Atoi(val)
+		if parseErr == nil && parsedVal >= 0 {
+			websocketMaxLifetime = parsedVal
+		}
+	}
+
+	maxIdleConns := defaultMaxIdleConns
+	if val, exists := os.LookupEnv("proxy_max_idle_conns"); exists {
+		parsedVal, parseErr := strconv.Atoi(val)
+		if parseErr == nil && parsedVal > 0 {
+			maxIdleConns = parsedVal
+		}
+	}
+
+	idleConnTimeout := defaultIdleConnTimeout
+	if val, exists := os.LookupEnv("proxy_idle_conn_timeout"); exists {
+		parsedVal, parseErr := strconv.Atoi(val)
+		if parseErr == nil && parsedVal > 0 {
+			idleConnTimeout = parsedVal
+		}
+	}
+
+	retries := defaultRetries
+	if val, exists := os.LookupEnv("proxy_retries"); exists {
+		parsedVal, parseErr := strconv.Atoi(val)
+		if parseErr == nil && parsedVal >= 0 {
+			retries = parsedVal
+		}
+	}
+
+	// consecutive failures that eject an endpoint from the balancer, 0 disables ejection
+	ejectFailures := defaultEjectFailures
+	if val, exists := os.LookupEnv("proxy_eject_failures"); exists {
+		parsedVal, parseErr := strconv.Atoi(val)
+		if parseErr == nil && parsedVal >= 0 {
+			ejectFailures = parsedVal
+		}
+	}
+
+	ejectTime := defaultEjectTime
+	if val, exists := os.LookupEnv("proxy_eject_time"); exists {
+		parsedVal, parseErr := strconv.Atoi(val)
+		if parseErr == nil && parsedVal > 0 {
+			ejectTime = parsedVal
+		}
+	}
+
+	// none, otlp to send spans to tracing_endpoint or log
+	tracingExporter := tracingExporterNone
+	if val, exists := os.LookupEnv("tracing_exporter"); exists {
+		tracingExporter = val
+	}
+
+	tracingEndpoint := defaultTracingEndpoint
+	if val, exists := os.LookupEnv("tracing_endpoint"); exists {
+		tracingEndpoint = val
+	}
+
+	// the share of new traces that are sampled, traces started by the caller keep their decision
+	tracingSampleRate := defaultTracingSampleRate
+	if val, exists := os.LookupEnv("tracing_sample_rate"); exists {
+		parsedVal, parseErr := strconv.ParseFloat(val, 64)
+		if parseErr == nil && parsedVal >= 0 && parsedVal <= 1 {
+			tracingSampleRate = parsedVal
+		}
+	}
+
+	// basic, token or both to authenticate the management routes, they are open when empty
+	auth := ""
+	if val, exists := os.LookupEnv("auth"); exists {
+		auth = val
+	}
+
+	basicAuthSecretPath := defaultBasicAuthSecretPath
+	if val, exists := os.LookupEnv("basic_auth_secret_path"); exists {
+		basicAuthSecretPath = val
+	}
+
+	// the users allowed to call with a bearer token, any authenticated user when empty
+	tokenReviewUsers := []string{}
+	if val, exists := os.LookupEnv("token_review_users"); exists && len(val) > 0 {
+		tokenReviewUsers = strings.Split(val, ",")
+	}
+
+	authInvocations := false
+	if val, exists := os.LookupEnv("auth_invocations"); exists {
+		authInvocations = val == "true"
+	}
+
+	// a JWKS file or URL with the keys of the JWTs of functions with com.openfaas.auth=jwt
+	jwksSource := ""
+	if val, exists := os.LookupEnv("jwks"); exists {
+		jwksSource = val
+	}
+
+	// gzip to compress the responses of functions without a com.openfaas.compression annotation
+	compression := compressionNone
+	if val, exists := os.LookupEnv("proxy_compression"); exists {
+		compression = val
+	}
+
+	// the largest request body of functions without a com.openfaas.max-body-size annotation,
+	// in bytes or as a quantity such as 10Mi, zero means no limit
+	maxBodySize := int64(0)
+	if val, exists := os.LookupEnv("proxy_max_body_size"); exists {
+		parsedVal, parseErr := resource.ParseQuantity(val)
+		if parseErr == nil && parsedVal.Sign() >= 0 {
+			maxBodySize = parsedVal.Value()
+		}
+	}
+
+	// the CIDRs of the proxies in front of the provider, such as the gateway pods, whose
+	// X-Forwarded-For entries are trusted for the client IP
+	trustedProxyList := []string{}
+	if val, exists := os.LookupEnv("trusted_proxies"); exists && len(val) > 0 {
+		for _, item := range strings.Split(val, ",") {
+			if item = strings.TrimSpace(item); len(item) > 0 {
+				trustedProxyList = append(trustedProxyList, item)
+			}
+		}
+	}
+
+	// json or clf to log every invocation to stdout, none turns the access log off
+	accessLogFormat := accessLogNone
+	if val, exists := os.LookupEnv("access_log"); exists {
+		accessLogFormat = val
+	}
+
+	// the share of successful invocations that are logged, errors are always logged
+	accessLogSampleRate := defaultAccessLogSampleRate
+	if val, exists := os.LookupEnv("access_log_sample_rate"); exists {
+		parsedVal, parseErr := strconv.ParseFloat(val, 64)
+		if parseErr == nil && parsedVal >= 0 && parsedVal <= 1 {
+			accessLogSampleRate = parsedVal
+		}
+	}
+
+	// the fields and query.<name> parameters left out of the access log
+	accessLogRedact := []string{}
+	if val, exists := os.LookupEnv("access_log_redact"); exists && len(val) > 0 {
+		accessLogRedact = strings.Split(val, ",")
+	}
+
+	// least-connections, p2c or service to use the ClusterIP of the function Service
+	loadBalancer := leastConnections
+	if val, exists := os.LookupEnv("load_balancer"); exists {
+		loadBalancer = val
+	}
+
+	enableH2C := false
+	if val, exists := os.LookupEnv("enable_h2c"); exists {
+		enableH2C = val == "true"
+	}
+
 	pprof := "false"
 	if val, exists := os.LookupEnv("pprof"); exists {
 		pprof = val
@@ -61,21 +257,104 @@ func Start(client clientset.Interface, kube kubernetes.Interface, kubeInformerFa
 	deploymentInformer := kubeInformerFactory.Apps().V1beta2().Deployments()
 	deploymentLister := deploymentInformer.Lister().Deployments(functionNamespace)
 
+	functionLister := faasInformerFactory.Openfaas().V1alpha2().Functions().Lister().Functions(functionNamespace)
+
+	proxyConfig := proxyConfig{
+		functionNamespace:    functionNamespace,
+		timeout:              time.Duration(readTimeout) * time.Second,
+		defaultTimeout:       time.Duration(writeTimeout) * time.Second,
+		maxTimeout:           time.Duration(maxFunctionTimeout) * time.Second,
+		websocketIdleTimeout: time.Duration(websocketIdleTimeout) * time.Second,
+		websocketMaxLifetime: time.Duration(websocketMaxLifetime) * time.Second,
+		maxIdleConns:         maxIdleConns,
+		idleConnTimeout:      time.Duration(idleConnTimeout) * time.Second,
+		retries:              retries,
+		invocations:          newInvocationCounts(),
+		compression:          compression,
+		maxBodySize:          maxBodySize,
+	}
+
+	if compression != compressionNone && compression != compressionGzip {
+		glog.Fatalf("Invalid proxy_compression configured: %s", compression)
+	}
+
+	trustedProxies, err := parseTrustedProxies(trustedProxyList)
+	if err != nil {
+		glog.Fatalf("Invalid trusted_proxies configured: %s", err.Error())
+	}
+	proxyConfig.trustedProxies = trustedProxies
+
+	authenticators, err := newAuthenticators(auth, basicAuthSecretPath, kube, tokenReviewUsers)
+	if err != nil {
+		glog.Fatalf("Invalid auth configured: %s", err.Error())
+	}
+	if authInvocations {
+		proxyConfig.authenticators = authenticators
+	}
+
+	accessLogger, err := newAccessLogger(accessLogFormat, accessLogSampleRate, accessLogRedact, os.Stdout)
+	if err != nil {
+		glog.Fatalf("Invalid access log configured: %s", err.Error())
+	}
+	if accessLogger != nil {
+		accessLogger.proxies = trustedProxies
+	}
+
+	exporter, err := newSpanExporter(tracingExporter, tracingEndpoint)
+	if err != nil {
+		glog.Fatalf("Invalid tracing_exporter configured: %s", err.Error())
+	}
+	if exporter != nil {
+		proxyConfig.tracer = newTracer(exporter, tracingSampleRate)
+		go proxyConfig.tracer.run(stopCh)
+	}
+	tracer := proxyConfig.tracer
+
+	secretsInformer := kubeInformerFactory.Core().V1().Secrets()
+	proxyConfig.functionAuth = &functionAuth{secrets: secretsInformer.Lister().Secrets(functionNamespace)}
+	if len(jwksSource) > 0 {
+		proxyConfig.functionAuth.keys = newJWKS(jwksSource)
+	}
+
+	synced := []cache.InformerSynced{secretsInformer.Informer().HasSynced}
+	if loadBalancer != "service" {
+		endpointsInformer := kubeInformerFactory.Core().V1().Endpoints()
+		balancer, err := newEndpointBalancer(endpointsInformer.Lister().Endpoints(functionNamespace), loadBalancer)
+		if err != nil {
+			glog.Fatalf("Invalid load_balancer configured: %s", err.Error())
+		}
+		balancer.ejectAfter = ejectFailures
+		balancer.ejectFor = time.Duration(ejectTime) * time.Second
+		proxyConfig.balancer = balancer
+		synced = append(synced, endpointsInformer.Informer().HasSynced)
+	}
+
+	// start the informers requested by the server
+	kubeInformerFactory.Start(stopCh)
+	faasInformerFactory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, synced...) {
+		glog.Fatalf("Failed to wait for the endpoints and secrets caches to sync")
+	}
+
+	// every route but the health check requires auth when it's configured, the
+	// invocation routes only when auth_invocations is set and the function has no
+	// auth of its own. Invocations are logged before auth, so rejected callers show
+	// up in the access log.
 	bootstrapHandlers := types.FaaSHandlers{
-		FunctionProxy:  makeProxy(functionNamespace, time.Duration(readTimeout)*time.Second),
-		DeleteHandler:  makeDeleteHandler(functionNamespace, client),
-		DeployHandler:  makeApplyHandler(functionNamespace, client),
-		FunctionReader: makeListHandler(functionNamespace, client, kube, deploymentLister),
-		ReplicaReader:  makeReplicaReader(functionNamespace, client, kube, deploymentLister),
-		ReplicaUpdater: makeReplicaHandler(functionNamespace, client),
-		UpdateHandler:  makeApplyHandler(functionNamespace, client),
+		FunctionProxy:  accessLog(accessLogger, makeProxy(proxyConfig, functionLister)),
+		DeleteHandler:  requireAuth(authenticators, traceHandler(tracer, "delete", makeDeleteHandler(functionNamespace, client))),
+		DeployHandler:  requireAuth(authenticators, traceHandler(tracer, "deploy", makeApplyHandler(functionNamespace, client))),
+		FunctionReader: requireAuth(authenticators, makeListHandler(functionNamespace, client, kube, deploymentLister, proxyConfig.invocations)),
+		ReplicaReader:  requireAuth(authenticators, makeReplicaReader(functionNamespace, client, kube, deploymentLister, proxyConfig.invocations)),
+		ReplicaUpdater: requireAuth(authenticators, traceHandler(tracer, "scale", makeReplicaHandler(functionNamespace, client))),
+		UpdateHandler:  requireAuth(authenticators, traceHandler(tracer, "update", makeApplyHandler(functionNamespace, client))),
 		Health:         makeHealthHandler(),
-		InfoHandler:    makeInfoHandler(),
+		InfoHandler:    requireAuth(authenticators, makeInfoHandler()),
 	}
 
 	bootstrapConfig := types.FaaSConfig{
 		ReadTimeout:  time.Duration(readTimeout) * time.Second,
-		WriteTimeout: time.Duration(writeTimeout) * time.Second,
+		WriteTimeout: serverWriteTimeout(proxyConfig.maxTimeout),
 		TCPPort:      &port,
 		EnableHealth: true,
 	}
@@ -86,7 +365,20 @@ func Start(client clientset.Interface, kube kubernetes.Interface, kubeInformerFa
 
 	bootstrap.Router().Path("/metrics").Handler(promhttp.Handler())
 
+	if enableH2C {
+		// the HTTP/2 preface is a request for "*", which must reach the router as is
+		bootstrap.Router().SkipClean(true)
+		grpcRouter := makeGRPCRouter(bootstrap.Router(), bootstrapHandlers.FunctionProxy)
+		bootstrap.Router().Methods("PRI").Handler(makeH2CHandler(grpcRouter))
+	}
+
 	glog.Infof("Using namespace '%s'", functionNamespace)
 	glog.Infof("Starting HTTP server on port %v", port)
 	bootstrap.Serve(&bootstrapHandlers, &bootstrapConfig)
 }
+
+// serverWriteTimeout is the write timeout of the server, it outlasts the longest function
+// timeout so the proxy enforces the deadline of each invocation and answers with a 504
+func serverWriteTimeout(maxFunctionTimeout time.Duration) time.Duration {
+	return maxFunctionTimeout + writeTimeoutGrace
+}
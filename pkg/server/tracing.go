@@ -0,0 +1,382 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanKindClient   = 3
+
+	// maxQueuedSpans is the number of finished spans buffered for the exporter,
+	// spans finished while the buffer is full are dropped
+	maxQueuedSpans = 2048
+	// exportBatchSize and exportInterval control how often spans are exported
+	exportBatchSize = 512
+	exportInterval  = 5 * time.Second
+)
+
+// spanContext identifies a span and is propagated to the functions
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+}
+
+// span is a timed operation of a trace, every method is a no-op on a nil span
+// so tracing can be disabled by using a nil tracer
+type span struct {
+	tracer   *tracer
+	name     string
+	kind     int
+	context  spanContext
+	parentID [8]byte
+	start    time.Time
+
+	mu         sync.Mutex
+	end        time.Time
+	attributes map[string]string
+	errMessage string
+}
+
+type spanKey struct{}
+
+// spanFromContext returns the span stored in the context or nil
+func spanFromContext(ctx context.Context) *span {
+	s, _ := ctx.Value(spanKey{}).(*span)
+	return s
+}
+
+func (s *span) setAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attributes[key] = value
+	s.mu.Unlock()
+}
+
+func (s *span) setError(message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.errMessage = message
+	s.mu.Unlock()
+}
+
+// setStatus records the HTTP status code of the span, 5xx codes mark the span as failed
+func (s *span) setStatus(code int) {
+	s.setAttribute("http.status_code", strconv.Itoa(code))
+	if code >= http.StatusInternalServerError {
+		s.setError(http.StatusText(code))
+	}
+}
+
+func (s *span) finish() {
+	s.finishAt(time.Now())
+}
+
+// finishAt ends the span and queues it for the exporter when it's sampled
+func (s *span) finishAt(end time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.end = end
+	s.mu.Unlock()
+
+	if s.context.sampled {
+		s.tracer.queue(s)
+	}
+}
+
+// inject sets the W3C traceparent and B3 headers of the span on an outgoing request
+func (s *span) inject(header http.Header) {
+	if s == nil {
+		return
+	}
+	sc := s.context
+
+	flags := "00"
+	sampled := "0"
+	if sc.sampled {
+		flags, sampled = "01", "1"
+	}
+	header.Set("traceparent", fmt.Sprintf("00-%x-%x-%s", sc.traceID, sc.spanID, flags))
+
+	header.Del("b3")
+	header.Set("X-B3-TraceId", hex.EncodeToString(sc.traceID[:]))
+	header.Set("X-B3-SpanId", hex.EncodeToString(sc.spanID[:]))
+	header.Set("X-B3-Sampled", sampled)
+	header.Del("X-B3-ParentSpanId")
+	if s.parentID != ([8]byte{}) {
+		header.Set("X-B3-ParentSpanId", hex.EncodeToString(s.parentID[:]))
+	}
+	header.Del("X-B3-Flags")
+}
+
+// tracer starts spans and queues the sampled ones for the exporter
+type tracer struct {
+	sampleRate float64
+	exporter   spanExporter
+	spans      chan *span
+}
+
+// spanExporter sends finished spans to a collector
+type spanExporter interface {
+	export(spans []*span) error
+}
+
+// newTracer creates a tracer that samples new traces at sampleRate, between 0 and 1.
+// Traces started by the caller keep their sampling decision.
+func newTracer(exporter spanExporter, sampleRate float64) *tracer {
+	return &tracer{
+		sampleRate: sampleRate,
+		exporter:   exporter,
+		spans:      make(chan *span, maxQueuedSpans),
+	}
+}
+
+// startServer starts a server span for the request, continuing the trace of the caller when
+// the request carries a traceparent or B3 context. The returned request carries the span.
+func (t *tracer) startServer(r *http.Request, name string) (*span, *http.Request) {
+	if t == nil {
+		return nil, r
+	}
+
+	parent, ok := extractSpanContext(r.Header)
+	if !ok {
+		parent = spanContext{traceID: newTraceID(), sampled: t.sample()}
+	}
+
+	s := t.newSpan(name, spanKindServer, parent)
+	s.setAttribute("http.method", r.Method)
+	s.setAttribute("http.target", r.URL.Path)
+	return s, r.WithContext(context.WithValue(r.Context(), spanKey{}, s))
+}
+
+// start starts a child of the span in the context, or a new trace when there is none
+func (t *tracer) start(ctx context.Context, name string, kind int) *span {
+	if t == nil {
+		return nil
+	}
+	return t.startAt(ctx, name, kind, time.Now())
+}
+
+func (t *tracer) startAt(ctx context.Context, name string, kind int, start time.Time) *span {
+	if t == nil {
+		return nil
+	}
+
+	parent := spanContext{traceID: newTraceID(), sampled: t.sample()}
+	if p := spanFromContext(ctx); p != nil {
+		parent = p.context
+	}
+
+	s := t.newSpan(name, kind, parent)
+	s.start = start
+	return s
+}
+
+func (t *tracer) newSpan(name string, kind int, parent spanContext) *span {
+	s := &span{
+		tracer:     t,
+		name:       name,
+		kind:       kind,
+		parentID:   parent.spanID,
+		start:      time.Now(),
+		attributes: map[string]string{},
+	}
+	s.context = spanContext{traceID: parent.traceID, spanID: newSpanID(), sampled: parent.sampled}
+	return s
+}
+
+func (t *tracer) sample() bool {
+	if t.sampleRate >= 1 {
+		return true
+	}
+	var b [8]byte
+	rand.Read(b[:])
+	n := uint64(0)
+	for _, v := range b {
+		n = n<<8 | uint64(v)
+	}
+	return float64(n>>11)/float64(1<<53) < t.sampleRate
+}
+
+func (t *tracer) queue(s *span) {
+	select {
+	case t.spans <- s:
+	default:
+		glog.V(2).Infof("Dropping span %s, the export queue is full", s.name)
+	}
+}
+
+// run exports the queued spans in batches until stopCh is closed
+func (t *tracer) run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	batch := []*span{}
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.exporter.export(batch); err != nil {
+			glog.Errorf("Error exporting %d spans: %s", len(batch), err.Error())
+		}
+		batch = []*span{}
+	}
+
+	for {
+		select {
+		case s := <-t.spans:
+			batch = append(batch, s)
+			if len(batch) >= exportBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-stopCh:
+			flush()
+			return
+		}
+	}
+}
+
+// traceConnection records the time a request waits for a connection to the function as a child
+// of the call span, including dials and connections reused from the pool
+func traceConnection(request *http.Request, call *span) *http.Request {
+	if call == nil {
+		return request
+	}
+
+	var getConn time.Time
+	trace := &httptrace.ClientTrace{
+		GetConn: func(hostPort string) {
+			getConn = time.Now()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			if getConn.IsZero() {
+				return
+			}
+			ctx := context.WithValue(request.Context(), spanKey{}, call)
+			connect := call.tracer.startAt(ctx, "connect", spanKindInternal, getConn)
+			connect.setAttribute("net.conn.reused", strconv.FormatBool(info.Reused))
+			connect.finish()
+		},
+	}
+	return request.WithContext(httptrace.WithClientTrace(request.Context(), trace))
+}
+
+// extractSpanContext reads the caller span from the W3C traceparent header or else the B3 headers
+func extractSpanContext(header http.Header) (spanContext, bool) {
+	if sc, ok := parseTraceparent(header.Get("traceparent")); ok {
+		return sc, true
+	}
+	if b3 := header.Get("b3"); len(b3) > 0 {
+		parts := strings.Split(b3, "-")
+		if len(parts) < 2 {
+			return spanContext{}, false
+		}
+		sampled := ""
+		if len(parts) > 2 {
+			sampled = parts[2]
+		}
+		return parseB3(parts[0], parts[1], sampled, "")
+	}
+	if traceID := header.Get("X-B3-TraceId"); len(traceID) > 0 {
+		return parseB3(traceID, header.Get("X-B3-SpanId"), header.Get("X-B3-Sampled"), header.Get("X-B3-Flags"))
+	}
+	return spanContext{}, false
+}
+
+// parseTraceparent parses a version 00 traceparent: 00-<trace id>-<parent id>-<flags>
+func parseTraceparent(value string) (spanContext, bool) {
+	sc := spanContext{}
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[3]) != 2 {
+		return sc, false
+	}
+	if !decodeID(sc.traceID[:], parts[1]) || !decodeID(sc.spanID[:], parts[2]) {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, false
+	}
+	sc.sampled = flags[0]&1 == 1
+	return sc, true
+}
+
+// parseB3 parses the B3 trace and span ids, 64 bit trace ids are left padded with zeros.
+// A missing sampling decision is sampled, the tracer doesn't defer decisions.
+func parseB3(traceID, spanID, sampled, flags string) (spanContext, bool) {
+	sc := spanContext{}
+	if len(traceID) == 16 {
+		traceID = strings.Repeat("0", 16) + traceID
+	}
+	if !decodeID(sc.traceID[:], traceID) || !decodeID(sc.spanID[:], spanID) {
+		return sc, false
+	}
+	sc.sampled = (sampled != "0" && sampled != "false") || flags == "1"
+	return sc, true
+}
+
+// decodeID decodes a lowercase hex id of exactly len(id) bytes that isn't all zeros
+func decodeID(id []byte, value string) bool {
+	if len(value) != 2*len(id) || strings.ToLower(value) != value {
+		return false
+	}
+	if _, err := hex.Decode(id, []byte(value)); err != nil {
+		return false
+	}
+	for _, b := range id {
+		if b != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func newTraceID() [16]byte {
+	var id [16]byte
+	rand.Read(id[:])
+	return id
+}
+
+func newSpanID() [8]byte {
+	var id [8]byte
+	rand.Read(id[:])
+	return id
+}
+
+// traceHandler wraps a management handler with a server span
+func traceHandler(t *tracer, name string, next http.HandlerFunc) http.HandlerFunc {
+	if t == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, r := t.startServer(r, name)
+		mw := &metricsWriter{ResponseWriter: w}
+		defer func() {
+			s.setStatus(mw.status())
+			s.finish()
+		}()
+
+		next(mw, r)
+	}
+}
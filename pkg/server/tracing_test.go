@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	faasv1 "github.com/openfaas-incubator/openfaas-operator/pkg/apis/openfaas/v1alpha2"
+	listers "github.com/openfaas-incubator/openfaas-operator/pkg/client/listers/openfaas/v1alpha2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func Test_extractSpanContext(t *testing.T) {
+	cases := []struct {
+		name    string
+		header  http.Header
+		traceID string
+		spanID  string
+		sampled bool
+	}{
+		{
+			name:    "traceparent",
+			header:  http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}},
+			traceID: "4bf92f3577b34da6a3ce929d0e0e4736", spanID: "00f067aa0ba902b7", sampled: true,
+		},
+		{
+			name:    "b3 single",
+			header:  http.Header{"B3": {"80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-0"}},
+			traceID: "80f198ee56343ba864fe8b2a57d3eff7", spanID: "e457b5a2e4d86bd1", sampled: false,
+		},
+		{
+			name: "b3 multi with 64 bit trace id",
+			header: http.Header{
+				"X-B3-Traceid": {"a3ce929d0e0e4736"},
+				"X-B3-Spanid":  {"00f067aa0ba902b7"},
+				"X-B3-Sampled": {"1"},
+			},
+			traceID: "0000000000000000a3ce929d0e0e4736", spanID: "00f067aa0ba902b7", sampled: true,
+		},
+	}
+
+	for _, c := range cases {
+		sc, ok := extractSpanContext(c.header)
+		if !ok {
+			t.Errorf("%s: want span context", c.name)
+			continue
+		}
+		if hex.EncodeToString(sc.traceID[:]) != c.traceID || hex.EncodeToString(sc.spanID[:]) != c.spanID || sc.sampled != c.sampled {
+			t.Errorf("%s: want %s %s %v, got %x %x %v", c.name, c.traceID, c.spanID, c.sampled, sc.traceID, sc.spanID, sc.sampled)
+		}
+	}
+}
+
+func Test_extractSpanContext_Invalid(t *testing.T) {
+	values := []string{
+		"",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+	}
+
+	for _, value := range values {
+		if _, ok := extractSpanContext(http.Header{"Traceparent": {value}}); ok {
+			t.Errorf("want %q rejected", value)
+		}
+	}
+}
+
+func Test_tracer_Sampling(t *testing.T) {
+	never := newTracer(nil, 0)
+
+	r := httptest.NewRequest(http.MethodGet, "/function/echo", nil)
+	if s, _ := never.startServer(r, "invoke echo"); s.context.sampled {
+		t.Errorf("want new trace not sampled")
+	}
+
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	s, r := never.startServer(r, "invoke echo")
+	if !s.context.sampled {
+		t.Errorf("want the sampling decision of the caller")
+	}
+
+	child := never.start(r.Context(), "call echo", spanKindClient)
+	if child.context.traceID != s.context.traceID || child.parentID != s.context.spanID {
+		t.Errorf("want child of %x, got parent %x", s.context.spanID, child.parentID)
+	}
+}
+
+func Test_makeProxy_Tracing(t *testing.T) {
+	propagated := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		propagated <- r.Header.Get("traceparent")
+	}))
+	defer upstream.Close()
+
+	endpoints := &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "echo", Namespace: "openfaas-fn"},
+		Subsets: []corev1.EndpointSubset{{
+			Addresses: []corev1.EndpointAddress{{IP: "127.0.0.1"}},
+			Ports:     []corev1.EndpointPort{{Port: int32(upstream.Listener.Addr().(*net.TCPAddr).Port)}},
+		}},
+	}
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	indexer.Add(&faasv1.Function{
+		ObjectMeta: metav1.ObjectMeta{Name: "echo", Namespace: "openfaas-fn"},
+		Spec:       faasv1.FunctionSpec{Name: "echo"},
+	})
+
+	tracer := newTracer(nil, 1)
+	proxy := makeProxy(proxyConfig{
+		functionNamespace: "openfaas-fn",
+		timeout:           time.Second,
+		balancer:          newTestBalancer(t, leastConnections, endpoints),
+		tracer:            tracer,
+	}, listers.NewFunctionLister(indexer).Functions("openfaas-fn"))
+
+	r := httptest.NewRequest(http.MethodGet, "/function/echo", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r = mux.SetURLVars(r, map[string]string{"name": "echo"})
+	proxy(httptest.NewRecorder(), r)
+
+	traceparent := <-propagated
+	if !strings.HasPrefix(traceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-") || strings.Contains(traceparent, "00f067aa0ba902b7") {
+		t.Errorf("want the trace continued with a new span, got %s", traceparent)
+	}
+
+	names := map[string]*span{}
+	for len(tracer.spans) > 0 {
+		s := <-tracer.spans
+		names[s.name] = s
+	}
+	for _, name := range []string{"invoke echo", "call echo", "connect"} {
+		if _, ok := names[name]; !ok {
+			t.Errorf("want span %s, got %v", name, names)
+		}
+	}
+	if call, invoke := names["call echo"], names["invoke echo"]; call != nil && invoke != nil && call.parentID != invoke.context.spanID {
+		t.Errorf("want call span child of the invocation")
+	}
+}
+
+func Test_otlpExporter(t *testing.T) {
+	received := make(chan map[string]interface{}, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		payload := map[string]interface{}{}
+		json.Unmarshal(body, &payload)
+		received <- payload
+	}))
+	defer collector.Close()
+
+	exporter, err := newSpanExporter(tracingExporterOTLP, collector.URL+"/v1/traces")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	s := newTracer(exporter, 1).start(context.Background(), "deploy", spanKindServer)
+	s.setStatus(http.StatusInternalServerError)
+	s.end = s.start.Add(time.Second)
+	if err := exporter.export([]*span{s}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	payload := <-received
+	spans := payload["resourceSpans"].([]interface{})[0].(map[string]interface{})["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+	exported := spans[0].(map[string]interface{})
+	if exported["name"] != "deploy" || exported["traceId"] != hex.EncodeToString(s.context.traceID[:]) {
+		t.Errorf("unexpected span %v", exported)
+	}
+	if status := exported["status"].(map[string]interface{}); status["code"] != float64(2) {
+		t.Errorf("want error status, got %v", status)
+	}
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// annotationWebsocketIdleTimeout closes an upgraded connection without traffic in either direction
+	annotationWebsocketIdleTimeout = "com.openfaas.websocket.idle-timeout"
+	// annotationWebsocketMaxLifetime closes an upgraded connection after a fixed time
+	annotationWebsocketMaxLifetime = "com.openfaas.websocket.max-lifetime"
+)
+
+// isUpgrade returns true for HTTP/1.1 upgrade requests such as WebSocket handshakes
+func isUpgrade(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") && len(r.Header.Get("Upgrade")) > 0
+}
+
+// proxyUpgrade forwards an upgrade request to the function. When the function switches
+// protocols both connections are hijacked and bytes are copied in both directions until
+// one side closes, the connection is idle for longer than idleTimeout or maxLifetime is over.
+func proxyUpgrade(service string, w http.ResponseWriter, r *http.Request, url string, dial func(network, addr string) (net.Conn, error), idleTimeout, maxLifetime time.Duration) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		writeHead(service, http.StatusInternalServerError, w)
+		return
+	}
+
+	request, err := makeForwardRequest(r, url)
+	if err != nil {
+		writeHead(service, http.StatusBadRequest, w)
+		return
+	}
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Upgrade", r.Header.Get("Upgrade"))
+
+	upstream, err := dial("tcp", request.URL.Host)
+	if err != nil {
+		// the same status as a request the proxy could not send
+		status, message := errorStatus(r.Context(), err)
+		glog.Errorf("%s error: %s", service, err.Error())
+		writeHead(service, status, w)
+		w.Write([]byte(message + ": " + service))
+		return
+	}
+	defer upstream.Close()
+
+	if err := request.Write(upstream); err != nil {
+		glog.Errorf("%s upgrade error: %s", service, err.Error())
+		writeHead(service, http.StatusBadGateway, w)
+		return
+	}
+
+	upstreamReader := bufio.NewReader(upstream)
+	response, err := http.ReadResponse(upstreamReader, request)
+	if err != nil {
+		glog.Errorf("%s upgrade error: %s", service, err.Error())
+		writeHead(service, http.StatusBadGateway, w)
+		return
+	}
+
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		defer response.Body.Close()
+		writeResponse(service, w, response)
+		return
+	}
+
+	client, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		glog.Errorf("%s hijack error: %s", service, err.Error())
+		return
+	}
+	defer client.Close()
+
+	// the server deadlines set for the handshake don't apply to the upgraded connection
+	client.SetDeadline(time.Time{})
+
+	fmt.Fprintf(clientBuf, "HTTP/1.1 %s\r\n", response.Status)
+	response.Header.Write(clientBuf)
+	clientBuf.WriteString("\r\n")
+	if err := clientBuf.Flush(); err != nil {
+		return
+	}
+
+	websocketConnections.WithLabelValues(service).Inc()
+	websocketConnectionsTotal.WithLabelValues(service).Inc()
+	defer websocketConnections.WithLabelValues(service).Dec()
+
+	t := &tunnel{client: client, upstream: upstream, idleTimeout: idleTimeout}
+	if maxLifetime > 0 {
+		timer := time.AfterFunc(maxLifetime, t.close)
+		defer timer.Stop()
+	}
+	t.run(clientBuf.Reader, upstreamReader)
+}
+
+// tunnel copies bytes between two hijacked connections
+type tunnel struct {
+	client      net.Conn
+	upstream    net.Conn
+	idleTimeout time.Duration
+	closeOnce   sync.Once
+}
+
+func (t *tunnel) run(clientReader, upstreamReader io.Reader) {
+	t.touch()
+
+	done := make(chan struct{}, 2)
+	go t.copy(t.upstream, clientReader, done)
+	go t.copy(t.client, upstreamReader, done)
+
+	// the first side to finish closes both connections, which ends the other copy
+	<-done
+	t.close()
+	<-done
+}
+
+func (t *tunnel) copy(dst io.Writer, src io.Reader, done chan<- struct{}) {
+	defer func() { done <- struct{}{} }()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			t.touch()
+			if _, writeErr := dst.Write(buf[:n]); writeErr != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// touch moves the idle deadline of both connections, traffic in either direction
+// keeps the tunnel open
+func (t *tunnel) touch() {
+	if t.idleTimeout <= 0 {
+		return
+	}
+	deadline := time.Now().Add(t.idleTimeout)
+	t.client.SetDeadline(deadline)
+	t.upstream.SetDeadline(deadline)
+}
+
+func (t *tunnel) close() {
+	t.closeOnce.Do(func() {
+		t.client.Close()
+		t.upstream.Close()
+	})
+}